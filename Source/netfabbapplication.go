@@ -83,6 +83,18 @@ func StorageSessionGetHashedSalt (UserID string) (string, string, error) {
 }
 
 
+func checkAdministratorSession (session * NetStorageSession) error {
+
+	for j := 0; j < len(GlobalConfig.Authentication.Administrators); j++ {
+		if (GlobalConfig.Authentication.Administrators[j].UserID == session.UserID) {
+			return nil;
+		}
+	}
+	
+	return errors.New ("administrator rights required");
+}
+
+
 func StorageSessionNew (w http.ResponseWriter, r *http.Request) error {
 	
 	// Parse JSON request
@@ -375,6 +387,58 @@ func startAppServer (ConfigFileName string, runBlocking bool) (error) {
 	session := createEmptySession ();
 	
 	addLogMessage(&session, fmt.Sprintf("Logging to %s..", dbfilename), LOGTYPE_SYSTEM, LOGLEVEL_CONSOLE);
+
+	// Create missing storage tables
+	db, err := OpenDB (GlobalConfig.Database.Type, GlobalConfig.Database.FileName);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = initStorageSchema (db);
+	db.Close ();
+	if (err != nil) {
+		return err;
+	}
+
+	StorageBackend, err = createStorageBackend (GlobalConfig.Data);
+	if (err != nil) {
+		return err;
+	}
+	addLogMessage(&session, fmt.Sprintf("Using %s data backend..", GlobalConfig.Data.Backend), LOGTYPE_SYSTEM, LOGLEVEL_CONSOLE);
+	
+	GlobalConfig.Data.Compression, err = checkBlobCompression (GlobalConfig.Data.Compression);
+	if (err != nil) {
+		return err;
+	}
+	if (GlobalConfig.Data.Compression != BLOBCOMPRESSION_NONE) {
+		addLogMessage(&session, fmt.Sprintf("Compressing new blobs with %s..", GlobalConfig.Data.Compression), LOGTYPE_SYSTEM, LOGLEVEL_CONSOLE);
+	}
+	
+	if (GlobalConfig.Changes.RetentionDays > 0) {
+		go runChangePruner (GlobalConfig.Changes);
+		addLogMessage(&session, fmt.Sprintf("Keeping changes for %d days..", GlobalConfig.Changes.RetentionDays), LOGTYPE_SYSTEM, LOGLEVEL_CONSOLE);
+	}
+	
+	if (GlobalConfig.GarbageCollection.IntervalMinutes > 0) {
+		go runGarbageCollector (GlobalConfig.GarbageCollection);
+		addLogMessage(&session, fmt.Sprintf("Collecting garbage every %d minutes..", GlobalConfig.GarbageCollection.IntervalMinutes), LOGTYPE_SYSTEM, LOGLEVEL_CONSOLE);
+	}
+
+	if (GlobalConfig.Scrub.IntervalMinutes > 0) {
+		go runIntegrityScrubber (GlobalConfig.Scrub);
+		addLogMessage(&session, fmt.Sprintf("Scrubbing storage every %d minutes..", GlobalConfig.Scrub.IntervalMinutes), LOGTYPE_SYSTEM, LOGLEVEL_CONSOLE);
+	}
+	
+	if (GlobalConfig.Analysis.IntervalSeconds > 0) {
+		go runEntityAnalyzer (GlobalConfig.Analysis);
+		addLogMessage(&session, "Analyzing uploaded entities in the background..", LOGTYPE_SYSTEM, LOGLEVEL_CONSOLE);
+	}
+	
+	if (GlobalConfig.Conversion.IntervalSeconds > 0) {
+		go runMeshConverter (GlobalConfig.Conversion);
+		addLogMessage(&session, fmt.Sprintf("Converting meshes every %d seconds..", GlobalConfig.Conversion.IntervalSeconds), LOGTYPE_SYSTEM, LOGLEVEL_CONSOLE);
+	}
+	
 	addLogMessage(&session, fmt.Sprintf("Listening on host %s, port %d..", host, port), LOGTYPE_SYSTEM, LOGLEVEL_CONSOLE);
     
 	http.Handle ("/", makeHandler (RESTHandler));
@@ -0,0 +1,840 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_3mf.go
+// 3MF package inspection and writing. A 3MF file is an OPC zip package, whose root relationships 
+// point to the 3D model part and an optional package thumbnail. The model part is read as an XML 
+// stream, so that large meshes are only counted and never held in memory.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+
+const RELATIONSHIPTYPE_3DMODEL = "http://schemas.microsoft.com/3dmanufacturing/2013/01/3dmodel";
+const RELATIONSHIPTYPE_THUMBNAIL = "http://schemas.openxmlformats.org/package/2006/relationships/metadata/thumbnail";
+
+const PACKAGE3MF_DEFAULTUNIT = "millimeter";
+const PACKAGE3MF_MAXTHUMBNAILSIZE = 16 * 1024 * 1024;
+const PACKAGE3MF_MAXCOMPONENTDEPTH = 32;
+
+// Components can reference objects many times, this limits the triangles and component instances of 
+// the expanded build
+const PACKAGE3MF_MAXEXPANDEDTRIANGLES = 20000000;
+
+const PACKAGE3MF_NAMESPACE = "http://schemas.microsoft.com/3dmanufacturing/core/2015/02";
+const PACKAGE3MF_MODELPART = "3D/3dmodel.model";
+
+var Package3MFUnits = []string { "micron", "millimeter", "centimeter", "inch", "foot", "meter" };
+
+var Package3MFIdentityTransform = NetStorage3MFTransform { 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0 };
+
+// The meshes of a model part are held in memory until the build is expanded, this limits the vertices 
+// and triangles of all objects that are read from a model part
+var Package3MFMeshLimits = NetStorage3MFMeshLimits { 10000000, 20000000 };
+
+
+type NetStorageOPCRelationship struct {
+	Target string `xml:"Target,attr"`
+	Type string `xml:"Type,attr"`
+}
+
+type NetStorageOPCRelationships struct {
+	XMLName xml.Name `xml:"Relationships"`
+	Relationships []NetStorageOPCRelationship `xml:"Relationship"`
+}
+
+// An affine transform in 3MF notation: a 3x3 matrix in row order followed by the translation
+type NetStorage3MFTransform [12]float64
+
+type NetStorage3MFComponent struct {
+	ObjectID string
+	Transform NetStorage3MFTransform
+}
+
+type NetStorage3MFMesh struct {
+	Vertices [][3]float32
+	Triangles [][3]int
+	Components []NetStorage3MFComponent
+}
+
+type NetStorage3MFMeshLimits struct {
+	MaxVertices int
+	MaxTriangles int
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// NetStorageSeekReaderAt
+// provides random access to a stored file for the zip reader. Storage backends only offer seekable 
+// readers, so reads are serialized.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type NetStorageSeekReaderAt struct {
+	Reader io.ReadSeeker
+	Mutex sync.Mutex
+}
+
+func (reader *NetStorageSeekReaderAt) ReadAt (buffer []byte, offset int64) (int, error) {
+	reader.Mutex.Lock();
+	defer reader.Mutex.Unlock();
+	
+	_, err := reader.Reader.Seek (offset, io.SeekStart);
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	count, err := io.ReadFull (reader.Reader, buffer);
+	if (err == io.ErrUnexpectedEOF) {
+		err = io.EOF;
+	}
+	
+	return count, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// find3MFPart
+// finds a part of a package by its name. Part names are case insensitive.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func find3MFPart (archive *zip.Reader, partname string) (*zip.File) {
+
+	partname = strings.TrimPrefix (partname, "/");
+	for _, file := range archive.File {
+		if (strings.EqualFold (file.Name, partname)) {
+			return file;
+		}
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// read3MFRootRelationships
+// reads the relationships of the package root
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func read3MFRootRelationships (archive *zip.Reader) ([]NetStorageOPCRelationship, error) {
+	var relationships NetStorageOPCRelationships;
+
+	file := find3MFPart (archive, "_rels/.rels");
+	if (file == nil) {
+		return relationships.Relationships, errors.New ("3MF package has no root relationships");
+	}
+	
+	reader, err := file.Open ();
+	if (err != nil) {
+		return relationships.Relationships, err;
+	}
+	defer reader.Close ();
+	
+	err = xml.NewDecoder (reader).Decode (&relationships);
+	return relationships.Relationships, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// getXMLAttribute
+// returns the value of an attribute of an XML element, ignoring its namespace
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func getXMLAttribute (element xml.StartElement, name string) string {
+
+	for _, attribute := range element.Attr {
+		if (attribute.Name.Local == name) {
+			return attribute.Value;
+		}
+	}
+	
+	return "";
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// read3MFModel
+// reads the model part of a package and collects its unit, metadata, objects, build items and base 
+// materials
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func read3MFModel (reader io.Reader, modelpackage * NetStorage3MFPackage) (error) {
+
+	decoder := xml.NewDecoder (reader);
+	
+	var metadataname string;
+	var metadatavalue strings.Builder;
+	inmetadata := false;
+	inbuild := false;
+	basematerialsid := "";
+	basematerialindex := 0;
+	var object * NetStorage3MFObject = nil;
+	
+	for {
+		token, err := decoder.Token ();
+		if (err == io.EOF) {
+			break;
+		}
+		if (err != nil) {
+			return err;
+		}
+		
+		switch element := token.(type) {
+			case xml.StartElement:
+				switch (element.Name.Local) {
+					case "model":
+						unit := getXMLAttribute (element, "unit");
+						if (unit != "") {
+							modelpackage.Unit = unit;
+						}
+					case "metadata":
+						// object level metadata is inside a metadatagroup and not part of the model metadata
+						if (object == nil) {
+							inmetadata = true;
+							metadataname = getXMLAttribute (element, "name");
+							metadatavalue.Reset ();
+						}
+					case "object":
+						modelpackage.Objects = append (modelpackage.Objects, NetStorage3MFObject {
+							ID: getXMLAttribute (element, "id"),
+							Name: getXMLAttribute (element, "name"),
+							Type: getXMLAttribute (element, "type"),
+						});
+						object = &modelpackage.Objects[len (modelpackage.Objects) - 1];
+						if (object.Type == "") {
+							object.Type = "model";
+						}
+					case "vertex":
+						if (object != nil) {
+							object.VertexCount++;
+						}
+					case "triangle":
+						if (object != nil) {
+							object.TriangleCount++;
+						}
+					case "component":
+						if (object != nil) {
+							object.ComponentCount++;
+						}
+					case "build":
+						inbuild = true;
+					case "item":
+						if (inbuild) {
+							modelpackage.BuildItemCount++;
+						}
+					case "basematerials":
+						basematerialsid = getXMLAttribute (element, "id");
+						basematerialindex = 0;
+					case "base":
+						if (basematerialsid != "") {
+							modelpackage.Materials = append (modelpackage.Materials, NetStorage3MFMaterial {
+								GroupID: basematerialsid,
+								Index: basematerialindex,
+								Name: getXMLAttribute (element, "name"),
+								DisplayColor: getXMLAttribute (element, "displaycolor"),
+							});
+							basematerialindex++;
+						}
+				}
+				
+			case xml.CharData:
+				if (inmetadata) {
+					metadatavalue.Write (element);
+				}
+				
+			case xml.EndElement:
+				switch (element.Name.Local) {
+					case "metadata":
+						if (inmetadata && (metadataname != "")) {
+							modelpackage.MetaData[metadataname] = strings.TrimSpace (metadatavalue.String ());
+						}
+						inmetadata = false;
+					case "object":
+						object = nil;
+					case "build":
+						inbuild = false;
+					case "basematerials":
+						basematerialsid = "";
+				}
+		}
+	}
+	
+	modelpackage.ObjectCount = len (modelpackage.Objects);
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// open3MFPackage
+// opens a 3MF package and finds its model part. The model and thumbnail part names are stored in the 
+// package information.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func open3MFPackage (file io.ReadSeeker, filesize int64, modelpackage * NetStorage3MFPackage) (*zip.Reader, *zip.File, error) {
+
+	archive, err := zip.NewReader (&NetStorageSeekReaderAt { Reader: file }, filesize);
+	if (err != nil) {
+		return nil, nil, errors.New ("not a valid 3MF package: " + err.Error ());
+	}
+	
+	relationships, err := read3MFRootRelationships (archive);
+	if (err != nil) {
+		return nil, nil, err;
+	}
+	
+	for _, relationship := range relationships {
+		switch (relationship.Type) {
+			case RELATIONSHIPTYPE_3DMODEL:
+				if (modelpackage.ModelPart == "") {
+					modelpackage.ModelPart = relationship.Target;
+				}
+			case RELATIONSHIPTYPE_THUMBNAIL:
+				modelpackage.ThumbnailPart = relationship.Target;
+		}
+	}
+	
+	if (modelpackage.ModelPart == "") {
+		return nil, nil, errors.New ("3MF package has no model part");
+	}
+	
+	modelfile := find3MFPart (archive, modelpackage.ModelPart);
+	if (modelfile == nil) {
+		return nil, nil, errors.New ("3MF model part not found: " + modelpackage.ModelPart);
+	}
+	
+	return archive, modelfile, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// analyze3MFPackage
+// inspects a 3MF package and reads its model information and package thumbnail
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func analyze3MFPackage (file io.ReadSeeker, filesize int64) (NetStorage3MFPackage, error) {
+	var modelpackage NetStorage3MFPackage;
+	modelpackage.Unit = PACKAGE3MF_DEFAULTUNIT;
+	modelpackage.MetaData = make (map[string]string);
+	modelpackage.Objects = make ([]NetStorage3MFObject, 0);
+	modelpackage.Materials = make ([]NetStorage3MFMaterial, 0);
+	
+	archive, modelfile, err := open3MFPackage (file, filesize, &modelpackage);
+	if (err != nil) {
+		return modelpackage, err;
+	}
+	
+	reader, err := modelfile.Open ();
+	if (err != nil) {
+		return modelpackage, err;
+	}
+	err = read3MFModel (reader, &modelpackage);
+	reader.Close ();
+	if (err != nil) {
+		return modelpackage, err;
+	}
+	
+	if (modelpackage.ThumbnailPart != "") {
+		thumbnailfile := find3MFPart (archive, modelpackage.ThumbnailPart);
+		if ((thumbnailfile != nil) && (thumbnailfile.UncompressedSize64 <= PACKAGE3MF_MAXTHUMBNAILSIZE)) {
+			reader, err := thumbnailfile.Open ();
+			if (err != nil) {
+				return modelpackage, err;
+			}
+			modelpackage.Thumbnail, err = ioutil.ReadAll (io.LimitReader (reader, PACKAGE3MF_MAXTHUMBNAILSIZE));
+			reader.Close ();
+			if (err != nil) {
+				return modelpackage, err;
+			}
+			
+			modelpackage.ThumbnailContentType = getDataTypeContentType (strings.TrimPrefix (path.Ext (thumbnailfile.Name), "."));
+		}
+	}
+	
+	return modelpackage, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// parse3MFTransform
+// parses the transform attribute of a component or build item. An empty attribute is the identity.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func parse3MFTransform (value string) (NetStorage3MFTransform, error) {
+
+	if (strings.TrimSpace (value) == "") {
+		return Package3MFIdentityTransform, nil;
+	}
+	
+	var transform NetStorage3MFTransform;
+	fields := strings.Fields (value);
+	if (len (fields) != len (transform)) {
+		return transform, errors.New ("invalid 3MF transform: " + value);
+	}
+	
+	for index, field := range fields {
+		number, err := strconv.ParseFloat (field, 64);
+		if (err != nil) {
+			return transform, errors.New ("invalid 3MF transform: " + value);
+		}
+		transform[index] = number;
+	}
+	
+	return transform, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// combine3MFTransforms
+// returns the transform that applies first and then second
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func combine3MFTransforms (first NetStorage3MFTransform, second NetStorage3MFTransform) (NetStorage3MFTransform) {
+	var result NetStorage3MFTransform;
+
+	for row := 0; row < 4; row++ {
+		for column := 0; column < 3; column++ {
+			var value float64;
+			for index := 0; index < 3; index++ {
+				value += first[row * 3 + index] * second[index * 3 + column];
+			}
+			if (row == 3) {
+				value += second[9 + column];
+			}
+			result[row * 3 + column] = value;
+		}
+	}
+	
+	return result;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// apply3MFTransform
+// transforms a vertex
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func apply3MFTransform (transform NetStorage3MFTransform, vertex [3]float32) ([3]float32) {
+	var result [3]float32;
+	
+	for column := 0; column < 3; column++ {
+		result[column] = float32 (float64 (vertex[0]) * transform[column] + float64 (vertex[1]) * transform[3 + column] + 
+			float64 (vertex[2]) * transform[6 + column] + transform[9 + column]);
+	}
+	
+	return result;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// read3MFMeshes
+// reads the meshes and components of all objects and the build items of a model part. Fails as soon 
+// as the vertices or triangles of all objects exceed the limits.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func read3MFMeshes (reader io.Reader, limits NetStorage3MFMeshLimits) (map[string]*NetStorage3MFMesh, []NetStorage3MFComponent, error) {
+
+	meshes := make (map[string]*NetStorage3MFMesh);
+	items := make ([]NetStorage3MFComponent, 0);
+	vertexcount := 0;
+	trianglecount := 0;
+	
+	decoder := xml.NewDecoder (reader);
+	var mesh * NetStorage3MFMesh = nil;
+	inbuild := false;
+	
+	for {
+		token, err := decoder.Token ();
+		if (err == io.EOF) {
+			break;
+		}
+		if (err != nil) {
+			return meshes, items, err;
+		}
+		
+		switch element := token.(type) {
+			case xml.StartElement:
+				switch (element.Name.Local) {
+					case "object":
+						mesh = &NetStorage3MFMesh {};
+						meshes[getXMLAttribute (element, "id")] = mesh;
+						
+					case "vertex":
+						if (mesh != nil) {
+							vertexcount++;
+							if (vertexcount > limits.MaxVertices) {
+								return meshes, items, errors.New (fmt.Sprintf ("3MF model has more than %d vertices", limits.MaxVertices));
+							}
+							
+							var vertex [3]float32;
+							for index, name := range []string { "x", "y", "z" } {
+								value, err := strconv.ParseFloat (getXMLAttribute (element, name), 32);
+								if (err != nil) {
+									return meshes, items, errors.New ("invalid 3MF vertex coordinate: " + getXMLAttribute (element, name));
+								}
+								vertex[index] = float32 (value);
+							}
+							if (!isFiniteVertex (vertex)) {
+								return meshes, items, errors.New ("invalid 3MF vertex, coordinates must be finite");
+							}
+							mesh.Vertices = append (mesh.Vertices, vertex);
+						}
+						
+					case "triangle":
+						if (mesh != nil) {
+							trianglecount++;
+							if (trianglecount > limits.MaxTriangles) {
+								return meshes, items, errors.New (fmt.Sprintf ("3MF model has more than %d triangles", limits.MaxTriangles));
+							}
+							
+							var triangle [3]int;
+							for index, name := range []string { "v1", "v2", "v3" } {
+								value, err := strconv.Atoi (getXMLAttribute (element, name));
+								if (err != nil) {
+									return meshes, items, errors.New ("invalid 3MF triangle index: " + getXMLAttribute (element, name));
+								}
+								triangle[index] = value;
+							}
+							mesh.Triangles = append (mesh.Triangles, triangle);
+						}
+						
+					case "component", "item":
+						if ((element.Name.Local == "component") && (mesh == nil)) {
+							continue;
+						}
+						if ((element.Name.Local == "item") && !inbuild) {
+							continue;
+						}
+						
+						var component NetStorage3MFComponent;
+						component.ObjectID = getXMLAttribute (element, "objectid");
+						component.Transform, err = parse3MFTransform (getXMLAttribute (element, "transform"));
+						if (err != nil) {
+							return meshes, items, err;
+						}
+						
+						if (element.Name.Local == "component") {
+							mesh.Components = append (mesh.Components, component);
+						} else {
+							items = append (items, component);
+						}
+						
+					case "build":
+						inbuild = true;
+				}
+				
+			case xml.EndElement:
+				switch (element.Name.Local) {
+					case "object":
+						mesh = nil;
+					case "build":
+						inbuild = false;
+				}
+		}
+	}
+	
+	return meshes, items, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// count3MFObject
+// counts the triangles and component instances of an object with its components expanded. Counts 
+// are cached per object and saturate above the limit, so that the count itself stays cheap.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func count3MFObject (meshes map[string]*NetStorage3MFMesh, objectid string, depth int, counts map[string]int64) (int64, error) {
+
+	if (depth > PACKAGE3MF_MAXCOMPONENTDEPTH) {
+		return 0, errors.New ("3MF components are nested too deeply");
+	}
+
+	count, found := counts[objectid];
+	if (found) {
+		return count, nil;
+	}
+
+	mesh, found := meshes[objectid];
+	if (!found) {
+		return 0, errors.New ("3MF object not found: " + objectid);
+	}
+	
+	count = int64 (len (mesh.Triangles));
+	for _, component := range mesh.Components {
+		componentcount, err := count3MFObject (meshes, component.ObjectID, depth + 1, counts);
+		if (err != nil) {
+			return 0, err;
+		}
+		
+		count = count + 1 + componentcount;
+		if (count > PACKAGE3MF_MAXEXPANDEDTRIANGLES) {
+			count = PACKAGE3MF_MAXEXPANDEDTRIANGLES + 1;
+		}
+	}
+	
+	counts[objectid] = count;
+	return count, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// emit3MFObject
+// calls the callback for all triangles of an object and its components in build coordinates
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func emit3MFObject (meshes map[string]*NetStorage3MFMesh, objectid string, transform NetStorage3MFTransform, depth int, callback func (triangle NetStorageSTLTriangle)) (error) {
+
+	if (depth > PACKAGE3MF_MAXCOMPONENTDEPTH) {
+		return errors.New ("3MF components are nested too deeply");
+	}
+
+	mesh, found := meshes[objectid];
+	if (!found) {
+		return errors.New ("3MF object not found: " + objectid);
+	}
+	
+	for _, indices := range mesh.Triangles {
+		var triangle NetStorageSTLTriangle;
+		for vertex := 0; vertex < 3; vertex++ {
+			if ((indices[vertex] < 0) || (indices[vertex] >= len (mesh.Vertices))) {
+				return fmt.Errorf ("invalid vertex index %d in 3MF object %s", indices[vertex], objectid);
+			}
+			triangle[vertex] = apply3MFTransform (transform, mesh.Vertices[indices[vertex]]);
+		}
+		
+		callback (triangle);
+	}
+	
+	for _, component := range mesh.Components {
+		err := emit3MFObject (meshes, component.ObjectID, combine3MFTransforms (component.Transform, transform), depth + 1, callback);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// read3MFTriangles
+// reads a 3MF package and calls the callback for every triangle of its build items, with the 
+// transforms of the build items and components applied
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func read3MFTriangles (file io.ReadSeeker, filesize int64, callback func (triangle NetStorageSTLTriangle)) (error) {
+	var modelpackage NetStorage3MFPackage;
+
+	_, modelfile, err := open3MFPackage (file, filesize, &modelpackage);
+	if (err != nil) {
+		return err;
+	}
+	
+	reader, err := modelfile.Open ();
+	if (err != nil) {
+		return err;
+	}
+	meshes, items, err := read3MFMeshes (reader, Package3MFMeshLimits);
+	reader.Close ();
+	if (err != nil) {
+		return err;
+	}
+	
+	// the expanded build is checked before any triangle is emitted
+	counts := make (map[string]int64);
+	var total int64;
+	for _, item := range items {
+		count, err := count3MFObject (meshes, item.ObjectID, 0, counts);
+		if (err != nil) {
+			return err;
+		}
+		
+		total = total + count;
+		if (total > PACKAGE3MF_MAXEXPANDEDTRIANGLES) {
+			return errors.New (fmt.Sprintf ("3MF build expands to more than %d triangles", PACKAGE3MF_MAXEXPANDEDTRIANGLES));
+		}
+	}
+	
+	for _, item := range items {
+		err = emit3MFObject (meshes, item.ObjectID, item.Transform, 0, callback);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// is3MFUnit
+// checks if a unit is one of the model units of the 3MF specification
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func is3MFUnit (unit string) bool {
+	for _, validunit := range Package3MFUnits {
+		if (unit == validunit) {
+			return true;
+		}
+	}
+	
+	return false;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// write3MFPackage
+// writes the triangles of a mesh source as 3MF package with a single object and returns the number 
+// of triangles. The source is read twice, once for the vertices and once for the triangles. Equal 
+// vertices are merged and triangles that collapse to an edge or a point are left out.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func write3MFPackage (file io.Writer, name string, unit string, source NetStorageTriangleSource) (int64, error) {
+
+	if (!is3MFUnit (unit)) {
+		return 0, errors.New ("invalid 3MF unit: " + unit);
+	}
+	
+	var escapedname bytes.Buffer;
+	err := xml.EscapeText (&escapedname, []byte (name));
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	archive := zip.NewWriter (file);
+	
+	contenttypes, err := archive.Create ("[Content_Types].xml");
+	if (err != nil) {
+		return 0, err;
+	}
+	_, err = io.WriteString (contenttypes, `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` + 
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` + 
+		`<Default Extension="model" ContentType="application/vnd.ms-package.3dmanufacturing-3dmodel+xml"/>` + 
+		`</Types>` + "\n");
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	relationships, err := archive.Create ("_rels/.rels");
+	if (err != nil) {
+		return 0, err;
+	}
+	_, err = io.WriteString (relationships, `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + 
+		`<Relationship Target="/` + PACKAGE3MF_MODELPART + `" Id="rel0" Type="` + RELATIONSHIPTYPE_3DMODEL + `"/>` + 
+		`</Relationships>` + "\n");
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	modelfile, err := archive.Create (PACKAGE3MF_MODELPART);
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	writer := bufio.NewWriterSize (modelfile, 1024 * 1024);
+	formatvalue := func (value float32) string {
+		return strconv.FormatFloat (float64 (value), 'g', -1, 32);
+	};
+	
+	fmt.Fprintf (writer, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<model unit=\"%s\" xml:lang=\"en-US\" xmlns=\"%s\">\n", unit, PACKAGE3MF_NAMESPACE);
+	fmt.Fprintf (writer, " <resources>\n  <object id=\"1\" name=\"%s\" type=\"model\">\n   <mesh>\n    <vertices>\n", escapedname.String ());
+	
+	vertexindices := make (map[[3]float32]int64);
+	err = source (func (triangle NetStorageSTLTriangle) {
+		for vertex := 0; vertex < 3; vertex++ {
+			_, exists := vertexindices[triangle[vertex]];
+			if (!exists) {
+				vertexindices[triangle[vertex]] = int64 (len (vertexindices));
+				fmt.Fprintf (writer, "     <vertex x=\"%s\" y=\"%s\" z=\"%s\"/>\n", formatvalue (triangle[vertex][0]), formatvalue (triangle[vertex][1]), formatvalue (triangle[vertex][2]));
+			}
+		}
+	});
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	fmt.Fprintf (writer, "    </vertices>\n    <triangles>\n");
+	
+	var count int64;
+	missingvertex := false;
+	err = source (func (triangle NetStorageSTLTriangle) {
+		var face [3]int64;
+		for vertex := 0; vertex < 3; vertex++ {
+			index, exists := vertexindices[triangle[vertex]];
+			if (!exists) {
+				missingvertex = true;
+				return;
+			}
+			face[vertex] = index;
+		}
+		
+		if ((face[0] == face[1]) || (face[1] == face[2]) || (face[2] == face[0])) {
+			return;
+		}
+		
+		fmt.Fprintf (writer, "     <triangle v1=\"%d\" v2=\"%d\" v3=\"%d\"/>\n", face[0], face[1], face[2]);
+		count++;
+	});
+	if (err != nil) {
+		return 0, err;
+	}
+	if (missingvertex) {
+		return 0, errors.New ("mesh source changed while writing the 3MF package");
+	}
+	
+	fmt.Fprintf (writer, "    </triangles>\n   </mesh>\n  </object>\n </resources>\n <build>\n  <item objectid=\"1\"/>\n </build>\n</model>\n");
+	
+	// write errors are sticky and reported by the flush
+	err = writer.Flush ();
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	err = archive.Close ();
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	return count, nil;
+}
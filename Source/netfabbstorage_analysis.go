@@ -0,0 +1,322 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_analysis.go
+// Automatic analysis of uploaded entities. Once an entity has been activated, an "analyzeentity" task 
+// is queued. The application server runs these tasks one at a time in the background, reads the 
+// content according to the datatype and merges the results into the entity metadata under their 
+// own key, next to the metadata given by the client.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"fmt"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+	"encoding/json"
+	"database/sql"
+)
+
+
+const ENTITYMETADATA_GEOMETRY = "geometry";
+const ENTITYMETADATA_3MF = "3mf";
+const ENTITYMETADATA_CONVERSION = "conversion";
+
+const TASKNAME_ENTITYANALYSIS = "analyzeentity";
+const ENTITYANALYSIS_WORKER = "NetfabbApplicationServer";
+
+// Wakes up the analysis worker when a task has been queued
+var EntityAnalysisSignal = make (chan struct{}, 1);
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// mergeEntityMetadata
+// sets a key of the JSON object in the metadata of an entity. Empty metadata is treated as an empty 
+// object. Metadata that is not a JSON object cannot be extended.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func mergeEntityMetadata (metadata string, key string, value interface{}) (string, error) {
+
+	fields := make (map[string]json.RawMessage);
+	if ((metadata != "") && (metadata != "null")) {
+		err := json.Unmarshal ([]byte (metadata), &fields);
+		if (err != nil) {
+			return metadata, fmt.Errorf ("metadata is not a JSON object: %s", err.Error ());
+		}
+	}
+	
+	data, err := json.Marshal (value);
+	if (err != nil) {
+		return metadata, err;
+	}
+	fields[key] = data;
+	
+	merged, err := json.Marshal (fields);
+	if (err != nil) {
+		return metadata, err;
+	}
+	
+	return string (merged), nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// openEntityContent
+// opens the stored content of an entity for random access and returns it with its size. Compressed 
+// content is returned decompressed.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func openEntityContent (db *sql.DB, entity NetStorageEntity) (io.ReadSeekCloser, int64, error) {
+
+	storagename, compression, err := getEntityStorageName (db, entity);
+	if (err != nil) {
+		return nil, 0, err;
+	}
+	
+	filesize, err := strconv.ParseInt (entity.FileSize, 10, 64);
+	if (err != nil) {
+		return nil, 0, err;
+	}
+	
+	file, err := openStoredFile (storagename, compression);
+	if (err != nil) {
+		return nil, 0, err;
+	}
+	
+	return file, filesize, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// queueEntityAnalysis
+// queues the analysis of an entity that has been activated. Failures are logged, but do not fail the 
+// upload.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func queueEntityAnalysis (db *sql.DB, session * NetStorageSession, entityuuid string) {
+
+	parameters, err := json.Marshal (map[string]string { "entityuuid": entityuuid });
+	if (err == nil) {
+		err = createNewTask (db, createUUID (), TASKNAME_ENTITYANALYSIS, string (parameters));
+	}
+	if (err != nil) {
+		addLogMessage (session, "Could not queue analysis of entity " + entityuuid + ": " + err.Error (), LOGTYPE_DATA_ANALYSIS, LOGLEVEL_CONSOLE);	
+		return;
+	}
+	
+	select {
+		case EntityAnalysisSignal <- struct{}{}:
+		default:
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// analyzeEntity
+// analyzes the content of an active entity if its datatype is supported and stores the results in 
+// the entity metadata
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func analyzeEntity (db *sql.DB, session * NetStorageSession, entityuuid string) (error) {
+
+	entity, err := RetrieveEntityByUUID (db, entityuuid, true);
+	if (err != nil) {
+		return err;
+	}
+	
+	contenttype := getDataTypeContentType (entity.DataType);
+	
+	var key string;
+	switch (contenttype) {
+		case "model/stl":
+			key = ENTITYMETADATA_GEOMETRY;
+		case "model/3mf":
+			key = ENTITYMETADATA_3MF;
+		default:
+			return nil;
+	}
+	
+	addLogMessage (session, "Analyzing entity: " + entityuuid, LOGTYPE_DATA_ANALYSIS, LOGLEVEL_CONSOLE);	
+	
+	result, err := readEntityAnalysis (db, entity, contenttype);
+	if (err != nil) {
+		return err;
+	}
+	
+	// embedded package thumbnails are stored as thumbnail of the entity, other meshes are rendered. 
+	// A missing thumbnail does not discard the analysis.
+	modelpackage, ispackage := result.(NetStorage3MFPackage);
+	if (ispackage && (len (modelpackage.Thumbnail) > 0)) {
+		err = storeEntityThumbnail (db, entity, modelpackage.ThumbnailContentType, THUMBNAILSOURCE_3MF, modelpackage.Thumbnail);
+	} else if (GlobalConfig.Thumbnails.Render) {
+		_, err = renderEntityThumbnail (db, entity, GlobalConfig.Thumbnails.Size);
+	}
+	if (err != nil) {
+		addLogMessage (session, "Could not create thumbnail of entity " + entity.UUID + ": " + err.Error (), LOGTYPE_DATA_ANALYSIS, LOGLEVEL_CONSOLE);	
+	}
+	
+	metadata, err := mergeEntityMetadata (entity.MetaData, key, result);
+	if (err != nil) {
+		return err;
+	}
+	
+	return updateEntityMetadata (db, entity.UUID, metadata);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// runEntityAnalysisTask
+// runs an entity analysis task and returns its status and results. A panic while reading a corrupt 
+// file fails the task instead of the server.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func runEntityAnalysisTask (db *sql.DB, session * NetStorageSession, task NetTaskHandleReply) (status string, results map[string]string) {
+
+	results = make (map[string]string);
+
+	defer func () {
+		recovered := recover ();
+		if (recovered != nil) {
+			addLogMessage (session, fmt.Sprintf ("Analysis task %s crashed: %v", task.UUID, recovered), LOGTYPE_DATA_ANALYSIS, LOGLEVEL_CONSOLE);	
+			status = "ERROR";
+			results["error"] = fmt.Sprintf ("internal error: %v", recovered);
+		}
+	} ();
+
+	entityuuid := task.Parameters["entityuuid"];
+	err := analyzeEntity (db, session, entityuuid);
+	if (err != nil) {
+		addLogMessage (session, "Could not analyze entity " + entityuuid + ": " + err.Error (), LOGTYPE_DATA_ANALYSIS, LOGLEVEL_CONSOLE);	
+		results["error"] = err.Error ();
+		return "ERROR", results;
+	}
+	
+	results["entityuuid"] = entityuuid;
+	return "SUCCESS", results;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// runEntityAnalysisTasks
+// runs all queued entity analysis tasks
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func runEntityAnalysisTasks (db *sql.DB, session * NetStorageSession) (error) {
+
+	for {
+		task, err := handleTask (db, session, TASKNAME_ENTITYANALYSIS, ENTITYANALYSIS_WORKER);
+		if (err != nil) {
+			return err;
+		}
+		
+		if (task.UUID == "") {
+			return nil;
+		}
+		
+		status, results := runEntityAnalysisTask (db, session, task);
+		
+		err = updateTask (db, session, task.UUID, task.WorkerSecret, status, results);
+		if (err != nil) {
+			return err;
+		}
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// runEntityAnalyzer
+// runs queued entity analysis tasks as soon as they are queued, and polls the task queue in the 
+// configured interval for tasks that have been returned
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func runEntityAnalyzer (config ConfigDefinitionAnalysis) {
+
+	session := createEmptySession ();
+	
+	db, err := OpenDB (GlobalConfig.Database.Type, GlobalConfig.Database.FileName);
+	if (err == nil) {
+		var count int;
+		count, err = requeueInterruptedTasks (db, TASKNAME_ENTITYANALYSIS, ENTITYANALYSIS_WORKER);
+		db.Close ();
+		
+		if ((err == nil) && (count > 0)) {
+			addLogMessage (&session, fmt.Sprintf ("Requeued %d interrupted entity analyses", count), LOGTYPE_DATA_ANALYSIS, LOGLEVEL_CONSOLE);	
+		}
+	}
+	if (err != nil) {
+		addLogMessage (&session, "Could not requeue interrupted entity analyses: " + err.Error (), LOGTYPE_DATA_ANALYSIS, LOGLEVEL_CONSOLE);	
+	}
+
+	ticker := time.NewTicker (time.Duration (config.IntervalSeconds) * time.Second);
+	defer ticker.Stop ();
+	
+	for {
+		db, err := OpenDB (GlobalConfig.Database.Type, GlobalConfig.Database.FileName);
+		if (err == nil) {
+			err = runEntityAnalysisTasks (db, &session);
+			db.Close ();
+		}
+		
+		if (err != nil) {
+			addLogMessage (&session, "Entity analysis failed: " + err.Error (), LOGTYPE_DATA_ANALYSIS, LOGLEVEL_CONSOLE);	
+		}
+		
+		select {
+			case <- EntityAnalysisSignal:
+			case <- ticker.C:
+		}
+	}
+	
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// readEntityAnalysis
+// opens the stored content of an entity and analyzes it according to the content type of its datatype
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func readEntityAnalysis (db *sql.DB, entity NetStorageEntity, contenttype string) (interface{}, error) {
+
+	file, filesize, err := openEntityContent (db, entity);
+	if (err != nil) {
+		return nil, err;
+	}
+	defer file.Close ();
+	
+	switch (contenttype) {
+		case "model/stl":
+			return analyzeSTLGeometry (file, filesize);
+		case "model/3mf":
+			return analyze3MFPackage (file, filesize);
+		default:
+			return nil, errors.New ("no analysis for content type " + contenttype);
+	}
+}
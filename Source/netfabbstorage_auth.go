@@ -76,6 +76,30 @@ const (
 	LOGTYPE_DATA_UPLOADBINARY   = "DATUPL"
 	LOGTYPE_DATA_UPLOADENTITY   = "DATUEN"
 	LOGTYPE_DATA_DOWNLOADENTITY = "DATDEN"
+	LOGTYPE_DATA_NEWUPLOAD      = "DATNUP"
+	LOGTYPE_DATA_UPLOADCHUNK    = "DATUCH"
+	LOGTYPE_DATA_UPLOADSTATUS   = "DATUST"
+	LOGTYPE_DATA_FINALIZEUPLOAD = "DATFUP"
+	LOGTYPE_DATA_ABORTUPLOAD    = "DATAUP"
+	LOGTYPE_DATA_RENAME         = "DATREN"
+	LOGTYPE_DATA_MOVE           = "DATMOV"
+	LOGTYPE_DATA_DELETE         = "DATDEL"
+	LOGTYPE_DATA_TRASH          = "DATTRS"
+	LOGTYPE_DATA_PURGETRASH     = "DATPTR"
+	LOGTYPE_DATA_FOLDERTREE     = "DATTRE"
+	LOGTYPE_DATA_SEARCH         = "DATSRC"
+	LOGTYPE_DATA_HUBADMIN       = "DATHAD"
+	LOGTYPE_DATA_ITEMLOCK       = "DATLCK"
+	LOGTYPE_DATA_QUOTA          = "DATQUO"
+	LOGTYPE_DATA_GARBAGE        = "DATGAR"
+	LOGTYPE_DATA_SCRUB          = "DATSCR"
+	LOGTYPE_DATA_ANALYSIS       = "DATANA"
+	LOGTYPE_DATA_CONVERSION     = "DATCNV"
+	LOGTYPE_DATA_BATCH          = "DATBAT"
+	LOGTYPE_DATA_PROPERTIES     = "DATPRP"
+	LOGTYPE_DATA_COMMENTS       = "DATCMT"
+	LOGTYPE_DATA_CHANGES        = "DATCHG"
+	LOGTYPE_DATA_EVENTS         = "DATEVT"
 
 	LOGTYPE_PANSERVICE = "SVCPAN"
 )
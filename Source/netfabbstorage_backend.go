@@ -0,0 +1,189 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_backend.go
+// Storage backends for blob content. Backends address files by slash separated names relative to 
+// their root. Uploads are always staged in the local data directory and handed to the backend 
+// once they are complete.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+
+type NetStorageBackend interface {
+	// moves a completely written local file into the backend
+	StoreFile (name string, localfilename string) error
+	
+	// opens a stored file for reading
+	Open (name string) (io.ReadSeekCloser, error)
+	
+	Exists (name string) (bool, error)
+	
+	// removes a stored file. Removing a file that does not exist is not an error.
+	Remove (name string) error
+	
+	// lists all stored files whose names start with the prefix, including files in subdirectories
+	List (prefix string) ([]NetStorageBackendFile, error)
+}
+
+
+type NetStorageBackendFile struct {
+	Name string
+	Size int64
+	ModTime time.Time
+}
+
+
+var StorageBackend NetStorageBackend = nil;
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createStorageBackend
+// creates the storage backend selected in the <data> config element
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createStorageBackend (config ConfigDefinitionData) (NetStorageBackend, error) {
+
+	switch (config.Backend) {
+		case "local", "":
+			return createLocalStorageBackend (config.Directory), nil;
+			
+		case "s3":
+			return createS3StorageBackend (config.S3);
+		
+		default:
+			return nil, errors.New ("Invalid data backend: " + config.Backend);
+	}
+
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// NetLocalStorageBackend
+// stores files in a directory of the local file system
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type NetLocalStorageBackend struct {
+	Directory string
+}
+
+func createLocalStorageBackend (directory string) (*NetLocalStorageBackend) {
+	var backend NetLocalStorageBackend;
+	backend.Directory = directory;
+	return &backend;
+}
+
+func (backend *NetLocalStorageBackend) getFileName (name string) string {
+	return filepath.FromSlash (path.Join (backend.Directory, name));
+}
+
+func (backend *NetLocalStorageBackend) StoreFile (name string, localfilename string) error {
+	filename := backend.getFileName (name);
+	
+	err := os.MkdirAll (filepath.Dir (filename), 0755);
+	if (err != nil) {
+		return err;
+	}
+	
+	return os.Rename (localfilename, filename);
+}
+
+func (backend *NetLocalStorageBackend) Open (name string) (io.ReadSeekCloser, error) {
+	return os.Open (backend.getFileName (name));
+}
+
+func (backend *NetLocalStorageBackend) Exists (name string) (bool, error) {
+	_, err := os.Stat (backend.getFileName (name));
+	if (err == nil) {
+		return true, nil;
+	}
+	
+	if (os.IsNotExist (err)) {
+		return false, nil;
+	}
+	
+	return false, err;
+}
+
+func (backend *NetLocalStorageBackend) Remove (name string) error {
+	err := os.Remove (backend.getFileName (name));
+	if (os.IsNotExist (err)) {
+		return nil;
+	}
+	
+	return err;
+}
+
+func (backend *NetLocalStorageBackend) List (prefix string) ([]NetStorageBackendFile, error) {
+	files := make ([]NetStorageBackendFile, 0);
+	
+	// only the directory of the prefix can contain matching files
+	directory := backend.getFileName (prefix[:strings.LastIndex (prefix, "/") + 1]);
+	
+	err := filepath.Walk (directory, func (filename string, info os.FileInfo, err error) error {
+		if (err != nil) {
+			if (os.IsNotExist (err) && (filename == directory)) {
+				return filepath.SkipDir;
+			}
+			return err;
+		}
+		
+		if (!info.Mode().IsRegular()) {
+			return nil;
+		}
+		
+		relativename, err := filepath.Rel (backend.Directory, filename);
+		if (err != nil) {
+			return err;
+		}
+		name := filepath.ToSlash (relativename);
+		
+		if (strings.HasPrefix (name, prefix)) {
+			var file NetStorageBackendFile;
+			file.Name = name;
+			file.Size = info.Size ();
+			file.ModTime = info.ModTime ();
+			files = append (files, file);
+		}
+		
+		return nil;
+	});
+	
+	return files, err;
+}
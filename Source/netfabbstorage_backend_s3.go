@@ -0,0 +1,658 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_backend_s3.go
+// Storage backend for S3 compatible object storages (Amazon S3, MinIO, Ceph...). Requests are sent 
+// path-style and signed with AWS Signature Version 4.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+
+// Files larger than one part are sent as multipart upload
+const S3_PARTSIZE = 64 * 1024 * 1024;
+
+const S3_UNSIGNEDPAYLOAD = "UNSIGNED-PAYLOAD";
+const S3_EMPTYPAYLOAD = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855";
+
+
+type NetS3StorageBackend struct {
+	Endpoint string
+	Region string
+	Bucket string
+	Prefix string
+	AccessKey string
+	SecretKey string
+	Client *http.Client
+}
+
+type NetS3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type NetS3InitiateMultipartUploadResult struct {
+	XMLName xml.Name `xml:"InitiateMultipartUploadResult"`
+	UploadID string `xml:"UploadId"`
+}
+
+type NetS3CompletedPart struct {
+	PartNumber int `xml:"PartNumber"`
+	ETag string `xml:"ETag"`
+}
+
+type NetS3CompleteMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts []NetS3CompletedPart `xml:"Part"`
+}
+
+type NetS3ListedObject struct {
+	Key string `xml:"Key"`
+	Size int64 `xml:"Size"`
+	LastModified string `xml:"LastModified"`
+}
+
+type NetS3ListBucketResult struct {
+	XMLName xml.Name `xml:"ListBucketResult"`
+	Contents []NetS3ListedObject `xml:"Contents"`
+	IsTruncated bool `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createS3StorageBackend
+// creates an S3 backend from the <s3> config element
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createS3StorageBackend (config ConfigDefinitionS3) (NetStorageBackend, error) {
+
+	if (config.Endpoint == "") {
+		return nil, errors.New ("Missing S3 endpoint");
+	}
+	
+	if (config.Bucket == "") {
+		return nil, errors.New ("Missing S3 bucket");
+	}
+
+	var backend NetS3StorageBackend;
+	backend.Endpoint = strings.TrimRight (config.Endpoint, "/");
+	backend.Region = config.Region;
+	backend.Bucket = config.Bucket;
+	backend.Prefix = strings.Trim (config.Prefix, "/");
+	backend.AccessKey = config.AccessKey;
+	backend.SecretKey = config.SecretKey;
+	backend.Client = &http.Client {};
+	
+	if (backend.Region == "") {
+		backend.Region = "us-east-1";
+	}
+	
+	return &backend, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// s3URIEncode
+// encodes a string as required by the canonical request of AWS Signature Version 4
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func s3URIEncode (value string, encodeSlash bool) string {
+	var buffer bytes.Buffer;
+	
+	for i := 0; i < len (value); i++ {
+		c := value[i];
+		if ((c >= 'A') && (c <= 'Z')) || ((c >= 'a') && (c <= 'z')) || ((c >= '0') && (c <= '9')) || (c == '-') || (c == '.') || (c == '_') || (c == '~') || ((c == '/') && !encodeSlash) {
+			buffer.WriteByte (c);
+		} else {
+			buffer.WriteString (fmt.Sprintf ("%%%02X", c));
+		}
+	}
+	
+	return buffer.String ();
+}
+
+
+func s3HMAC (key []byte, data string) []byte {
+	mac := hmac.New (sha256.New, key);
+	mac.Write ([]byte (data));
+	return mac.Sum (nil);
+}
+
+
+func s3SHA256 (data []byte) string {
+	return fmt.Sprintf ("%x", sha256.Sum256 (data));
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// newRequest
+// creates a signed request for an object of the bucket
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (backend *NetS3StorageBackend) newRequest (method string, name string, query url.Values, body io.Reader, contentlength int64, payloadhash string) (*http.Request, error) {
+
+	canonicaluri := "/" + s3URIEncode (backend.Bucket, true) + "/" + s3URIEncode (path.Join (backend.Prefix, name), false);
+	
+	return backend.newSignedRequest (method, canonicaluri, query, body, contentlength, payloadhash);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// newSignedRequest
+// creates a request for an encoded path of the endpoint and signs it
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (backend *NetS3StorageBackend) newSignedRequest (method string, canonicaluri string, query url.Values, body io.Reader, contentlength int64, payloadhash string) (*http.Request, error) {
+
+	keys := make ([]string, 0, len (query));
+	for key := range query {
+		keys = append (keys, key);
+	}
+	sort.Strings (keys);
+	
+	parameters := make ([]string, 0, len (keys));
+	for _, key := range keys {
+		parameters = append (parameters, s3URIEncode (key, true) + "=" + s3URIEncode (query.Get (key), true));
+	}
+	canonicalquery := strings.Join (parameters, "&");
+	
+	requesturl := backend.Endpoint + canonicaluri;
+	if (canonicalquery != "") {
+		requesturl = requesturl + "?" + canonicalquery;
+	}
+	
+	request, err := http.NewRequest (method, requesturl, body);
+	if (err != nil) {
+		return nil, err;
+	}
+	request.ContentLength = contentlength;
+	
+	now := time.Now ().UTC ();
+	amzdate := now.Format ("20060102T150405Z");
+	datestamp := now.Format ("20060102");
+	scope := datestamp + "/" + backend.Region + "/s3/aws4_request";
+	
+	request.Header.Set ("x-amz-date", amzdate);
+	request.Header.Set ("x-amz-content-sha256", payloadhash);
+	
+	canonicalheaders := "host:" + request.URL.Host + "\n" + "x-amz-content-sha256:" + payloadhash + "\n" + "x-amz-date:" + amzdate + "\n";
+	signedheaders := "host;x-amz-content-sha256;x-amz-date";
+	
+	canonicalrequest := method + "\n" + canonicaluri + "\n" + canonicalquery + "\n" + canonicalheaders + "\n" + signedheaders + "\n" + payloadhash;
+	stringtosign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + s3SHA256 ([]byte (canonicalrequest));
+	
+	signingkey := s3HMAC ([]byte ("AWS4" + backend.SecretKey), datestamp);
+	signingkey = s3HMAC (signingkey, backend.Region);
+	signingkey = s3HMAC (signingkey, "s3");
+	signingkey = s3HMAC (signingkey, "aws4_request");
+	signature := fmt.Sprintf ("%x", s3HMAC (signingkey, stringtosign));
+	
+	request.Header.Set ("Authorization", "AWS4-HMAC-SHA256 Credential=" + backend.AccessKey + "/" + scope + ", SignedHeaders=" + signedheaders + ", Signature=" + signature);
+	
+	return request, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// sendRequest
+// sends a request and turns S3 error replies into errors. The caller closes the response body.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (backend *NetS3StorageBackend) sendRequest (request *http.Request) (*http.Response, error) {
+
+	response, err := backend.Client.Do (request);
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	if ((response.StatusCode >= 200) && (response.StatusCode < 300)) {
+		return response, nil;
+	}
+	
+	defer response.Body.Close ();
+	
+	if (response.StatusCode == http.StatusNotFound) {
+		return nil, os.ErrNotExist;
+	}
+	
+	errordata, _ := ioutil.ReadAll (response.Body);
+	
+	var s3error NetS3Error;
+	if (xml.Unmarshal (errordata, &s3error) == nil) {
+		return nil, errors.New (fmt.Sprintf ("S3 request failed: %s (%s)", s3error.Message, s3error.Code));
+	}
+	
+	return nil, errors.New (fmt.Sprintf ("S3 request failed with status code %d", response.StatusCode));
+}
+
+
+func (backend *NetS3StorageBackend) putObject (name string, file *os.File, size int64) error {
+
+	// a non-nil body without length is sent chunked, which S3 rejects for PUT
+	var body io.Reader = file;
+	if (size == 0) {
+		body = http.NoBody;
+	}
+
+	request, err := backend.newRequest ("PUT", name, nil, body, size, S3_UNSIGNEDPAYLOAD);
+	if (err != nil) {
+		return err;
+	}
+	
+	response, err := backend.sendRequest (request);
+	if (err != nil) {
+		return err;
+	}
+	
+	response.Body.Close ();
+	return nil;
+}
+
+
+func (backend *NetS3StorageBackend) putMultipartObject (name string, file *os.File, size int64) error {
+
+	query := url.Values {};
+	query.Set ("uploads", "");
+	
+	request, err := backend.newRequest ("POST", name, query, nil, 0, S3_EMPTYPAYLOAD);
+	if (err != nil) {
+		return err;
+	}
+	
+	response, err := backend.sendRequest (request);
+	if (err != nil) {
+		return err;
+	}
+	
+	resultdata, err := ioutil.ReadAll (response.Body);
+	response.Body.Close ();
+	if (err != nil) {
+		return err;
+	}
+	
+	var initiateresult NetS3InitiateMultipartUploadResult;
+	err = xml.Unmarshal (resultdata, &initiateresult);
+	if (err != nil) {
+		return err;
+	}
+	
+	uploadid := initiateresult.UploadID;
+	
+	var complete NetS3CompleteMultipartUpload;
+	
+	var offset int64 = 0;
+	for partnumber := 1; offset < size; partnumber++ {
+		partsize := size - offset;
+		if (partsize > S3_PARTSIZE) {
+			partsize = S3_PARTSIZE;
+		}
+		
+		partquery := url.Values {};
+		partquery.Set ("partNumber", fmt.Sprintf ("%d", partnumber));
+		partquery.Set ("uploadId", uploadid);
+		
+		request, err = backend.newRequest ("PUT", name, partquery, io.NewSectionReader (file, offset, partsize), partsize, S3_UNSIGNEDPAYLOAD);
+		if (err == nil) {
+			response, err = backend.sendRequest (request);
+		}
+		if (err != nil) {
+			backend.abortMultipartObject (name, uploadid);
+			return err;
+		}
+		response.Body.Close ();
+		
+		var part NetS3CompletedPart;
+		part.PartNumber = partnumber;
+		part.ETag = response.Header.Get ("ETag");
+		complete.Parts = append (complete.Parts, part);
+		
+		offset = offset + partsize;
+	}
+	
+	completedata, err := xml.Marshal (&complete);
+	if (err != nil) {
+		backend.abortMultipartObject (name, uploadid);
+		return err;
+	}
+	
+	completequery := url.Values {};
+	completequery.Set ("uploadId", uploadid);
+	
+	request, err = backend.newRequest ("POST", name, completequery, bytes.NewReader (completedata), int64 (len (completedata)), s3SHA256 (completedata));
+	if (err == nil) {
+		response, err = backend.sendRequest (request);
+	}
+	if (err != nil) {
+		backend.abortMultipartObject (name, uploadid);
+		return err;
+	}
+	
+	// S3 may report errors of the completion with status 200
+	resultdata, err = ioutil.ReadAll (response.Body);
+	response.Body.Close ();
+	if (err != nil) {
+		return err;
+	}
+	
+	var s3error NetS3Error;
+	if (xml.Unmarshal (resultdata, &s3error) == nil) {
+		backend.abortMultipartObject (name, uploadid);
+		return errors.New (fmt.Sprintf ("S3 request failed: %s (%s)", s3error.Message, s3error.Code));
+	}
+	
+	return nil;
+}
+
+
+func (backend *NetS3StorageBackend) abortMultipartObject (name string, uploadid string) {
+
+	query := url.Values {};
+	query.Set ("uploadId", uploadid);
+	
+	request, err := backend.newRequest ("DELETE", name, query, nil, 0, S3_EMPTYPAYLOAD);
+	if (err != nil) {
+		return;
+	}
+	
+	response, err := backend.sendRequest (request);
+	if (err == nil) {
+		response.Body.Close ();
+	}
+}
+
+
+func (backend *NetS3StorageBackend) StoreFile (name string, localfilename string) error {
+
+	file, err := os.Open (localfilename);
+	if (err != nil) {
+		return err;
+	}
+	
+	fileinfo, err := file.Stat ();
+	if (err != nil) {
+		file.Close ();
+		return err;
+	}
+	
+	if (fileinfo.Size () > S3_PARTSIZE) {
+		err = backend.putMultipartObject (name, file, fileinfo.Size ());
+	} else {
+		err = backend.putObject (name, file, fileinfo.Size ());
+	}
+	file.Close ();
+	
+	if (err != nil) {
+		return err;
+	}
+	
+	return os.Remove (localfilename);
+}
+
+
+func (backend *NetS3StorageBackend) headObject (name string) (int64, error) {
+
+	request, err := backend.newRequest ("HEAD", name, nil, nil, 0, S3_EMPTYPAYLOAD);
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	response, err := backend.sendRequest (request);
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	response.Body.Close ();
+	return response.ContentLength, nil;
+}
+
+
+func (backend *NetS3StorageBackend) Open (name string) (io.ReadSeekCloser, error) {
+
+	size, err := backend.headObject (name);
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	var reader NetS3ObjectReader;
+	reader.Backend = backend;
+	reader.Name = name;
+	reader.Size = size;
+	reader.Offset = 0;
+	reader.Body = nil;
+	
+	return &reader, nil;
+}
+
+
+func (backend *NetS3StorageBackend) Exists (name string) (bool, error) {
+
+	_, err := backend.headObject (name);
+	if (err == nil) {
+		return true, nil;
+	}
+	
+	if (os.IsNotExist (err)) {
+		return false, nil;
+	}
+	
+	return false, err;
+}
+
+
+func (backend *NetS3StorageBackend) Remove (name string) error {
+
+	request, err := backend.newRequest ("DELETE", name, nil, nil, 0, S3_EMPTYPAYLOAD);
+	if (err != nil) {
+		return err;
+	}
+	
+	response, err := backend.sendRequest (request);
+	if (os.IsNotExist (err)) {
+		return nil;
+	}
+	if (err != nil) {
+		return err;
+	}
+	
+	response.Body.Close ();
+	return nil;
+}
+
+
+func (backend *NetS3StorageBackend) List (prefix string) ([]NetStorageBackendFile, error) {
+	files := make ([]NetStorageBackendFile, 0);
+
+	keyprefix := "";
+	if (backend.Prefix != "") {
+		keyprefix = backend.Prefix + "/";
+	}
+	
+	canonicaluri := "/" + s3URIEncode (backend.Bucket, true);
+	continuationtoken := "";
+	
+	for {
+		query := url.Values {};
+		query.Set ("list-type", "2");
+		query.Set ("prefix", keyprefix + prefix);
+		if (continuationtoken != "") {
+			query.Set ("continuation-token", continuationtoken);
+		}
+		
+		request, err := backend.newSignedRequest ("GET", canonicaluri, query, nil, 0, S3_EMPTYPAYLOAD);
+		if (err != nil) {
+			return files, err;
+		}
+		
+		response, err := backend.sendRequest (request);
+		if (err != nil) {
+			return files, err;
+		}
+		
+		resultdata, err := ioutil.ReadAll (response.Body);
+		response.Body.Close ();
+		if (err != nil) {
+			return files, err;
+		}
+		
+		var result NetS3ListBucketResult;
+		err = xml.Unmarshal (resultdata, &result);
+		if (err != nil) {
+			return files, err;
+		}
+		
+		for _, object := range result.Contents {
+			modtime, err := time.Parse (time.RFC3339, object.LastModified);
+			if (err != nil) {
+				return files, err;
+			}
+		
+			var file NetStorageBackendFile;
+			file.Name = strings.TrimPrefix (object.Key, keyprefix);
+			file.Size = object.Size;
+			file.ModTime = modtime;
+			files = append (files, file);
+		}
+		
+		if (!result.IsTruncated) {
+			return files, nil;
+		}
+		
+		if (result.NextContinuationToken == "") {
+			return files, errors.New ("S3 listing is truncated but has no continuation token");
+		}
+		continuationtoken = result.NextContinuationToken;
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// NetS3ObjectReader
+// reads an object with ranged GET requests. A request is only sent when reading, so seeking is free.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type NetS3ObjectReader struct {
+	Backend *NetS3StorageBackend
+	Name string
+	Size int64
+	Offset int64
+	Body io.ReadCloser
+}
+
+func (reader *NetS3ObjectReader) Read (buffer []byte) (int, error) {
+
+	if (reader.Offset >= reader.Size) {
+		return 0, io.EOF;
+	}
+
+	if (reader.Body == nil) {
+		request, err := reader.Backend.newRequest ("GET", reader.Name, nil, nil, 0, S3_EMPTYPAYLOAD);
+		if (err != nil) {
+			return 0, err;
+		}
+		request.Header.Set ("Range", fmt.Sprintf ("bytes=%d-", reader.Offset));
+		
+		response, err := reader.Backend.sendRequest (request);
+		if (err != nil) {
+			return 0, err;
+		}
+		
+		reader.Body = response.Body;
+	}
+	
+	count, err := reader.Body.Read (buffer);
+	reader.Offset = reader.Offset + int64 (count);
+	
+	if (err == io.EOF) {
+		reader.Body.Close ();
+		reader.Body = nil;
+		
+		if (reader.Offset < reader.Size) {
+			err = io.ErrUnexpectedEOF;
+		}
+	}
+	
+	return count, err;
+}
+
+func (reader *NetS3ObjectReader) Seek (offset int64, whence int) (int64, error) {
+
+	var newoffset int64;
+	switch (whence) {
+		case io.SeekStart:
+			newoffset = offset;
+		case io.SeekCurrent:
+			newoffset = reader.Offset + offset;
+		case io.SeekEnd:
+			newoffset = reader.Size + offset;
+		default:
+			return reader.Offset, errors.New ("Invalid seek origin");
+	}
+	
+	if (newoffset < 0) {
+		return reader.Offset, errors.New ("Invalid seek offset");
+	}
+	
+	if ((newoffset != reader.Offset) && (reader.Body != nil)) {
+		reader.Body.Close ();
+		reader.Body = nil;
+	}
+	
+	reader.Offset = newoffset;
+	return newoffset, nil;
+}
+
+func (reader *NetS3ObjectReader) Close () error {
+	if (reader.Body != nil) {
+		err := reader.Body.Close ();
+		reader.Body = nil;
+		return err;
+	}
+	
+	return nil;
+}
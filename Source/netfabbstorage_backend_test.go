@@ -0,0 +1,402 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_backend_test.go
+// Tests of the storage backends. The S3 backend runs against an in-memory fake of the S3 REST API.
+// Run with
+//   go test netfabbstorage_backend_test.go netfabbstorage_backend.go netfabbstorage_backend_s3.go netfabbstorage_config.go
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// fakeS3Server
+// serves PUT, GET, HEAD and DELETE of objects and ListObjectsV2 of a single bucket from memory. 
+// Listings return at most two keys per page to exercise continuation tokens.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const FAKES3_PAGESIZE = 2;
+
+type fakeS3Object struct {
+	Data []byte
+	ModTime time.Time
+}
+
+type fakeS3Server struct {
+	Bucket string
+	Mutex sync.Mutex
+	Objects map[string]fakeS3Object
+}
+
+func newFakeS3Server (bucket string) (*fakeS3Server, *httptest.Server) {
+	var fake fakeS3Server;
+	fake.Bucket = bucket;
+	fake.Objects = make (map[string]fakeS3Object);
+	
+	return &fake, httptest.NewServer (&fake);
+}
+
+func (fake *fakeS3Server) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+
+	if (!strings.HasPrefix (r.Header.Get ("Authorization"), "AWS4-HMAC-SHA256 Credential=")) {
+		http.Error (w, "missing signature", http.StatusForbidden);
+		return;
+	}
+
+	fake.Mutex.Lock();
+	defer fake.Mutex.Unlock();
+	
+	if (r.URL.Path == "/" + fake.Bucket) {
+		if ((r.Method == "GET") && (r.URL.Query().Get ("list-type") == "2")) {
+			fake.listObjects (w, r);
+		} else {
+			http.Error (w, "unsupported bucket request", http.StatusNotImplemented);
+		}
+		return;
+	}
+	
+	if (!strings.HasPrefix (r.URL.Path, "/" + fake.Bucket + "/")) {
+		http.Error (w, "unknown bucket", http.StatusNotFound);
+		return;
+	}
+	key := strings.TrimPrefix (r.URL.Path, "/" + fake.Bucket + "/");
+	
+	switch (r.Method) {
+		case "PUT":
+			// S3 requires the content length of a single PUT
+			if (r.ContentLength < 0) {
+				http.Error (w, "missing content length", http.StatusLengthRequired);
+				return;
+			}
+			
+			data, err := ioutil.ReadAll (r.Body);
+			if (err != nil) {
+				http.Error (w, err.Error (), http.StatusBadRequest);
+				return;
+			}
+			fake.Objects[key] = fakeS3Object {Data: data, ModTime: time.Now ().UTC ()};
+			
+		case "GET", "HEAD":
+			object, found := fake.Objects[key];
+			if (!found) {
+				http.Error (w, "no such key", http.StatusNotFound);
+				return;
+			}
+			
+			data := object.Data;
+			status := http.StatusOK;
+			
+			rangeheader := r.Header.Get ("Range");
+			if (rangeheader != "") {
+				first, err := strconv.Atoi (strings.TrimSuffix (strings.TrimPrefix (rangeheader, "bytes="), "-"));
+				if ((err != nil) || (first < 0) || (first >= len (data))) {
+					http.Error (w, "invalid range", http.StatusRequestedRangeNotSatisfiable);
+					return;
+				}
+				data = data[first:];
+				status = http.StatusPartialContent;
+			}
+			
+			w.Header().Set ("Content-Length", strconv.Itoa (len (data)));
+			w.WriteHeader (status);
+			if (r.Method == "GET") {
+				w.Write (data);
+			}
+			
+		case "DELETE":
+			delete (fake.Objects, key);
+			w.WriteHeader (http.StatusNoContent);
+			
+		default:
+			http.Error (w, "unsupported object request", http.StatusNotImplemented);
+	}
+}
+
+func (fake *fakeS3Server) listObjects (w http.ResponseWriter, r *http.Request) {
+
+	prefix := r.URL.Query().Get ("prefix");
+	
+	keys := make ([]string, 0);
+	for key := range fake.Objects {
+		if (strings.HasPrefix (key, prefix)) {
+			keys = append (keys, key);
+		}
+	}
+	sort.Strings (keys);
+	
+	// the continuation token is the last key of the previous page
+	token := r.URL.Query().Get ("continuation-token");
+	start := sort.SearchStrings (keys, token);
+	if ((token != "") && (start < len (keys)) && (keys[start] == token)) {
+		start++;
+	}
+	
+	var result NetS3ListBucketResult;
+	for i := start; (i < len (keys)) && (len (result.Contents) < FAKES3_PAGESIZE); i++ {
+		var object NetS3ListedObject;
+		object.Key = keys[i];
+		object.Size = int64 (len (fake.Objects[keys[i]].Data));
+		object.LastModified = fake.Objects[keys[i]].ModTime.Format ("2006-01-02T15:04:05.000Z");
+		result.Contents = append (result.Contents, object);
+	}
+	
+	if (start + len (result.Contents) < len (keys)) {
+		result.IsTruncated = true;
+		result.NextContinuationToken = result.Contents[len (result.Contents) - 1].Key;
+	}
+	
+	data, err := xml.Marshal (&result);
+	if (err != nil) {
+		http.Error (w, err.Error (), http.StatusInternalServerError);
+		return;
+	}
+	
+	w.Header().Set ("Content-Type", "application/xml");
+	w.Write (data);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// storeTestFile
+// writes the content to a local file and moves it into the backend
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func storeTestFile (t *testing.T, backend NetStorageBackend, name string, content string) {
+
+	localfilename := filepath.Join (t.TempDir (), "upload.part");
+	
+	err := ioutil.WriteFile (localfilename, []byte (content), 0644);
+	if (err != nil) {
+		t.Fatal (err);
+	}
+	
+	err = backend.StoreFile (name, localfilename);
+	if (err != nil) {
+		t.Fatalf ("StoreFile %s: %v", name, err);
+	}
+	
+	_, err = os.Stat (localfilename);
+	if (!os.IsNotExist (err)) {
+		t.Fatalf ("StoreFile %s did not consume the local file", name);
+	}
+}
+
+
+func listTestFiles (t *testing.T, backend NetStorageBackend, prefix string) map[string]int64 {
+
+	files, err := backend.List (prefix);
+	if (err != nil) {
+		t.Fatalf ("List %s: %v", prefix, err);
+	}
+	
+	sizes := make (map[string]int64);
+	for _, file := range files {
+		if (file.ModTime.IsZero ()) {
+			t.Errorf ("List %s: %s has no modification time", prefix, file.Name);
+		}
+		sizes[file.Name] = file.Size;
+	}
+	
+	return sizes;
+}
+
+
+func expectTestFiles (t *testing.T, backend NetStorageBackend, prefix string, expected map[string]int64) {
+
+	sizes := listTestFiles (t, backend, prefix);
+	if (fmt.Sprint (sizes) != fmt.Sprint (expected)) {
+		t.Errorf ("List %s returned %v, expected %v", prefix, sizes, expected);
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// testStorageBackend
+// stores, reads, lists and removes files the way the storage server uses a backend
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func testStorageBackend (t *testing.T, backend NetStorageBackend) {
+
+	blobname := "blobs/ab/ab0123456789.dat";
+	blobcontent := "0123456789abcdefghij";
+	
+	storeTestFile (t, backend, blobname, blobcontent);
+	storeTestFile (t, backend, "blobs/cd/cd0123456789.dat", "cd");
+	storeTestFile (t, backend, "thumbnails/entity.dat", "png");
+	storeTestFile (t, backend, "entity.dat", "legacy");
+	storeTestFile (t, backend, "blobs/da/da39a3ee5e6b.dat", "");
+	
+	exists, err := backend.Exists (blobname);
+	if ((err != nil) || !exists) {
+		t.Errorf ("Exists %s returned %v, %v", blobname, exists, err);
+	}
+	
+	exists, err = backend.Exists ("blobs/ef/missing.dat");
+	if ((err != nil) || exists) {
+		t.Errorf ("Exists of a missing file returned %v, %v", exists, err);
+	}
+	
+	// full and partial reads
+	file, err := backend.Open (blobname);
+	if (err != nil) {
+		t.Fatalf ("Open %s: %v", blobname, err);
+	}
+	
+	data, err := ioutil.ReadAll (file);
+	if ((err != nil) || (string (data) != blobcontent)) {
+		t.Errorf ("Read %s returned %q, %v", blobname, data, err);
+	}
+	
+	offset, err := file.Seek (-5, io.SeekEnd);
+	if ((err != nil) || (offset != 15)) {
+		t.Errorf ("Seek %s returned %d, %v", blobname, offset, err);
+	}
+	
+	data, err = ioutil.ReadAll (file);
+	if ((err != nil) || (string (data) != "fghij")) {
+		t.Errorf ("Read %s after seek returned %q, %v", blobname, data, err);
+	}
+	
+	_, err = file.Seek (10, io.SeekStart);
+	if (err == nil) {
+		buffer := make ([]byte, 3);
+		_, err = io.ReadFull (file, buffer);
+		if (!bytes.Equal (buffer, []byte ("abc"))) {
+			t.Errorf ("Read %s at offset 10 returned %q", blobname, buffer);
+		}
+	}
+	if (err != nil) {
+		t.Errorf ("Read %s at offset 10: %v", blobname, err);
+	}
+	
+	err = file.Close ();
+	if (err != nil) {
+		t.Errorf ("Close %s: %v", blobname, err);
+	}
+	
+	// empty files
+	file, err = backend.Open ("blobs/da/da39a3ee5e6b.dat");
+	if (err == nil) {
+		data, err = ioutil.ReadAll (file);
+		if (len (data) != 0) {
+			t.Errorf ("Read of an empty file returned %q", data);
+		}
+		file.Close ();
+	}
+	if (err != nil) {
+		t.Errorf ("Read of an empty file: %v", err);
+	}
+	
+	_, err = backend.Open ("blobs/ef/missing.dat");
+	if (!os.IsNotExist (err)) {
+		t.Errorf ("Open of a missing file returned %v", err);
+	}
+	
+	// listings
+	expectTestFiles (t, backend, "", map[string]int64 {
+		blobname: 20, "blobs/cd/cd0123456789.dat": 2, "blobs/da/da39a3ee5e6b.dat": 0, "thumbnails/entity.dat": 3, "entity.dat": 6 });
+	expectTestFiles (t, backend, "blobs/", map[string]int64 {
+		blobname: 20, "blobs/cd/cd0123456789.dat": 2, "blobs/da/da39a3ee5e6b.dat": 0 });
+	expectTestFiles (t, backend, "blobs/ab/ab01", map[string]int64 { blobname: 20 });
+	expectTestFiles (t, backend, "missing/", map[string]int64 {});
+	
+	// removal
+	err = backend.Remove (blobname);
+	if (err != nil) {
+		t.Errorf ("Remove %s: %v", blobname, err);
+	}
+	
+	exists, err = backend.Exists (blobname);
+	if ((err != nil) || exists) {
+		t.Errorf ("Exists of a removed file returned %v, %v", exists, err);
+	}
+	
+	err = backend.Remove (blobname);
+	if (err != nil) {
+		t.Errorf ("Remove of a missing file: %v", err);
+	}
+	
+	expectTestFiles (t, backend, "blobs/", map[string]int64 { "blobs/cd/cd0123456789.dat": 2, "blobs/da/da39a3ee5e6b.dat": 0 });
+}
+
+
+func TestLocalStorageBackend (t *testing.T) {
+	directory := t.TempDir ();
+	
+	testStorageBackend (t, createLocalStorageBackend (directory));
+}
+
+
+func TestS3StorageBackend (t *testing.T) {
+	fake, server := newFakeS3Server ("netfabb");
+	defer server.Close ();
+
+	var config ConfigDefinitionS3;
+	config.Endpoint = server.URL;
+	config.Bucket = "netfabb";
+	config.Prefix = "/storage/";
+	config.AccessKey = "access";
+	config.SecretKey = "secret";
+	
+	backend, err := createS3StorageBackend (config);
+	if (err != nil) {
+		t.Fatal (err);
+	}
+	
+	// objects outside of the prefix belong to someone else and must not be listed
+	fake.Objects["other/entity.dat"] = fakeS3Object {Data: []byte ("other"), ModTime: time.Now ()};
+	
+	testStorageBackend (t, backend);
+	
+	if (len (fake.Objects["storage/thumbnails/entity.dat"].Data) != 3) {
+		t.Errorf ("S3 objects are not stored below the prefix");
+	}
+	if (len (fake.Objects["other/entity.dat"].Data) != 5) {
+		t.Errorf ("S3 object outside of the prefix has been changed");
+	}
+}
@@ -0,0 +1,287 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_batch.go
+// Batch requests of the data API. A batch is an ordered list of operations that is executed in one 
+// transaction, either all operations succeed or none. Operations that create folders or update 
+// entities may carry a temporary id, later operations reference the object with "$<id>" instead of 
+// its uuid. No operation takes an item, so new items cannot carry an id.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+
+const BATCHOPERATION_NEWFOLDER = "newfolder";
+const BATCHOPERATION_NEWITEM = "newitem";
+const BATCHOPERATION_UPDATEENTITY = "updateentity";
+
+const BATCHOBJECT_FOLDER = "folder";
+const BATCHOBJECT_ENTITY = "entity";
+
+const BATCH_MAXOPERATIONS = 1000;
+const BATCH_REFERENCEPREFIX = "$";
+
+
+// An object created by a batch operation with a temporary id
+type NetStorageBatchObject struct {
+	ObjectType string
+	UUID string
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// resolveBatchReference
+// resolves a "$<id>" reference to the uuid of an object created earlier in the batch. Other values 
+// are returned unchanged.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func resolveBatchReference (objects map[string]NetStorageBatchObject, value string, objecttype string) (string, error) {
+
+	if (!strings.HasPrefix (value, BATCH_REFERENCEPREFIX)) {
+		return value, nil;
+	}
+	
+	id := strings.TrimPrefix (value, BATCH_REFERENCEPREFIX);
+	object, found := objects[id];
+	if (!found) {
+		return "", errors.New ("unknown batch id: " + id);
+	}
+	
+	if (object.ObjectType != objecttype) {
+		return "", fmt.Errorf ("batch id %s is a %s, not a %s", id, object.ObjectType, objecttype);
+	}
+	
+	return object.UUID, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// executeBatchOperation
+// executes one operation of a batch within the batch transaction
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func executeBatchOperation (db *sql.DB, session * NetStorageSession, operation NetStorageBatchOperation, objects map[string]NetStorageBatchObject) (NetStorageBatchResult, error) {
+	var result NetStorageBatchResult;
+	result.Operation = operation.Operation;
+	result.ID = operation.ID;
+
+	switch (operation.Operation) {
+	
+		case BATCHOPERATION_NEWFOLDER:
+			parentuuid, err := resolveBatchReference (objects, operation.FolderUUID, BATCHOBJECT_FOLDER);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			folder, err := RetrieveFolderByUUID (db, parentuuid);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			result.UUID = createUUID ();
+			result.ProjectUUID = folder.ProjectUUID;
+			result.FolderUUID = folder.UUID;
+			
+			return result, createNewFolder (db, result.UUID, folder.ProjectUUID, operation.FolderName, folder.UUID);
+			
+		case BATCHOPERATION_NEWITEM:
+			folderuuid, err := resolveBatchReference (objects, operation.FolderUUID, BATCHOBJECT_FOLDER);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			folder, err := RetrieveFolderByUUID (db, folderuuid);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			result.UUID = createUUID ();
+			result.ProjectUUID = folder.ProjectUUID;
+			result.FolderUUID = folder.UUID;
+			
+			return result, createNewItem (db, result.UUID, folder.UUID, operation.ItemName);
+			
+		case BATCHOPERATION_UPDATEENTITY:
+			entityuuid, err := resolveBatchReference (objects, operation.EntityUUID, BATCHOBJECT_ENTITY);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			entity, err := RetrieveEntityByUUID (db, entityuuid, false);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			item, err := RetrieveItemByUUID (db, entity.ItemUUID);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			err = checkItemLock (item, session.UserID);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			err = updateEntity (db, entity.UUID, operation.DataType, string (operation.MetaData), true);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			if (operation.Comment != "") {
+				err = updateEntityComment (db, entity.UUID, operation.Comment);
+				if (err != nil) {
+					return result, err;
+				}
+			}
+			
+			entity, err = RetrieveEntityByUUID (db, entity.UUID, true);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			result.UUID = entity.UUID;
+			result.ProjectUUID = item.ProjectUUID;
+			result.FolderUUID = item.FolderUUID;
+			result.ItemUUID = item.UUID;
+			result.VersionNumber = entity.VersionNumber;
+			return result, nil;
+			
+		default:
+			return result, errors.New ("invalid batch operation: " + operation.Operation);
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// executeBatch
+// executes all operations of a batch in one transaction and rolls back all of them if one fails
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func executeBatch (db *sql.DB, session * NetStorageSession, operations []NetStorageBatchOperation) ([]NetStorageBatchResult, map[string]string, error) {
+
+	results := make ([]NetStorageBatchResult, 0);
+	ids := make (map[string]string);
+	objects := make (map[string]NetStorageBatchObject);
+	
+	err := BeginTransaction (db);
+	if (err != nil) {
+		return nil, nil, err;
+	}
+	
+	for index, operation := range operations {
+		if (operation.ID != "") {
+			if (operation.Operation == BATCHOPERATION_NEWITEM) {
+				RollbackTransaction (db);
+				return nil, nil, fmt.Errorf ("batch operation %d: no operation references items, %s does not take a batch id", index, operation.Operation);
+			}
+			
+			_, exists := objects[operation.ID];
+			if (exists) {
+				RollbackTransaction (db);
+				return nil, nil, fmt.Errorf ("batch operation %d: duplicate batch id %s", index, operation.ID);
+			}
+		}
+		
+		result, err := executeBatchOperation (db, session, operation, objects);
+		if (err != nil) {
+			RollbackTransaction (db);
+			return nil, nil, fmt.Errorf ("batch operation %d (%s) failed: %s", index, operation.Operation, err.Error ());
+		}
+		
+		if (operation.ID != "") {
+			switch (operation.Operation) {
+				case BATCHOPERATION_NEWFOLDER:
+					objects[operation.ID] = NetStorageBatchObject { ObjectType: BATCHOBJECT_FOLDER, UUID: result.UUID };
+				default:
+					objects[operation.ID] = NetStorageBatchObject { ObjectType: BATCHOBJECT_ENTITY, UUID: result.UUID };
+			}
+			ids[operation.ID] = result.UUID;
+		}
+		
+		result.Index = index;
+		results = append (results, result);
+	}
+	
+	err = CommittTransaction (db);
+	if (err != nil) {
+		return nil, nil, err;
+	}
+	
+	return results, ids, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageBatchHandler
+// handles a /batch POST request and executes a list of data operations in one transaction. Returns 
+// the result of every operation and the uuids of the temporary ids.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageBatchHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
+	
+	// Parse JSON request
+	var request NetStorageBatchRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_BATCH);
+	if (err != nil) {
+		return err;
+	}
+	
+	addLogMessage (session, fmt.Sprintf ("Executing batch of %d operations", len (request.Operations)), LOGTYPE_DATA_BATCH, LOGLEVEL_CONSOLE);	
+	
+	if (len (request.Operations) > BATCH_MAXOPERATIONS) {
+		return fmt.Errorf ("batch exceeds the maximum of %d operations", BATCH_MAXOPERATIONS);
+	}
+	
+	results, ids, err := executeBatch (db, session, request.Operations);
+	if (err != nil) {
+		return err;
+	}
+	
+	// updated entities are queued for analysis once their changes are committed
+	for _, result := range results {
+		if (result.Operation == BATCHOPERATION_UPDATEENTITY) {
+			queueEntityAnalysis (db, session, result.UUID);
+		}
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageBatchReply;
+	reply.Protocol = PROTOCOL_BATCH;
+	reply.Version = PROTOCOL_VERSION;
+	reply.Results = results;
+	reply.IDs = ids;
+	return sendJSON (w, &reply);			
+}
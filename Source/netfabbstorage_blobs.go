@@ -0,0 +1,252 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_blobs.go
+// Content addressed blob store. Entities reference their file content by SHA1, identical uploads 
+// share one blob and a blob is only removed when no entity references it anymore. Blobs can be stored 
+// compressed, their file size is always the size of the uncompressed content.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+)
+
+
+// Serializes reference counting with the creation and removal of blob files
+var BlobStoreMutex sync.Mutex;
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveBlobBySHA1
+// retrieves a blob by its SHA1 sum
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveBlobBySHA1 (db *sql.DB, sha1sum string) (NetStorageBlob, error) {
+	var blob NetStorageBlob;
+	
+	statement, err := db.Prepare ("SELECT sha1, filesize, refcount, compression, storedsize FROM netstorage_blobs WHERE sha1=?");
+	if (err != nil) {
+		return blob, err;
+	}
+		
+	rows, err := statement.Query(sha1sum);
+	if (err != nil) {
+		return blob, err;
+	}
+	
+	defer rows.Close();
+
+	if (!rows.Next()) {
+		return blob, errors.New("blob not found: " + sha1sum);		
+	}	
+	
+	err = rows.Scan (&blob.SHA1, &blob.FileSize, &blob.RefCount, &blob.Compression, &blob.StoredSize);
+	
+	return blob, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// storeBlob
+// moves a completely written temporary file into the blob store and adds a reference to the blob. 
+// If the blob already exists, the temporary file is discarded. New blobs are compressed with the 
+// configured compression, unless that does not make them smaller.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func storeBlob (db *sql.DB, tempfilename string, sha1sum string, filesize int64) (error) {
+
+	if (len (sha1sum) < 2) {
+		return errors.New ("Invalid blob SHA1: " + sha1sum);
+	}
+
+	exists, err := blobExists (db, sha1sum);
+	if (err != nil) {
+		return err;
+	}
+	
+	// compress outside of the lock, other uploads do not need to wait for it
+	compression := BLOBCOMPRESSION_NONE;
+	storedfilename := tempfilename;
+	storedsize := filesize;
+	if (!exists && (GlobalConfig.Data.Compression != BLOBCOMPRESSION_NONE)) {
+		compressedfilename, compressedsize, err := compressTemporaryFile (tempfilename, GlobalConfig.Data.Compression);
+		if (err != nil) {
+			return err;
+		}
+		
+		if (compressedsize < filesize) {
+			os.Remove (tempfilename);
+			compression = GlobalConfig.Data.Compression;
+			storedfilename = compressedfilename;
+			storedsize = compressedsize;
+		} else {
+			os.Remove (compressedfilename);
+		}
+	}
+
+	BlobStoreMutex.Lock();
+	defer BlobStoreMutex.Unlock();
+	
+	exists, err = blobExists (db, sha1sum);
+	if (err != nil) {
+		os.Remove (storedfilename);
+		return err;
+	}
+	
+	// a file without blob entry is left over from a failed upload, its compression is unknown and it is replaced
+	if (exists) {
+		err = os.Remove (storedfilename);
+	} else {
+		err = StorageBackend.StoreFile (getBlobStorageName (sha1sum), storedfilename);
+	}
+	if (err != nil) {
+		return err;
+	}
+
+	statement, err := db.Prepare ("INSERT INTO netstorage_blobs (sha1, filesize, refcount, compression, storedsize) VALUES (?, ?, 1, ?, ?) ON CONFLICT(sha1) DO UPDATE SET refcount=refcount+1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(sha1sum, filesize, compression, storedsize);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// discardOrphanBlob
+// removes the file of a blob after the transaction that has stored it has been rolled back. The 
+// file is kept if the blob is still registered, as other entities reference it.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func discardOrphanBlob (db *sql.DB, sha1sum string) (error) {
+
+	BlobStoreMutex.Lock();
+	defer BlobStoreMutex.Unlock();
+	
+	exists, err := blobExists (db, sha1sum);
+	if ((err != nil) || exists) {
+		return err;
+	}
+	
+	return StorageBackend.Remove (getBlobStorageName (sha1sum));
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// blobExists
+// checks if a blob is registered in the blob store
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func blobExists (db *sql.DB, sha1sum string) (bool, error) {
+
+	count := 0;
+	err := db.QueryRow ("SELECT COUNT(*) FROM netstorage_blobs WHERE sha1=?", sha1sum).Scan (&count);
+	
+	return (count > 0), err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// releaseBlob
+// removes a reference from a blob and deletes the blob file once it is not referenced anymore
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func releaseBlob (db *sql.DB, sha1sum string) (error) {
+
+	BlobStoreMutex.Lock();
+	defer BlobStoreMutex.Unlock();
+	
+	blob, err := RetrieveBlobBySHA1 (db, sha1sum);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (blob.RefCount > 1) {
+		statement, err := db.Prepare ("UPDATE netstorage_blobs SET refcount=refcount-1 WHERE sha1=?");
+		if (err != nil) {
+			return err;
+		}
+		
+		_, err = statement.Exec(sha1sum);	
+		return err;
+	}
+	
+	statement, err := db.Prepare ("DELETE FROM netstorage_blobs WHERE sha1=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(sha1sum);	
+	if (err != nil) {
+		return err;
+	}
+	
+	return StorageBackend.Remove (getBlobStorageName (sha1sum));
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// getEntityStorageName
+// returns the storage name and the compression of the content of an entity. Entities that have been 
+// uploaded before the blob store existed are still stored uncompressed as <entityuuid>.dat.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func getEntityStorageName (db *sql.DB, entity NetStorageEntity) (string, string, error) {
+
+	if (entity.SHA1 == "") {
+		return getUUIDStorageName (entity.UUID), BLOBCOMPRESSION_NONE, nil;
+	}
+
+	statement, err := db.Prepare ("SELECT compression FROM netstorage_blobs WHERE sha1=?");
+	if (err != nil) {
+		return "", "", err;
+	}
+		
+	rows, err := statement.Query(entity.SHA1);
+	if (err != nil) {
+		return "", "", err;
+	}
+	
+	defer rows.Close();
+
+	if (rows.Next()) {
+		compression := BLOBCOMPRESSION_NONE;
+		err = rows.Scan (&compression);
+		
+		return getBlobStorageName (entity.SHA1), compression, err;
+	}
+	
+	return getUUIDStorageName (entity.UUID), BLOBCOMPRESSION_NONE, nil;
+}
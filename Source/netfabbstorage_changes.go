@@ -0,0 +1,422 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_changes.go
+// Change journal of hubs, projects, folders, items, entities and tasks. Every change is recorded with a 
+// monotonically increasing sequence number, so that clients can synchronize incrementally by asking 
+// for the changes after the cursor of their last synchronization.
+//
+// Deleting a folder or project records a deletion of every contained folder and item, restoring it 
+// records a restore of each of them. Moving a folder to another project records an update in both 
+// projects, clients of the new project need to retrieve the subtree of the folder.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+
+const CHANGETYPE_CREATE = "create";
+const CHANGETYPE_UPDATE = "update";
+const CHANGETYPE_DELETE = "delete";
+const CHANGETYPE_RESTORE = "restore";
+
+const CHANGEOBJECT_HUB = "hub";
+const CHANGEOBJECT_PROJECT = DELETIONTYPE_PROJECT;
+const CHANGEOBJECT_FOLDER = DELETIONTYPE_FOLDER;
+const CHANGEOBJECT_ITEM = DELETIONTYPE_ITEM;
+const CHANGEOBJECT_ENTITY = DELETIONTYPE_ENTITY;
+const CHANGEOBJECT_TASK = "task";
+
+const CHANGESCOPE_ALL = "all";
+const CHANGESCOPE_HUB = "hub";
+const CHANGESCOPE_PROJECT = "project";
+
+const CHANGES_DEFAULTLIMIT = 1000;
+const CHANGES_LATESTCURSOR = "latest";
+
+// Selects the hub, project and parent folder uuid of a changed object
+var changeScopeQueries = map[string]string {
+	CHANGEOBJECT_HUB: "SELECT uuid, '', '' FROM netstorage_hubs WHERE uuid=?",
+	CHANGEOBJECT_PROJECT: "SELECT hubuuid, uuid, '' FROM netstorage_projects WHERE uuid=?",
+	CHANGEOBJECT_FOLDER: "SELECT IFNULL(netstorage_projects.hubuuid, ''), netstorage_folders.projectuuid, netstorage_folders.parentuuid FROM netstorage_folders " +
+		"LEFT JOIN netstorage_projects ON netstorage_projects.uuid=netstorage_folders.projectuuid WHERE netstorage_folders.uuid=?",
+	CHANGEOBJECT_ITEM: "SELECT IFNULL(netstorage_projects.hubuuid, ''), netstorage_folders.projectuuid, netstorage_items.folderuuid FROM netstorage_items " +
+		"JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid " +
+		"LEFT JOIN netstorage_projects ON netstorage_projects.uuid=netstorage_folders.projectuuid WHERE netstorage_items.uuid=?",
+	CHANGEOBJECT_ENTITY: "SELECT IFNULL(netstorage_projects.hubuuid, ''), netstorage_folders.projectuuid, netstorage_items.folderuuid FROM netstorage_entities " +
+		"JOIN netstorage_items ON netstorage_items.uuid=netstorage_entities.itemuuid " +
+		"JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid " +
+		"LEFT JOIN netstorage_projects ON netstorage_projects.uuid=netstorage_folders.projectuuid WHERE netstorage_entities.uuid=?",
+	CHANGEOBJECT_TASK: "SELECT '', '', '' FROM netstorage_tasks WHERE uuid=?",
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// recordChange
+// appends a change of an object to the journal. Objects that do not exist are ignored.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func recordChange (db *sql.DB, objecttype string, objectuuid string, change string) (error) {
+
+	scopequery, found := changeScopeQueries[objecttype];
+	if (!found) {
+		return errors.New ("Invalid change object type: " + objecttype);
+	}
+	
+	timestamp := time.Now().Format(time.RFC3339);
+
+	statement, err := db.Prepare ("INSERT INTO netstorage_changes (objecttype, objectuuid, change, hubuuid, projectuuid, folderuuid, timestamp) " +
+		"SELECT ?, ?, ?, scope.*, ? FROM (" + scopequery + ") AS scope");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(objecttype, objectuuid, change, timestamp, objectuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	// inside of a transaction, the listeners are signaled again after committing
+	signalChangeListeners ();
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// recordDeletionChanges
+// appends a change of every object of a deletion to the journal, projects and folders first
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func recordDeletionChanges (db *sql.DB, deletionuuid string, change string) (error) {
+
+	objecttypes := []string { CHANGEOBJECT_PROJECT, CHANGEOBJECT_FOLDER, CHANGEOBJECT_ITEM, CHANGEOBJECT_ENTITY };
+	
+	for _, objecttype := range objecttypes {
+		objectuuids, err := RetrieveDeletedObjects (db, deletionuuid, objecttype);
+		if (err != nil) {
+			return err;
+		}
+		
+		for _, objectuuid := range objectuuids {
+			err = recordChange (db, objecttype, objectuuid, change);
+			if (err != nil) {
+				return err;
+			}
+		}
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// recordTransactionTaskChanges
+// appends an update of every task that has been changed with a task transaction to the journal
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func recordTransactionTaskChanges (db *sql.DB, transactionuuid string) (error) {
+
+	rows, err := db.Query ("SELECT uuid FROM netstorage_tasks WHERE transactionuuid=?", transactionuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	var taskuuids []string;
+	for (rows.Next()) {
+		taskuuid := "";
+		err = rows.Scan (&taskuuid);
+		if (err != nil) {
+			rows.Close ();
+			return err;
+		}
+		
+		taskuuids = append (taskuuids, taskuuid);
+	}
+	rows.Close ();
+	
+	for _, taskuuid := range taskuuids {
+		err = recordChange (db, CHANGEOBJECT_TASK, taskuuid, CHANGETYPE_UPDATE);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveChangeHorizon
+// returns the sequence number of the latest change and the oldest cursor that can still be resumed. 
+// Cursors before the horizon refer to changes that have been pruned from the journal.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveChangeHorizon (db *sql.DB) (int64, int64, error) {
+
+	var latest int64;
+	err := db.QueryRow ("SELECT IFNULL((SELECT seq FROM sqlite_sequence WHERE name='netstorage_changes'), 0)").Scan (&latest);
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	var horizon int64;
+	err = db.QueryRow ("SELECT IFNULL(MIN(sequence) - 1, ?) FROM netstorage_changes", latest).Scan (&horizon);
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	return latest, horizon, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveChanges
+// retrieves the changes of a scope after a sequence number in journal order. Returns the changes and 
+// whether there are more changes after the last one.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveChanges (db *sql.DB, scope string, scopeuuid string, after int64, limit int) ([]NetStorageChange, bool, error) {
+	changes := make ([]NetStorageChange, 0);
+
+	condition := "sequence>?";
+	arguments := []interface{} { after };
+	
+	switch (scope) {
+		case CHANGESCOPE_ALL:
+		case CHANGESCOPE_HUB:
+			condition += " AND hubuuid=?";
+			arguments = append (arguments, scopeuuid);
+		case CHANGESCOPE_PROJECT:
+			condition += " AND projectuuid=?";
+			arguments = append (arguments, scopeuuid);
+		default:
+			return changes, false, errors.New ("Invalid change scope: " + scope);
+	}
+	
+	// one row more than the limit is queried to know if there are more changes
+	arguments = append (arguments, limit + 1);
+	
+	rows, err := db.Query ("SELECT sequence, objecttype, objectuuid, change, hubuuid, projectuuid, folderuuid, timestamp FROM netstorage_changes WHERE " + condition + " ORDER BY sequence LIMIT ?", arguments...);
+	if (err != nil) {
+		return changes, false, err;
+	}
+	
+	defer rows.Close ();
+	
+	for (rows.Next()) {
+		if (len (changes) >= limit) {
+			return changes, true, nil;
+		}
+	
+		var change NetStorageChange;
+		err = rows.Scan (&change.Sequence, &change.ObjectType, &change.ObjectUUID, &change.Change, &change.HubUUID, &change.ProjectUUID, &change.FolderUUID, &change.TimeStamp);
+		if (err != nil) {
+			return changes, false, err;
+		}
+		
+		changes = append (changes, change);
+	}
+	
+	return changes, false, rows.Err ();
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// parseChangeCursor
+// returns the sequence number of the latest change and the sequence number a cursor refers to. An 
+// empty cursor refers to the start of the journal, "latest" to its end.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func parseChangeCursor (db *sql.DB, cursor string) (int64, int64, error) {
+
+	latest, horizon, err := RetrieveChangeHorizon (db);
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	var after int64;
+	switch (cursor) {
+		case "":
+			after = horizon;
+		case CHANGES_LATESTCURSOR:
+			after = latest;
+		default:
+			after, err = strconv.ParseInt (cursor, 10, 64);
+			if ((err != nil) || (after < 0) || (after > latest)) {
+				return 0, 0, errors.New ("Invalid change cursor: " + cursor);
+			}
+	}
+	
+	if (after < horizon) {
+		return 0, 0, errors.New ("Change cursor has expired, a full synchronization is required: " + cursor);
+	}
+	
+	return latest, after, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// pruneChanges
+// removes the journal entries that are older than the retention period. Returns the number of 
+// removed entries.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func pruneChanges (db *sql.DB, retentiondays int) (int, error) {
+
+	expiry := time.Now().AddDate (0, 0, -retentiondays);
+	
+	// timestamps are compared as times, their string form depends on the time zone of the server
+	rows, err := db.Query ("SELECT sequence, timestamp FROM netstorage_changes ORDER BY sequence");
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	var lastexpired int64;
+	for (rows.Next()) {
+		var sequence int64;
+		timestamp := "";
+		err = rows.Scan (&sequence, &timestamp);
+		if (err != nil) {
+			rows.Close ();
+			return 0, err;
+		}
+		
+		changetime, err := time.Parse (time.RFC3339, timestamp);
+		if ((err != nil) || changetime.After (expiry)) {
+			break;
+		}
+		
+		lastexpired = sequence;
+	}
+	rows.Close ();
+	
+	if (lastexpired == 0) {
+		return 0, nil;
+	}
+	
+	result, err := db.Exec ("DELETE FROM netstorage_changes WHERE sequence<=?", lastexpired);
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	count, err := result.RowsAffected ();
+	return int (count), err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// runChangePruner
+// prunes the change journal on startup and then in the configured interval, independent of the 
+// garbage collection
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func runChangePruner (config ConfigDefinitionChanges) {
+
+	for {
+		session := createEmptySession ();
+		
+		db, err := OpenDB (GlobalConfig.Database.Type, GlobalConfig.Database.FileName);
+		if (err == nil) {
+			var count int;
+			count, err = pruneChanges (db, config.RetentionDays);
+			db.Close ();
+			
+			if ((err == nil) && (count > 0)) {
+				addLogMessage (&session, fmt.Sprintf ("Pruned %d expired changes", count), LOGTYPE_DATA_CHANGES, LOGLEVEL_CONSOLE);	
+			}
+		}
+		
+		if (err != nil) {
+			addLogMessage (&session, "Pruning changes failed: " + err.Error (), LOGTYPE_DATA_CHANGES, LOGLEVEL_CONSOLE);	
+		}
+		
+		if (config.IntervalMinutes <= 0) {
+			return;
+		}
+		time.Sleep (time.Duration (config.IntervalMinutes) * time.Minute);
+	}
+	
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageChangesHandler
+// handles a /changes, /hubs/<uuid>/changes or /projects/<uuid>/changes GET request and retrieves the 
+// changes after the cursor=<cursor> query parameter. Without cursor, all retained changes are returned. 
+// cursor=latest returns no changes but the current cursor, clients retrieve it before walking the 
+// tree for their initial synchronization. The number of changes can be limited with limit=<n>.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageChangesHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, scope string, scopeuuid string) error {
+	addLogMessage (session, fmt.Sprintf ("Retrieving changes of %s %s", scope, scopeuuid), LOGTYPE_DATA_CHANGES, LOGLEVEL_CONSOLE);	
+
+	parameters := r.URL.Query ();
+	
+	limit := CHANGES_DEFAULTLIMIT;
+	limitparameter := parameters.Get ("limit");
+	if (limitparameter != "") {
+		value, err := strconv.Atoi (limitparameter);
+		if ((err != nil) || (value <= 0) || (value > LISTING_MAXLIMIT)) {
+			return errors.New ("Invalid change limit: " + limitparameter);
+		}
+		limit = value;
+	}
+	
+	latest, after, err := parseChangeCursor (db, parameters.Get ("cursor"));
+	if (err != nil) {
+		return err;
+	}
+
+	changes, hasmore, err := RetrieveChanges (db, scope, scopeuuid, after, limit);
+	if (err != nil) {
+		return err;
+	}
+	
+	// without more changes the cursor moves to the end of the journal, also past changes of other scopes
+	next := latest;
+	if (hasmore) {
+		next = changes[len (changes) - 1].Sequence;
+	}
+	
+	var reply NetStorageChangesReply;
+	reply.Protocol = PROTOCOL_CHANGES;
+	reply.Version = PROTOCOL_VERSION;
+	reply.Cursor = strconv.FormatInt (next, 10);
+	reply.HasMore = hasmore;
+	reply.Changes = changes;
+	return sendJSON (w, &reply);			
+}
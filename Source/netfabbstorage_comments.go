@@ -0,0 +1,468 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_comments.go
+// Review comments on items and entity versions. A comment either starts a thread or replies to 
+// another comment of the same item or entity. Comments can only be edited by their author. Deleting 
+// a comment that has replies keeps an empty placeholder, so that the thread stays intact.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+
+const COMMENTTARGET_ITEM = "item";
+const COMMENTTARGET_ENTITY = "entity";
+
+const COMMENT_MAXLENGTH = 10000;
+
+// The columns of netstorage_comments, in the order scanComment expects them
+const SQL_COMMENTFIELDS = "netstorage_comments.uuid, netstorage_comments.itemuuid, netstorage_comments.entityuuid, netstorage_comments.parentuuid, netstorage_comments.userid, netstorage_comments.text, netstorage_comments.timestamp, netstorage_comments.edited, netstorage_comments.deleted";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// checkCommentText
+// trims and validates the text of a comment
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func checkCommentText (text string) (string, error) {
+
+	text = strings.TrimSpace (text);
+	if (text == "") {
+		return "", errors.New ("Comment text is empty");
+	}
+	
+	if (len (text) > COMMENT_MAXLENGTH) {
+		return "", errors.New ("Comment text is too long");
+	}
+	
+	return text, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// resolveCommentTarget
+// returns the item and entity uuid of the object a comment is attached to. The entity uuid is empty 
+// for comments on the item itself.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func resolveCommentTarget (db *sql.DB, target string, targetuuid string) (string, string, error) {
+
+	switch (target) {
+		case COMMENTTARGET_ITEM:
+			item, err := RetrieveItemByUUID (db, targetuuid);
+			if (err != nil) {
+				return "", "", err;
+			}
+			return item.UUID, "", nil;
+		
+		case COMMENTTARGET_ENTITY:
+			entity, err := RetrieveEntityByUUID (db, targetuuid, true);
+			if (err != nil) {
+				return "", "", err;
+			}
+			
+			// comments on versions of deleted items are not accessible either
+			item, err := RetrieveItemByUUID (db, entity.ItemUUID);
+			if (err != nil) {
+				return "", "", err;
+			}
+			return item.UUID, entity.UUID, nil;
+	}
+	
+	return "", "", errors.New ("Invalid comment target: " + target);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// scanComment
+// reads a comment from a result row of SQL_COMMENTFIELDS
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func scanComment (scan func (fields ...interface{}) error) (NetStorageComment, error) {
+	var comment NetStorageComment;
+	deleted := 0;
+
+	err := scan (&comment.UUID, &comment.ItemUUID, &comment.EntityUUID, &comment.ParentUUID, &comment.UserID, &comment.Text, &comment.TimeStamp, &comment.Edited, &deleted);
+	comment.Deleted = (deleted != 0);
+	
+	return comment, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveCommentByUUID
+// retrieves a comment by uuid
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveCommentByUUID (db *sql.DB, commentuuid string) (NetStorageComment, error) {
+
+	statement, err := db.Prepare ("SELECT " + SQL_COMMENTFIELDS + " FROM netstorage_comments WHERE uuid=?");
+	if (err != nil) {
+		return NetStorageComment {}, err;
+	}
+	
+	defer statement.Close ();
+
+	rows, err := statement.Query (commentuuid);
+	if (err != nil) {
+		return NetStorageComment {}, err;
+	}
+	
+	defer rows.Close ();
+	
+	if (!rows.Next ()) {
+		return NetStorageComment {}, errors.New ("comment not found: " + commentuuid);
+	}
+	
+	return scanComment (rows.Scan);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveComments
+// retrieves the comments of an item, including the comments on its entities, or the comments of a 
+// single entity. Comments are listed in the order they have been written, replies reference their 
+// parent comment.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveComments (db *sql.DB, itemuuid string, entityuuid string, options NetStorageListOptions) ([]NetStorageComment, NetStorageListPage, error) {
+	comments := make ([]NetStorageComment, 0);
+	var page NetStorageListPage;
+	
+	condition := "netstorage_comments.itemuuid=?";
+	arguments := []interface{} { itemuuid };
+	if (entityuuid != "") {
+		condition += " AND netstorage_comments.entityuuid=?";
+		arguments = append (arguments, entityuuid);
+	}
+	
+	// comments written within the same second keep their insertion order
+	query := NetStorageListQuery {
+		Fields: SQL_COMMENTFIELDS,
+		From: "netstorage_comments",
+		Condition: condition,
+		Arguments: arguments,
+		UUIDField: "netstorage_comments.uuid",
+		SortFields: map[string]string { 
+			LISTSORT_TIMESTAMP: "netstorage_comments.timestamp || printf('%020d', netstorage_comments.rowid)",
+		},
+		DefaultSort: LISTSORT_TIMESTAMP,
+	};
+	
+	rows, err := openList (db, query, options);
+	if (err != nil) {
+		return comments, page, err;
+	}
+	
+	for (rows.Next()) {
+		comment, err := scanComment (rows.Scan);
+		if (err != nil) {
+			rows.Close ();		
+			return comments, page, err;
+		}
+		
+		comments = append (comments, comment);
+	}
+	
+	rows.Close ();		
+	
+	page, err = rows.Page ();
+	return comments, page, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// removeComment
+// deletes a comment. A comment with replies is replaced by a placeholder, deleted placeholders 
+// without remaining replies are removed as well. Returns true if the comment has been removed 
+// entirely.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func removeComment (db *sql.DB, comment NetStorageComment) (bool, error) {
+
+	countstatement, err := db.Prepare ("SELECT COUNT(*) FROM netstorage_comments WHERE parentuuid=?");
+	if (err != nil) {
+		return false, err;
+	}
+	
+	defer countstatement.Close ();
+
+	replycount := 0;
+	err = countstatement.QueryRow (comment.UUID).Scan (&replycount);
+	if (err != nil) {
+		return false, err;
+	}
+	
+	if (replycount > 0) {
+		updatestatement, err := db.Prepare ("UPDATE netstorage_comments SET text='', deleted=1 WHERE uuid=?");
+		if (err != nil) {
+			return false, err;
+		}
+		
+		defer updatestatement.Close ();
+		
+		_, err = updatestatement.Exec (comment.UUID);
+		return false, err;
+	}
+	
+	deletestatement, err := db.Prepare ("DELETE FROM netstorage_comments WHERE uuid=?");
+	if (err != nil) {
+		return false, err;
+	}
+	
+	defer deletestatement.Close ();
+	
+	_, err = deletestatement.Exec (comment.UUID);
+	if (err != nil) {
+		return false, err;
+	}
+	
+	if (comment.ParentUUID != "") {
+		parent, err := RetrieveCommentByUUID (db, comment.ParentUUID);
+		if ((err == nil) && parent.Deleted) {
+			_, err = removeComment (db, parent);
+		}
+		if (err != nil) {
+			return false, err;
+		}
+	}
+	
+	return true, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageCommentsHandler
+// handles a /items/<uuid>/comments or /entities/<uuid>/comments GET request and lists the comments 
+// of an item or entity. The listing parameters limit, cursor and order are supported.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageCommentsHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, target string, targetuuid string) error {
+	addLogMessage (session, "Retrieving comments of " + target + ": " + targetuuid, LOGTYPE_DATA_COMMENTS, LOGLEVEL_CONSOLE);	
+
+	options, err := parseListOptions (r);
+	if (err != nil) {
+		return err;
+	}
+	
+	itemuuid, entityuuid, err := resolveCommentTarget (db, target, targetuuid);
+	if (err != nil) {
+		return err;
+	}
+
+	comments, page, err := RetrieveComments (db, itemuuid, entityuuid, options);
+	if (err == nil) {	
+		var reply NetStorageCommentsReply;
+		reply.Protocol = PROTOCOL_COMMENTS;
+		reply.Version = PROTOCOL_VERSION;
+		reply.NetStorageListPage = page;
+		reply.Comments = comments;		
+		return sendJSON (w, &reply);			
+	}			
+	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageNewCommentHandler
+// handles a /items/<uuid>/comments or /entities/<uuid>/comments POST request and adds a comment of 
+// the session user. If a parent uuid is given, the comment is a reply to it.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageNewCommentHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, target string, targetuuid string) error {
+	addLogMessage (session, "Adding comment to " + target + ": " + targetuuid, LOGTYPE_DATA_COMMENTS, LOGLEVEL_CONSOLE);	
+
+	// Parse JSON request
+	var request NetStorageNewCommentRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_NEWCOMMENT);
+	if (err != nil) {
+		return err;
+	}
+	
+	var comment NetStorageComment;
+	comment.Text, err = checkCommentText (request.Text);
+	if (err != nil) {
+		return err;
+	}
+	
+	comment.ItemUUID, comment.EntityUUID, err = resolveCommentTarget (db, target, targetuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (request.ParentUUID != "") {
+		parent, err := RetrieveCommentByUUID (db, request.ParentUUID);
+		if (err != nil) {
+			return err;
+		}
+		
+		if ((parent.ItemUUID != comment.ItemUUID) || (parent.EntityUUID != comment.EntityUUID)) {
+			return errors.New ("parent comment belongs to another " + target + ": " + parent.UUID);
+		}
+		
+		if (parent.Deleted) {
+			return errors.New ("parent comment has been deleted: " + parent.UUID);
+		}
+		
+		comment.ParentUUID = parent.UUID;
+	}
+	
+	comment.UUID = createUUID ();
+	comment.UserID = session.UserID;
+	comment.TimeStamp = time.Now().Format(time.RFC3339);
+	
+	statement, err := db.Prepare ("INSERT INTO netstorage_comments (uuid, itemuuid, entityuuid, parentuuid, userid, text, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?)");
+	if (err != nil) {
+		return err;
+	}
+	
+	defer statement.Close ();
+	
+	_, err = statement.Exec (comment.UUID, comment.ItemUUID, comment.EntityUUID, comment.ParentUUID, comment.UserID, comment.Text, comment.TimeStamp);
+	if (err != nil) {
+		return err;
+	}
+	
+	var reply NetStorageCommentReply;
+	reply.Protocol = PROTOCOL_NEWCOMMENT;
+	reply.Version = PROTOCOL_VERSION;
+	reply.Comment = comment;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageEditCommentHandler
+// handles a /comments/<uuid> POST request and changes the text of a comment of the session user
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageEditCommentHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, commentuuid string) error {
+	addLogMessage (session, "Editing comment: " + commentuuid, LOGTYPE_DATA_COMMENTS, LOGLEVEL_CONSOLE);	
+
+	// Parse JSON request
+	var request NetStorageEditCommentRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_EDITCOMMENT);
+	if (err != nil) {
+		return err;
+	}
+	
+	text, err := checkCommentText (request.Text);
+	if (err != nil) {
+		return err;
+	}
+	
+	comment, err := RetrieveCommentByUUID (db, commentuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (comment.Deleted) {
+		return errors.New ("comment has been deleted: " + comment.UUID);
+	}
+	
+	if (comment.UserID != session.UserID) {
+		return errors.New ("comment can only be edited by its author: " + comment.UUID);
+	}
+	
+	comment.Text = text;
+	comment.Edited = time.Now().Format(time.RFC3339);
+	
+	statement, err := db.Prepare ("UPDATE netstorage_comments SET text=?, edited=? WHERE uuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	defer statement.Close ();
+	
+	_, err = statement.Exec (comment.Text, comment.Edited, comment.UUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	var reply NetStorageCommentReply;
+	reply.Protocol = PROTOCOL_EDITCOMMENT;
+	reply.Version = PROTOCOL_VERSION;
+	reply.Comment = comment;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageDeleteCommentHandler
+// handles a /comments/<uuid> DELETE request. Comments can be deleted by their author and by 
+// administrators.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageDeleteCommentHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, commentuuid string) error {
+	addLogMessage (session, "Deleting comment: " + commentuuid, LOGTYPE_DATA_COMMENTS, LOGLEVEL_CONSOLE);	
+
+	comment, err := RetrieveCommentByUUID (db, commentuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (comment.UserID != session.UserID) {
+		err = checkAdministratorSession (session);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	removed, err := removeComment (db, comment);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = CommittTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	var reply NetStorageDeleteCommentReply;
+	reply.Protocol = PROTOCOL_DELETECOMMENT;
+	reply.Version = PROTOCOL_VERSION;
+	reply.CommentUUID = comment.UUID;
+	reply.Removed = removed;
+	return sendJSON (w, &reply);			
+}
@@ -0,0 +1,260 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_compression.go
+// At-rest compression of blobs. The compression of new blobs is selected in the <data> config 
+// element, every blob records the compression it has been stored with, so that changing the config 
+// does not affect existing blobs. Downloads decompress blobs while they are sent. The mesh readers 
+// need to seek and get the content decompressed into a temporary file.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+
+const BLOBCOMPRESSION_NONE = "";
+const BLOBCOMPRESSION_GZIP = "gzip";
+const BLOBCOMPRESSION_ZSTD = "zstd";
+
+
+// A temporary local file that is removed when it is closed
+type NetStorageTemporaryFile struct {
+	*os.File
+}
+
+func (file *NetStorageTemporaryFile) Close () error {
+	err := file.File.Close ();
+	os.Remove (file.Name ());
+	return err;
+}
+
+
+// A decompressing reader that closes the stored file together with the decompressor
+type NetStorageDecompressedStream struct {
+	io.ReadCloser
+	File io.Closer
+}
+
+func (stream *NetStorageDecompressedStream) Close () error {
+	err := stream.ReadCloser.Close ();
+	fileerr := stream.File.Close ();
+	if (err == nil) {
+		err = fileerr;
+	}
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// checkBlobCompression
+// checks the compression attribute of the <data> config element. "none" and an empty attribute 
+// store blobs uncompressed.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func checkBlobCompression (compression string) (string, error) {
+
+	switch (compression) {
+		case "none", BLOBCOMPRESSION_NONE:
+			return BLOBCOMPRESSION_NONE, nil;
+			
+		case BLOBCOMPRESSION_GZIP, BLOBCOMPRESSION_ZSTD:
+			return compression, nil;
+			
+		default:
+			return "", errors.New ("Invalid data compression: " + compression);
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createCompressor
+// returns a writer that compresses into a file
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createCompressor (compression string, writer io.Writer) (io.WriteCloser, error) {
+
+	switch (compression) {
+		case BLOBCOMPRESSION_GZIP:
+			return gzip.NewWriter (writer), nil;
+			
+		case BLOBCOMPRESSION_ZSTD:
+			return zstd.NewWriter (writer);
+			
+		default:
+			return nil, errors.New ("Invalid blob compression: " + compression);
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createDecompressor
+// returns a reader that decompresses a stored blob
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createDecompressor (compression string, reader io.Reader) (io.ReadCloser, error) {
+
+	switch (compression) {
+		case BLOBCOMPRESSION_GZIP:
+			return gzip.NewReader (reader);
+			
+		case BLOBCOMPRESSION_ZSTD:
+			decoder, err := zstd.NewReader (reader);
+			if (err != nil) {
+				return nil, err;
+			}
+			return decoder.IOReadCloser (), nil;
+			
+		default:
+			return nil, errors.New ("Invalid blob compression: " + compression);
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// compressTemporaryFile
+// compresses a completely written temporary file into a new temporary file and returns its name 
+// and size. The original file is kept.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func compressTemporaryFile (tempfilename string, compression string) (string, int64, error) {
+
+	file, err := os.Open (tempfilename);
+	if (err != nil) {
+		return "", 0, err;
+	}
+	defer file.Close ();
+	
+	compressedfilename := getUploadStorageName (createUUID ());
+	compressedfile, err := os.Create (compressedfilename);
+	if (err != nil) {
+		return "", 0, err;
+	}
+	
+	compressor, err := createCompressor (compression, compressedfile);
+	if (err == nil) {
+		_, err = io.Copy (compressor, file);
+		
+		closeerr := compressor.Close ();
+		if (err == nil) {
+			err = closeerr;
+		}
+	}
+	
+	closeerr := compressedfile.Close ();
+	if (err == nil) {
+		err = closeerr;
+	}
+	
+	if (err != nil) {
+		os.Remove (compressedfilename);
+		return "", 0, err;
+	}
+	
+	info, err := os.Stat (compressedfilename);
+	if (err != nil) {
+		os.Remove (compressedfilename);
+		return "", 0, err;
+	}
+	
+	return compressedfilename, info.Size (), nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// openStoredStream
+// opens a stored file for sequential reading and decompresses it while it is read
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func openStoredStream (storagename string, compression string) (io.ReadCloser, error) {
+
+	file, err := StorageBackend.Open (storagename);
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	if (compression == BLOBCOMPRESSION_NONE) {
+		return file, nil;
+	}
+	
+	decompressor, err := createDecompressor (compression, file);
+	if (err != nil) {
+		file.Close ();
+		return nil, err;
+	}
+	
+	return &NetStorageDecompressedStream {decompressor, file}, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// openStoredFile
+// opens a stored file for random access and reverses its compression. Compressed files are 
+// decompressed into a temporary file first, use openStoredStream where seeking is not needed.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func openStoredFile (storagename string, compression string) (io.ReadSeekCloser, error) {
+
+	file, err := StorageBackend.Open (storagename);
+	if ((err != nil) || (compression == BLOBCOMPRESSION_NONE)) {
+		return file, err;
+	}
+	defer file.Close ();
+	
+	decompressor, err := createDecompressor (compression, file);
+	if (err != nil) {
+		return nil, err;
+	}
+	defer decompressor.Close ();
+	
+	tempfile, err := os.Create (getUploadStorageName (createUUID ()));
+	if (err != nil) {
+		return nil, err;
+	}
+	content := &NetStorageTemporaryFile {tempfile};
+	
+	_, err = io.Copy (content, decompressor);
+	if (err == nil) {
+		_, err = content.Seek (0, io.SeekStart);
+	}
+	
+	if (err != nil) {
+		content.Close ();
+		return nil, err;
+	}
+	
+	return content, nil;
+}
@@ -51,6 +51,15 @@ const CONFIG_VERSION = "v1.0.0";
 const CONFIG_DBNAME = "./netfabbapplicationserver.db";
 
 const CONFIG_DEFAULTDATADIRECTORY = "./data/";
+const CONFIG_DEFAULTTRASHRETENTIONDAYS = 30;
+const CONFIG_DEFAULTGCINTERVALMINUTES = 60;
+const CONFIG_DEFAULTGCGRACEPERIODHOURS = 24;
+const CONFIG_DEFAULTSCRUBINTERVALMINUTES = 1440;
+const CONFIG_DEFAULTTHUMBNAILSIZE = 256;
+const CONFIG_DEFAULTCONVERSIONINTERVALSECONDS = 5;
+const CONFIG_DEFAULTANALYSISINTERVALSECONDS = 5;
+const CONFIG_DEFAULTCHANGESRETENTIONDAYS = 90;
+const CONFIG_DEFAULTCHANGESPRUNEINTERVALMINUTES = 60;
 
 const CONFIG_WORKERNAME = "ApplicationServer";
 const CONFIG_RUNPANSERVICE = false;
@@ -74,9 +83,22 @@ type ConfigDefinitionDatabase struct {
 }
 
 
+type ConfigDefinitionS3 struct {
+	XMLName xml.Name `xml:"s3"`
+	Endpoint string `xml:"endpoint,attr"`
+	Region string `xml:"region,attr"`
+	Bucket string `xml:"bucket,attr"`
+	Prefix string `xml:"prefix,attr"`
+	AccessKey string `xml:"accesskey,attr"`
+	SecretKey string `xml:"secretkey,attr"`
+}
+
 type ConfigDefinitionData struct {
 	XMLName xml.Name `xml:"data"`
 	Directory string `xml:"directory,attr"`
+	Backend string `xml:"backend,attr"`
+	Compression string `xml:"compression,attr"`
+	S3 ConfigDefinitionS3 `xml:"s3"`
 }
 
 type ConfigDefinitionHTTPS struct {
@@ -86,6 +108,53 @@ type ConfigDefinitionHTTPS struct {
 	PrivateKey string `xml:"privatekey,attr"`
 }
 
+type ConfigDefinitionTrash struct {
+	XMLName xml.Name `xml:"trash"`
+	RetentionDays int `xml:"retentiondays,attr"`
+}
+
+type ConfigDefinitionChanges struct {
+	XMLName xml.Name `xml:"changes"`
+	RetentionDays int `xml:"retentiondays,attr"`
+	IntervalMinutes int `xml:"pruneinterval,attr"`
+}
+
+type ConfigDefinitionGarbageCollection struct {
+	XMLName xml.Name `xml:"garbagecollection"`
+	IntervalMinutes int `xml:"interval,attr"`
+	GracePeriodHours int `xml:"graceperiod,attr"`
+	DryRun bool `xml:"dryrun,attr"`
+}
+
+type ConfigDefinitionScrub struct {
+	XMLName xml.Name `xml:"scrub"`
+	IntervalMinutes int `xml:"interval,attr"`
+}
+
+type ConfigDefinitionThumbnails struct {
+	XMLName xml.Name `xml:"thumbnails"`
+	Render bool `xml:"render,attr"`
+	Size int `xml:"size,attr"`
+}
+
+type ConfigDefinitionConversion struct {
+	XMLName xml.Name `xml:"conversion"`
+	IntervalSeconds int `xml:"interval,attr"`
+}
+
+type ConfigDefinitionAnalysis struct {
+	XMLName xml.Name `xml:"analysis"`
+	IntervalSeconds int `xml:"interval,attr"`
+}
+
+type ConfigDefinitionQuotas struct {
+	XMLName xml.Name `xml:"quotas"`
+	HubBytes int64 `xml:"hubbytes,attr"`
+	HubEntities int64 `xml:"hubentities,attr"`
+	ProjectBytes int64 `xml:"projectbytes,attr"`
+	ProjectEntities int64 `xml:"projectentities,attr"`
+}
+
 
 type ConfigDefinitionAuthenticationNamedUser struct {
 	XMLName xml.Name `xml:"nameduser"`
@@ -100,6 +169,11 @@ type ConfigDefinitionAuthenticationGlobal struct {
 	Salt string `xml:"salt,attr"`
 }
 
+type ConfigDefinitionAuthenticationAdministrator struct {
+	XMLName xml.Name `xml:"administrator"`
+	UserID string `xml:"id,attr"`
+}
+
 
 type ConfigDefinitionAuthentication struct {
 	XMLName xml.Name `xml:"authentication"`
@@ -108,6 +182,7 @@ type ConfigDefinitionAuthentication struct {
 
 	NamedUsers []ConfigDefinitionAuthenticationNamedUser `xml:"nameduser"`
 	Global ConfigDefinitionAuthenticationGlobal `xml:"global"`	
+	Administrators []ConfigDefinitionAuthenticationAdministrator `xml:"administrator"`
 }
 
 
@@ -119,6 +194,14 @@ type ConfigDefinition struct {
 	Database ConfigDefinitionDatabase `xml:"database"`
 	Data ConfigDefinitionData `xml:"data"`
 	HTTPS ConfigDefinitionHTTPS `xml:"https"`
+	Trash ConfigDefinitionTrash `xml:"trash"`
+	Changes ConfigDefinitionChanges `xml:"changes"`
+	Quotas ConfigDefinitionQuotas `xml:"quotas"`
+	GarbageCollection ConfigDefinitionGarbageCollection `xml:"garbagecollection"`
+	Scrub ConfigDefinitionScrub `xml:"scrub"`
+	Thumbnails ConfigDefinitionThumbnails `xml:"thumbnails"`
+	Conversion ConfigDefinitionConversion `xml:"conversion"`
+	Analysis ConfigDefinitionAnalysis `xml:"analysis"`
 	Authentication ConfigDefinitionAuthentication `xml:"authentication"`
 	
 }
@@ -131,6 +214,18 @@ func LoadConfig (FileName string) (ConfigDefinition, error) {
 	config.Server.Host = CONFIG_DEFAULTHOST;
 	config.Server.Port = CONFIG_DEFAULTPORT;
 	config.Log.Prefix = CONFIG_SESSIONDBPREFIX;
+	config.Data.Directory = CONFIG_DEFAULTDATADIRECTORY;
+	config.Data.Backend = "local";
+	config.Trash.RetentionDays = CONFIG_DEFAULTTRASHRETENTIONDAYS;
+	config.Changes.RetentionDays = CONFIG_DEFAULTCHANGESRETENTIONDAYS;
+	config.Changes.IntervalMinutes = CONFIG_DEFAULTCHANGESPRUNEINTERVALMINUTES;
+	config.GarbageCollection.IntervalMinutes = CONFIG_DEFAULTGCINTERVALMINUTES;
+	config.GarbageCollection.GracePeriodHours = CONFIG_DEFAULTGCGRACEPERIODHOURS;
+	config.Scrub.IntervalMinutes = CONFIG_DEFAULTSCRUBINTERVALMINUTES;
+	config.Thumbnails.Render = true;
+	config.Thumbnails.Size = CONFIG_DEFAULTTHUMBNAILSIZE;
+	config.Conversion.IntervalSeconds = CONFIG_DEFAULTCONVERSIONINTERVALSECONDS;
+	config.Analysis.IntervalSeconds = CONFIG_DEFAULTANALYSISINTERVALSECONDS;
 	
 	file, err := os.Open(FileName);
 	if (err != nil) {
@@ -0,0 +1,480 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_convert.go
+// Built-in mesh conversion tasks. A "meshconvert" task converts an STL, OBJ or 3MF entity into ASCII 
+// STL, binary STL, OBJ or 3MF and stores the result as new entity of the same or another item. The 
+// tasks are queued with the task protocol and are run by the application server itself.
+//
+// Task parameters:
+//   entityuuid  the entity to convert
+//   format      stlascii, stlbinary (or stl), obj or 3mf
+//   itemuuid    the item of the new entity, defaults to the item of the source entity
+//   unit        the model unit of 3MF results, defaults to millimeter
+//   comment     the comment of the new entity
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+
+const TASKNAME_MESHCONVERSION = "meshconvert";
+const MESHCONVERSION_WORKER = "NetfabbApplicationServer";
+
+const MESHFORMAT_STLASCII = "stlascii";
+const MESHFORMAT_STLBINARY = "stlbinary";
+const MESHFORMAT_OBJ = "obj";
+const MESHFORMAT_3MF = "3mf";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// getMeshFormatDataType
+// returns the entity datatype of a mesh conversion format. Plain "stl" is written as binary STL.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func getMeshFormatDataType (format string) (string, string, error) {
+
+	switch (strings.ToLower (format)) {
+		case MESHFORMAT_STLASCII:
+			return MESHFORMAT_STLASCII, "stl", nil;
+		case MESHFORMAT_STLBINARY, "stl":
+			return MESHFORMAT_STLBINARY, "stl", nil;
+		case MESHFORMAT_OBJ:
+			return MESHFORMAT_OBJ, "obj", nil;
+		case MESHFORMAT_3MF:
+			return MESHFORMAT_3MF, "3mf", nil;
+		default:
+			return "", "", errors.New ("invalid mesh format: " + format);
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createMeshSource
+// creates a triangle source that reads a stored STL, OBJ or 3MF file from its start on every call
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createMeshSource (file io.ReadSeeker, filesize int64, contenttype string) (NetStorageTriangleSource, error) {
+
+	if ((contenttype != "model/stl") && (contenttype != "model/obj") && (contenttype != "model/3mf")) {
+		return nil, errors.New ("not a mesh content type: " + contenttype);
+	}
+
+	source := func (callback func (triangle NetStorageSTLTriangle)) error {
+		_, err := file.Seek (0, io.SeekStart);
+		if (err != nil) {
+			return err;
+		}
+		
+		switch (contenttype) {
+			case "model/3mf":
+				return read3MFTriangles (file, filesize, callback);
+			case "model/obj":
+				return readOBJTriangles (file, callback);
+		}
+		
+		_, err = readSTLTriangles (file, filesize, callback);
+		return err;
+	};
+	
+	return source, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// retrieveMeshConversion
+// retrieves the source entity and target item of a mesh conversion and checks that the user may 
+// add an entity to the target item. Returns the normalized format.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func retrieveMeshConversion (db *sql.DB, parameters map[string]string, userid string) (NetStorageEntity, NetStorageItem, string, error) {
+	var entity NetStorageEntity;
+	var item NetStorageItem;
+
+	format, _, err := getMeshFormatDataType (parameters["format"]);
+	if (err != nil) {
+		return entity, item, "", err;
+	}
+	
+	entity, err = RetrieveEntityByUUID (db, parameters["entityuuid"], true);
+	if (err != nil) {
+		return entity, item, "", err;
+	}
+	
+	contenttype := getDataTypeContentType (entity.DataType);
+	if ((contenttype != "model/stl") && (contenttype != "model/obj") && (contenttype != "model/3mf")) {
+		return entity, item, "", errors.New ("cannot convert entities of datatype " + entity.DataType);
+	}
+	
+	itemuuid := parameters["itemuuid"];
+	if (itemuuid == "") {
+		itemuuid = entity.ItemUUID;
+	}
+	
+	item, err = RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return entity, item, "", err;
+	}
+	
+	err = checkItemLock (item, userid);
+	if (err != nil) {
+		return entity, item, "", err;
+	}
+	
+	if ((format == MESHFORMAT_3MF) && (!is3MFUnit (parameters["unit"]))) {
+		return entity, item, "", errors.New ("invalid 3MF unit: " + parameters["unit"]);
+	}
+	
+	return entity, item, format, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// checkMeshConversionParameters
+// checks the parameters of a new mesh conversion task and fills in their defaults. The task is run 
+// on behalf of the user that queued it.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func checkMeshConversionParameters (db *sql.DB, session * NetStorageSession, parameters map[string]string) (map[string]string, error) {
+
+	checked := make (map[string]string);
+	for key, value := range parameters {
+		checked[key] = value;
+	}
+	
+	if (checked["unit"] == "") {
+		checked["unit"] = PACKAGE3MF_DEFAULTUNIT;
+	}
+	
+	entity, item, format, err := retrieveMeshConversion (db, checked, session.UserID);
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	checked["entityuuid"] = entity.UUID;
+	checked["itemuuid"] = item.UUID;
+	checked["format"] = format;
+	checked["userid"] = session.UserID;
+	
+	return checked, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// writeMeshConversion
+// converts the content of an entity into a temporary file and returns the number of triangles
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func writeMeshConversion (db *sql.DB, entity NetStorageEntity, tempfilename string, format string, name string, unit string) (int64, error) {
+
+	file, filesize, err := openEntityContent (db, entity);
+	if (err != nil) {
+		return 0, err;
+	}
+	defer file.Close ();
+	
+	source, err := createMeshSource (file, filesize, getDataTypeContentType (entity.DataType));
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	tempfile, err := os.Create (tempfilename);
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	var count int64;
+	switch (format) {
+		case MESHFORMAT_STLASCII:
+			count, err = writeASCIISTL (tempfile, name, source);
+		case MESHFORMAT_STLBINARY:
+			count, err = writeBinarySTL (tempfile, source);
+		case MESHFORMAT_OBJ:
+			count, err = writeOBJ (tempfile, source);
+		case MESHFORMAT_3MF:
+			count, err = write3MFPackage (tempfile, name, unit, source);
+		default:
+			err = errors.New ("invalid mesh format: " + format);
+	}
+	
+	closeerr := tempfile.Close ();
+	if (err != nil) {
+		return 0, err;
+	}
+	if (closeerr != nil) {
+		return 0, closeerr;
+	}
+	
+	if (count == 0) {
+		return 0, errors.New ("mesh has no triangles");
+	}
+	
+	return count, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// hashTemporaryFile
+// computes the SHA1 and size of a written temporary file
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func hashTemporaryFile (tempfilename string) (string, int64, error) {
+
+	file, err := os.Open (tempfilename);
+	if (err != nil) {
+		return "", 0, err;
+	}
+	defer file.Close ();
+	
+	hasher := sha1.New ();
+	filesize, err := io.Copy (hasher, file);
+	if (err != nil) {
+		return "", 0, err;
+	}
+	
+	return fmt.Sprintf ("%x", hasher.Sum (nil)), filesize, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// convertMesh
+// runs a mesh conversion task and stores the result as new active entity. Returns the task results.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func convertMesh (db *sql.DB, session * NetStorageSession, taskuuid string, parameters map[string]string) (map[string]string, error) {
+
+	userid := parameters["userid"];
+	
+	// the item may have been locked or the entity deleted since the task was queued
+	entity, item, format, err := retrieveMeshConversion (db, parameters, userid);
+	if (err != nil) {
+		return nil, err;
+	}
+	_, datatype, err := getMeshFormatDataType (format);
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	addLogMessage (session, fmt.Sprintf ("Converting entity %s to %s", entity.UUID, format), LOGTYPE_DATA_CONVERSION, LOGLEVEL_CONSOLE);	
+	
+	tempfilename := getUploadStorageName (createUUID ());
+	count, err := writeMeshConversion (db, entity, tempfilename, format, item.Name, parameters["unit"]);
+	if (err != nil) {
+		os.Remove (tempfilename);
+		return nil, err;
+	}
+	
+	sha1sum, filesize, err := hashTemporaryFile (tempfilename);
+	if (err != nil) {
+		os.Remove (tempfilename);
+		return nil, err;
+	}
+	
+	var conversion NetStorageMeshConversion;
+	conversion.TaskUUID = taskuuid;
+	conversion.SourceEntityUUID = entity.UUID;
+	conversion.SourceDataType = entity.DataType;
+	conversion.Format = format;
+	conversion.TriangleCount = count;
+	if (format == MESHFORMAT_3MF) {
+		conversion.Unit = parameters["unit"];
+	}
+	
+	metadata, err := mergeEntityMetadata ("", ENTITYMETADATA_CONVERSION, conversion);
+	if (err != nil) {
+		os.Remove (tempfilename);
+		return nil, err;
+	}
+	
+	comment := parameters["comment"];
+	if (comment == "") {
+		comment = fmt.Sprintf ("Converted version %d to %s", entity.VersionNumber, format);
+	}
+	
+	entityuuid := createUUID ();
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		os.Remove (tempfilename);
+		return nil, err;
+	}
+	
+	err = checkQuotas (db, item, filesize);
+	if (err == nil) {
+		err = createNewEntity (db, entityuuid, item.UUID, sha1sum, filesize, userid, true);
+	}
+	if (err == nil) {
+		err = updateEntity (db, entityuuid, datatype, metadata, true);
+	}
+	if (err == nil) {
+		err = updateEntityComment (db, entityuuid, comment);
+	}
+	if (err == nil) {
+		err = storeBlob (db, tempfilename, sha1sum, filesize);
+	}
+	if (err == nil) {
+		err = CommittTransaction (db);
+	}
+	if (err != nil) {
+		RollbackTransaction (db);
+		os.Remove (tempfilename);
+		discardOrphanBlob (db, sha1sum);
+		return nil, err;
+	}
+	
+	queueEntityAnalysis (db, session, entityuuid);
+	
+	newentity, err := RetrieveEntityByUUID (db, entityuuid, true);
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	results := make (map[string]string);
+	results["entityuuid"] = entityuuid;
+	results["itemuuid"] = item.UUID;
+	results["versionnumber"] = fmt.Sprintf ("%d", newentity.VersionNumber);
+	results["datatype"] = datatype;
+	results["format"] = format;
+	results["sha1"] = sha1sum;
+	results["filesize"] = fmt.Sprintf ("%d", filesize);
+	results["trianglecount"] = fmt.Sprintf ("%d", count);
+	
+	return results, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// runMeshConversionTask
+// runs a mesh conversion task and returns its status and results. A panic while reading a corrupt 
+// mesh fails the task instead of the server.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func runMeshConversionTask (db *sql.DB, session * NetStorageSession, task NetTaskHandleReply) (status string, results map[string]string) {
+
+	defer func () {
+		recovered := recover ();
+		if (recovered != nil) {
+			addLogMessage (session, fmt.Sprintf ("Mesh conversion %s crashed: %v", task.UUID, recovered), LOGTYPE_DATA_CONVERSION, LOGLEVEL_CONSOLE);	
+			status = "ERROR";
+			results = make (map[string]string);
+			results["error"] = fmt.Sprintf ("internal error: %v", recovered);
+		}
+	} ();
+
+	results, err := convertMesh (db, session, task.UUID, task.Parameters);
+	if (err != nil) {
+		addLogMessage (session, fmt.Sprintf ("Mesh conversion %s failed: %s", task.UUID, err.Error ()), LOGTYPE_DATA_CONVERSION, LOGLEVEL_CONSOLE);	
+		results = make (map[string]string);
+		results["error"] = err.Error ();
+		return "ERROR", results;
+	}
+	
+	return "SUCCESS", results;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// runMeshConversionTasks
+// runs all queued mesh conversion tasks. Failed conversions finish their task with an error result.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func runMeshConversionTasks (db *sql.DB, session * NetStorageSession) (error) {
+
+	for {
+		task, err := handleTask (db, session, TASKNAME_MESHCONVERSION, MESHCONVERSION_WORKER);
+		if (err != nil) {
+			return err;
+		}
+		
+		if (task.UUID == "") {
+			return nil;
+		}
+		
+		status, results := runMeshConversionTask (db, session, task);
+		
+		err = updateTask (db, session, task.UUID, task.WorkerSecret, status, results);
+		if (err != nil) {
+			return err;
+		}
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// runMeshConverter
+// polls the task queue for mesh conversion tasks in the configured interval
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func runMeshConverter (config ConfigDefinitionConversion) {
+
+	startsession := createEmptySession ();
+	
+	db, err := OpenDB (GlobalConfig.Database.Type, GlobalConfig.Database.FileName);
+	if (err == nil) {
+		var count int;
+		count, err = requeueInterruptedTasks (db, TASKNAME_MESHCONVERSION, MESHCONVERSION_WORKER);
+		db.Close ();
+		
+		if ((err == nil) && (count > 0)) {
+			addLogMessage (&startsession, fmt.Sprintf ("Requeued %d interrupted mesh conversions", count), LOGTYPE_DATA_CONVERSION, LOGLEVEL_CONSOLE);	
+		}
+	}
+	if (err != nil) {
+		addLogMessage (&startsession, "Could not requeue interrupted mesh conversions: " + err.Error (), LOGTYPE_DATA_CONVERSION, LOGLEVEL_CONSOLE);	
+	}
+
+	for {
+		time.Sleep (time.Duration (config.IntervalSeconds) * time.Second);
+		
+		session := createEmptySession ();
+		
+		db, err := OpenDB (GlobalConfig.Database.Type, GlobalConfig.Database.FileName);
+		if (err != nil) {
+			addLogMessage (&session, "Mesh conversion failed: " + err.Error (), LOGTYPE_DATA_CONVERSION, LOGLEVEL_CONSOLE);	
+			continue;
+		}
+		
+		err = runMeshConversionTasks (db, &session);
+		db.Close ();
+		
+		if (err != nil) {
+			addLogMessage (&session, "Mesh conversion failed: " + err.Error (), LOGTYPE_DATA_CONVERSION, LOGLEVEL_CONSOLE);	
+		}
+	}
+	
+}
@@ -0,0 +1,191 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_convert_test.go
+// Tests of the mesh sources that mesh conversions and thumbnails read from.
+// Run with
+//   go test netfabbstorage_convert_test.go netfabbapplicationserver.go netfabbstorage_config.go <common_source>
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createTestCube3MF
+// writes a unit cube with 8 vertices and 12 triangles as 3MF package
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createTestCube3MF (t *testing.T) []byte {
+
+	corner := func (index int) [3]float32 {
+		return [3]float32 { float32 (index & 1), float32 ((index >> 1) & 1), float32 ((index >> 2) & 1) };
+	};
+	faces := [][3]int { 
+		{ 0, 2, 1 }, { 1, 2, 3 }, { 4, 5, 6 }, { 5, 7, 6 }, { 0, 1, 4 }, { 1, 5, 4 },
+		{ 2, 6, 3 }, { 3, 6, 7 }, { 0, 4, 2 }, { 2, 4, 6 }, { 1, 3, 5 }, { 3, 7, 5 },
+	};
+	
+	source := func (callback func (triangle NetStorageSTLTriangle)) error {
+		for _, face := range faces {
+			callback (NetStorageSTLTriangle { corner (face[0]), corner (face[1]), corner (face[2]) });
+		}
+		return nil;
+	};
+	
+	var buffer bytes.Buffer;
+	count, err := write3MFPackage (&buffer, "cube", "millimeter", source);
+	if (err != nil) {
+		t.Fatal (err);
+	}
+	if (count != 12) {
+		t.Fatalf ("write3MFPackage wrote %d triangles, expected 12", count);
+	}
+	
+	return buffer.Bytes ();
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// readTest3MFMeshes
+// reads the meshes of the model part of a 3MF package with the given limits
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func readTest3MFMeshes (t *testing.T, data []byte, limits NetStorage3MFMeshLimits) (map[string]*NetStorage3MFMesh, error) {
+
+	archive, err := zip.NewReader (bytes.NewReader (data), int64 (len (data)));
+	if (err != nil) {
+		t.Fatal (err);
+	}
+	
+	modelfile := find3MFPart (archive, PACKAGE3MF_MODELPART);
+	if (modelfile == nil) {
+		t.Fatal ("3MF package has no model part");
+	}
+	
+	reader, err := modelfile.Open ();
+	if (err != nil) {
+		t.Fatal (err);
+	}
+	defer reader.Close ();
+	
+	meshes, _, err := read3MFMeshes (reader, limits);
+	return meshes, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// TestRead3MFMeshLimits
+// the model part reader stops as soon as a limit is passed, before the element is added
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func TestRead3MFMeshLimits (t *testing.T) {
+
+	data := createTestCube3MF (t);
+	
+	tests := []struct {
+		limits NetStorage3MFMeshLimits
+		message string
+	} {
+		{ NetStorage3MFMeshLimits { 8, 12 }, "" },
+		{ NetStorage3MFMeshLimits { 7, 12 }, "more than 7 vertices" },
+		{ NetStorage3MFMeshLimits { 8, 11 }, "more than 11 triangles" },
+	};
+	
+	for _, test := range tests {
+		meshes, err := readTest3MFMeshes (t, data, test.limits);
+		
+		vertexcount := 0;
+		trianglecount := 0;
+		for _, mesh := range meshes {
+			vertexcount = vertexcount + len (mesh.Vertices);
+			trianglecount = trianglecount + len (mesh.Triangles);
+		}
+		if ((vertexcount > test.limits.MaxVertices) || (trianglecount > test.limits.MaxTriangles)) {
+			t.Errorf ("limits %v: read %d vertices and %d triangles", test.limits, vertexcount, trianglecount);
+		}
+		
+		if (test.message == "") {
+			if (err != nil) {
+				t.Errorf ("limits %v: %v", test.limits, err);
+			}
+		} else {
+			if ((err == nil) || !strings.Contains (err.Error (), test.message)) {
+				t.Errorf ("limits %v: error %v, expected %q", test.limits, err, test.message);
+			}
+		}
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// TestMeshSource3MFLimits
+// a mesh conversion of a 3MF package above the limits fails without emitting a triangle
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func TestMeshSource3MFLimits (t *testing.T) {
+
+	data := createTestCube3MF (t);
+	
+	defaultlimits := Package3MFMeshLimits;
+	defer func () {
+		Package3MFMeshLimits = defaultlimits;
+	} ();
+	
+	for _, limits := range []NetStorage3MFMeshLimits { { 8, 12 }, { 7, 12 }, { 8, 11 } } {
+		Package3MFMeshLimits = limits;
+		
+		source, err := createMeshSource (bytes.NewReader (data), int64 (len (data)), "model/3mf");
+		if (err != nil) {
+			t.Fatal (err);
+		}
+		
+		count := 0;
+		err = source (func (triangle NetStorageSTLTriangle) {
+			count++;
+		});
+		
+		if ((limits.MaxVertices >= 8) && (limits.MaxTriangles >= 12)) {
+			if ((err != nil) || (count != 12)) {
+				t.Errorf ("limits %v: %d triangles, error %v", limits, count, err);
+			}
+		} else {
+			if ((err == nil) || (count != 0)) {
+				t.Errorf ("limits %v: %d triangles emitted, error %v", limits, count, err);
+			}
+		}
+	}
+}
@@ -37,8 +37,11 @@ import (
 	"net/http"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
+	"time"
+	"strconv"
+	"strings"
+	"errors"
 	"database/sql"	
 	"crypto/sha1"
 )
@@ -54,11 +57,17 @@ import (
 func StorageHubsHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
 	addLogMessage (session, "Retrieving Hubs", LOGTYPE_DATA_HUBS, LOGLEVEL_CONSOLE);
 
-	hubs, err := RetrieveHubs (db);
+	options, err := parseListOptions (r);
+	if (err != nil) {
+		return err;
+	}
+
+	hubs, page, err := RetrieveHubs (db, options);
 	if (err == nil) {
 		var reply NetStorageHubReply;
 		reply.Protocol = PROTOCOL_HUBS;
 		reply.Version = PROTOCOL_VERSION;
+		reply.NetStorageListPage = page;
 		reply.Hubs = hubs;			
 		return sendJSON (w, &reply);			
 	}			
@@ -75,11 +84,17 @@ func StorageHubsHandler (db *sql.DB, session * NetStorageSession, w http.Respons
 func StorageProjectsHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, hubuuid string) error {
 	addLogMessage (session, "Retrieving Projects for Hub: " + hubuuid, LOGTYPE_DATA_PROJECTS, LOGLEVEL_CONSOLE);	
 
-	projects, err := RetrieveProjects (db, hubuuid);
+	options, err := parseListOptions (r);
+	if (err != nil) {
+		return err;
+	}
+
+	projects, page, err := RetrieveProjects (db, hubuuid, options);
 	if (err == nil) {	
 		var reply NetStorageProjectsReply;
 		reply.Protocol = PROTOCOL_PROJECTS;
 		reply.Version = PROTOCOL_VERSION;
+		reply.NetStorageListPage = page;
 		reply.HubUUID = hubuuid;
 		reply.Projects = projects;	
 		return sendJSON (w, &reply);			
@@ -100,11 +115,17 @@ func StorageProjectsHandler (db *sql.DB, session * NetStorageSession, w http.Res
 func StorageRootFoldersHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, projectuuid string) error {
 	addLogMessage (session, "Retrieving Folders for Project: " + projectuuid, LOGTYPE_DATA_ROOTFOLDERS, LOGLEVEL_CONSOLE);
 
-	folders, err := RetrieveRootFoldersOfProject (db, projectuuid);
+	options, err := parseListOptions (r);
+	if (err != nil) {
+		return err;
+	}
+
+	folders, page, err := RetrieveRootFoldersOfProject (db, projectuuid, options);
 	if (err == nil) {	
 		var reply NetStorageFoldersReply;
 		reply.Protocol = PROTOCOL_ROOTFOLDERS;
 		reply.Version = PROTOCOL_VERSION;
+		reply.NetStorageListPage = page;
 		reply.Folders = folders;	
 		return sendJSON (w, &reply);	
 	}			
@@ -121,11 +142,17 @@ func StorageRootFoldersHandler (db *sql.DB, session * NetStorageSession, w http.
 func StorageSubFoldersHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, folderuuid string) error {
 	addLogMessage (session, "Retrieving Folders for Folder: " + folderuuid, LOGTYPE_DATA_SUBFOLDERS, LOGLEVEL_CONSOLE);
 
-	folders, err := RetrieveSubFoldersOfFolder (db, folderuuid);
+	options, err := parseListOptions (r);
+	if (err != nil) {
+		return err;
+	}
+
+	folders, page, err := RetrieveSubFoldersOfFolder (db, folderuuid, options);
 	if (err == nil) {	
 		var reply NetStorageFoldersReply;
 		reply.Protocol = PROTOCOL_SUBFOLDERS;
 		reply.Version = PROTOCOL_VERSION;
+		reply.NetStorageListPage = page;
 		reply.Folders = folders;	
 		return sendJSON (w, &reply);			
 	}			
@@ -142,11 +169,17 @@ func StorageSubFoldersHandler (db *sql.DB, session * NetStorageSession, w http.R
 func StorageItemsHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, folderuuid string) error {
 	addLogMessage (session, "Retrieving Items for Folder: " + folderuuid, LOGTYPE_DATA_ITEMS, LOGLEVEL_CONSOLE);	
 
-	items, err := RetrieveItemsOfFolder (db, folderuuid);
+	options, err := parseListOptions (r);
+	if (err != nil) {
+		return err;
+	}
+
+	items, page, err := RetrieveItemsOfFolder (db, folderuuid, options);
 	if (err == nil) {	
 		var reply NetStorageItemsReply;
 		reply.Protocol = PROTOCOL_ITEMS;
 		reply.Version = PROTOCOL_VERSION;
+		reply.NetStorageListPage = page;
 		reply.Items = items;		
 		return sendJSON (w, &reply);			
 	}			
@@ -155,6 +188,65 @@ func StorageItemsHandler (db *sql.DB, session * NetStorageSession, w http.Respon
 }
 
 
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageFolderTreeHandler
+// handles a /projects/<uuid>/tree or /folders/<uuid>/tree GET request and retrieves all subfolders 
+// and items at once. The optional query parameters are depth=<n> to limit the number of subfolder 
+// levels and entities=current to include the current entity of every item.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageFolderTreeHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, projectuuid string, folderuuid string) error {
+	addLogMessage (session, "Retrieving folder tree: " + projectuuid + folderuuid, LOGTYPE_DATA_FOLDERTREE, LOGLEVEL_CONSOLE);	
+
+	maxdepth := -1;
+	depthparameter := r.URL.Query().Get ("depth");
+	if (depthparameter != "") {
+		depth, err := strconv.Atoi (depthparameter);
+		if ((err != nil) || (depth < 0)) {
+			return errors.New ("Invalid tree depth: " + depthparameter);
+		}
+		maxdepth = depth;
+	}
+	
+	withentities := false;
+	entitiesparameter := r.URL.Query().Get ("entities");
+	switch (entitiesparameter) {
+		case "":
+		case "current", "latest":
+			withentities = true;
+		default:
+			return errors.New ("Invalid entities parameter: " + entitiesparameter);
+	}
+
+	if (folderuuid != "") {
+		_, err := RetrieveFolderByUUID (db, folderuuid);
+		if (err != nil) {
+			return err;
+		}
+	} else {
+		_, err := RetrieveProjectByUUID (db, projectuuid);
+		if (err != nil) {
+			return err;
+		}
+	}
+
+	folders, err := RetrieveFolderTree (db, projectuuid, folderuuid, maxdepth, withentities);
+	if (err == nil) {	
+		var reply NetStorageFolderTreeReply;
+		reply.Protocol = PROTOCOL_FOLDERTREE;
+		reply.Version = PROTOCOL_VERSION;
+		reply.MaxDepth = maxdepth;
+		reply.Folders = folders;
+		if (reply.Folders == nil) {
+			reply.Folders = []*NetStorageTreeFolder {};
+		}
+		return sendJSON (w, &reply);			
+	}			
+	
+	return err;
+}
+
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 // StorageEntitiesHandler
 // handles a /items/<uuid>/entities GET request and retrieves a list of all items of a folder
@@ -163,11 +255,17 @@ func StorageItemsHandler (db *sql.DB, session * NetStorageSession, w http.Respon
 func StorageEntitiesHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
 	addLogMessage (session, "Retrieving Entities for Items: " + itemuuid, LOGTYPE_DATA_ENTITIES, LOGLEVEL_CONSOLE);	
  
-	entities, err := RetrieveEntitiesOfItem (db, itemuuid);
+	options, err := parseListOptions (r);
+	if (err != nil) {
+		return err;
+	}
+
+	entities, page, err := RetrieveEntitiesOfItem (db, itemuuid, options);
 	if (err == nil) {	
 		var reply NetStorageEntitiesReply;
 		reply.Protocol = PROTOCOL_ENTITIES;
 		reply.Version = PROTOCOL_VERSION;
+		reply.NetStorageListPage = page;
 		reply.Entities = entities;		
 		return sendJSON (w, &reply);			
 	}			
@@ -356,36 +454,68 @@ func StorageBinaryUploadHandler (db *sql.DB, session * NetStorageSession, w http
 		return err;
 	}
 	
+	err = checkItemLock (item, session.UserID);
+	if (err != nil) {
+		return err;
+	}
+	
+	// reject uploads that are known to exceed the quotas before receiving them
+	if (r.ContentLength > 0) {
+		err = checkQuotas (db, item, r.ContentLength);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
 	entityuuid := createUUID ();	
 	
-	// calculate sha1 sum
-	bytes, err := ioutil.ReadAll (r.Body);
+	// create temporary file on disk
+	tempfilename := getUploadStorageName (entityuuid);
+	file, err := os.Create(tempfilename);
 	if (err != nil) {
 		return err;
-	}			
-	sha1sum := fmt.Sprintf("%x", sha1.Sum(bytes));		
+	}	
 	
-	filesize := int64 (len(bytes));
-		
-	// create new Entity
-	err = createNewEntity (db, entityuuid, item.UUID, sha1sum, filesize, false);
+	// stream data to disk and calculate sha1 sum
+	hasher := sha1.New ();
+	filesize, err := io.Copy (io.MultiWriter (file, hasher), r.Body);
+	file.Close();
 	if (err != nil) {
+		os.Remove (tempfilename);
 		return err;
-	}	
+	}			
+	sha1sum := fmt.Sprintf("%x", hasher.Sum (nil));		
+
+	err = BeginTransaction (db);
+	if (err != nil) {
+		os.Remove (tempfilename);
+		return err;
+	}
 	
-	// create file on disk
-	file, err := os.Create(getUUIDStorageName (entityuuid));
+	// the quotas are checked within the transaction that creates the entity
+	err = checkQuotas (db, item, filesize);
+	if (err == nil) {
+		// move file into blob store
+		err = storeBlob (db, tempfilename, sha1sum, filesize);
+	}
 	if (err != nil) {
+		RollbackTransaction (db);
+		os.Remove (tempfilename);
+		discardOrphanBlob (db, sha1sum);
 		return err;
 	}	
-	defer file.Close();
 		
-	// copy data
-	_, err = file.Write(bytes);
-	if err != nil {
-		return err;
+	// create new Entity
+	err = createNewEntity (db, entityuuid, item.UUID, sha1sum, filesize, session.UserID, false);
+	if (err == nil) {
+		err = CommittTransaction (db);
 	}
-		
+	if (err != nil) {
+		RollbackTransaction (db);
+		discardOrphanBlob (db, sha1sum);
+		return err;
+	}	
+			
 	// Send reply JSON	
 	var reply NetStorageNewEntityReply;
 	reply.Protocol = PROTOCOL_NEWENTITY;
@@ -425,18 +555,45 @@ func StorageEntityUpdateHandler (db *sql.DB, session * NetStorageSession, w http
 		RollbackTransaction (db);
 		return err;
 	}
+	
+	item, err := RetrieveItemByUUID (db, entity.ItemUUID);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = checkItemLock (item, session.UserID);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
 		
 	err = updateEntity (db, entity.UUID, request.DataType, string (request.MetaData), true);
 	if (err != nil) {
 		RollbackTransaction (db);
 		return err;
 	}
+	
+	if (request.Comment != "") {
+		err = updateEntityComment (db, entity.UUID, request.Comment);
+		if (err != nil) {
+			RollbackTransaction (db);
+			return err;
+		}
+	}
+	
+	entity, err = RetrieveEntityByUUID (db, entity.UUID, true);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
 
 	err = CommittTransaction (db);
 	if (err != nil) {
 		return err;
 	}
 	
+	queueEntityAnalysis (db, session, entity.UUID);
 
 	// Send reply JSON	
 	var reply NetStorageUpdateEntityReply;
@@ -444,113 +601,1032 @@ func StorageEntityUpdateHandler (db *sql.DB, session * NetStorageSession, w http
 	reply.Version = PROTOCOL_VERSION;
 	reply.ItemUUID = entity.ItemUUID;	
 	reply.EntityUUID = entity.UUID;
+	reply.VersionNumber = entity.VersionNumber;
 	return sendJSON (w, &reply);			
 	
 	
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
-// StorageDownloadHandler
-// downloads the content of an entity
+// StorageSetCurrentEntityHandler
+// handles a /entities/<uuid>/setcurrent POST request and pins an entity as current version of its 
+// item. The pin is released as soon as a new version is uploaded.
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-
-func StorageDownloadHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, entityuuid string) error {
-	addLogMessage (session, "Downloading entity: " + entityuuid, LOGTYPE_DATA_DOWNLOADENTITY, LOGLEVEL_CONSOLE);	
-			
-	entity, err := RetrieveEntityByUUID (db, entityuuid, false);
+func StorageSetCurrentEntityHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, entityuuid string) error {
+	addLogMessage (session, "Setting current entity: " + entityuuid, LOGTYPE_DATA_ENTITIES, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageSetCurrentEntityRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_SETCURRENTENTITY);
 	if (err != nil) {
 		return err;
 	}
-		
-	file, err := os.Open(getUUIDStorageName (entity.UUID));
+	
+	entity, err := RetrieveEntityByUUID (db, entityuuid, true);
+	if (err != nil) {
+		return err;
+	}
+	
+	item, err := RetrieveItemByUUID (db, entity.ItemUUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkItemLock (item, session.UserID);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = setCurrentEntity (db, item.UUID, entity.UUID);
 	if (err != nil) {
 		return err;
 	}
 	
-	defer file.Close();
+	entity.Current = true;
+	
+	// Send reply JSON	
+	var reply NetStorageCurrentEntityReply;
+	reply.Protocol = PROTOCOL_SETCURRENTENTITY;
+	reply.Version = PROTOCOL_VERSION;
+	reply.ItemUUID = item.UUID;
+	reply.Entity = entity;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageCurrentEntityHandler
+// handles a /items/<uuid>/current GET request and returns the current entity of an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
 
-	_, err = io.Copy(w, file)
+func StorageCurrentEntityHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Retrieving current entity of item: " + itemuuid, LOGTYPE_DATA_ENTITIES, LOGLEVEL_CONSOLE);	
+	
+	entity, err := RetrieveCurrentEntityOfItem (db, itemuuid);
+	if (err == nil) {	
+		var reply NetStorageCurrentEntityReply;
+		reply.Protocol = PROTOCOL_CURRENTENTITY;
+		reply.Version = PROTOCOL_VERSION;
+		reply.ItemUUID = itemuuid;
+		reply.Entity = entity;
+		return sendJSON (w, &reply);			
+	}			
+	
 	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageDownloadHandler
+// downloads the content of an entity. Supports byte ranges and conditional requests.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+
+func StorageDownloadHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, entityuuid string) error {
+	addLogMessage (session, "Downloading entity: " + entityuuid, LOGTYPE_DATA_DOWNLOADENTITY, LOGLEVEL_CONSOLE);	
+			
+	entity, err := RetrieveEntityByUUID (db, entityuuid, false);
+	if (err != nil) {
+		return err;
+	}
 	
+	return serveEntityContent (db, session, w, r, entity, getDataTypeContentType (entity.DataType));
 }
 
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
-// Data handler
+// serveEntityContent
+// sends the content of an entity. Uncompressed content supports byte ranges and conditional requests. 
+// Compressed blobs are decompressed while they are sent, as seeking in them would mean decompressing 
+// everything in front of the range. They support conditional requests, but always send the complete 
+// content.
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func DataHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) (bool, error) {
+func serveEntityContent (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, entity NetStorageEntity, contenttype string) error {
 
-	url := r.URL.Path;
-	uuid := "";
+	storagename, compression, err := getEntityStorageName (db, entity);
+	if (err != nil) {
+		return err;
+	}
+	
+	// The SHA1 identifies the content, so it serves as strong ETag for conditional requests
+	etag := "";
+	if (entity.SHA1 != "") {
+		etag = "\"" + entity.SHA1 + "\"";
+		w.Header().Set ("ETag", etag);
+	}
+	w.Header().Set ("Content-Type", contenttype);
 
-	if (r.Method == "GET") {		
+	modtime, err := time.Parse (time.RFC3339, entity.TimeStamp);
+	if (err != nil) {
+		modtime = time.Time {};
+	}
 	
-		if urlCheckRootURL (url, "data/hubs", true) {
-			err := StorageHubsHandler (db, session, w, r);
-			return true, err;
+	if (compression == BLOBCOMPRESSION_NONE) {
+		file, err := StorageBackend.Open (storagename);
+		if (err != nil) {
+			return err;
 		}
 		
-		if parseUUIDURL (url, "data/hubs", "", &uuid) {
-			err := StorageProjectsHandler (db, session, w, r, uuid);
-			return true, err;
-		}
+		defer file.Close();
+	
+		// handles Range, If-Range, If-None-Match and HEAD requests and sets the Content-Length
+		http.ServeContent (w, r, "", modtime, file);
+		return nil;
+	}
+	
+	w.Header().Set ("Accept-Ranges", "none");
+	if (!modtime.IsZero ()) {
+		w.Header().Set ("Last-Modified", modtime.UTC().Format (http.TimeFormat));
+	}
+	
+	if (isNotModified (r, etag, modtime)) {
+		w.WriteHeader (http.StatusNotModified);
+		return nil;
+	}
+	
+	stream, err := openStoredStream (storagename, compression);
+	if (err != nil) {
+		return err;
+	}
+	
+	defer stream.Close();
+	
+	w.Header().Set ("Content-Length", entity.FileSize);
+	if (r.Method == "HEAD") {
+		return nil;
+	}
+	
+	// the reply has been started, a failure can only abort the transfer
+	_, err = io.Copy (w, stream);
+	if (err != nil) {
+		addLogMessage (session, "Download of entity " + entity.UUID + " aborted: " + err.Error (), LOGTYPE_DATA_DOWNLOADENTITY, LOGLEVEL_CONSOLE);	
+	}
+	
+	return nil;
+}
 
-		if parseUUIDURL (url, "data/projects", "rootfolders", &uuid) {
-			err := StorageRootFoldersHandler (db, session, w, r, uuid);
-			return true, err;
-		}
-		
-		if parseUUIDURL (url, "data/folders", "subfolders", &uuid) {
-			err := StorageSubFoldersHandler (db, session, w, r, uuid);
-			return true, err;
-		}
-		
 
-		if parseUUIDURL (url, "data/folders", "items", &uuid) {
-			err := StorageItemsHandler (db, session, w, r, uuid);
-			return true, err;
-		}
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// isNotModified
+// evaluates If-None-Match and If-Modified-Since of a GET or HEAD request
+//////////////////////////////////////////////////////////////////////////////////////////////////////
 
-		if parseUUIDURL (url, "data/items", "entities", &uuid) {
-			err := StorageEntitiesHandler (db, session, w, r, uuid);
-			return true, err;
-		}
+func isNotModified (r *http.Request, etag string, modtime time.Time) bool {
 
-		if parseUUIDURL (url, "data/download", "", &uuid) {
-			err := StorageDownloadHandler (db, session, w, r, uuid);
-			return true, err;
+	if ((r.Method != "GET") && (r.Method != "HEAD")) {
+		return false;
+	}
+	
+	nonematch := r.Header.Get ("If-None-Match");
+	if (nonematch != "") {
+		for _, candidate := range strings.Split (nonematch, ",") {
+			candidate = strings.TrimPrefix (strings.TrimSpace (candidate), "W/");
+			if ((candidate == "*") || ((etag != "") && (candidate == etag))) {
+				return true;
+			}
 		}
-		
+		return false;
+	}
+	
+	modifiedsince, err := http.ParseTime (r.Header.Get ("If-Modified-Since"));
+	if ((err != nil) || modtime.IsZero ()) {
+		return false;
 	}
+	
+	return !modtime.Truncate (time.Second).After (modifiedsince);
+}
 
-	if (r.Method == "POST") {		
-		if parseUUIDURL (url, "data/hubs", "", &uuid) {
-			err := StorageProjectNewHandler (db, session, w, r, uuid);
-			return true, err;
-		}
-		
-		if parseUUIDURL (url, "data/folders", "newfolder", &uuid) {
-			err := StorageFolderNewHandler (db, session, w, r, uuid);
-			return true, err;
-		}
 
-		if parseUUIDURL (url, "data/folders", "newitem", &uuid) {
-			err := StorageItemNewHandler (db, session, w, r, uuid);
-			return true, err;
-		}
-		
-		if parseUUIDURL (url, "data/upload", "", &uuid) {
-			err := StorageBinaryUploadHandler (db, session, w, r, uuid);
-			return true, err;
-		}
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageProjectRenameHandler
+// handles a /projects/<uuid>/rename POST request and renames a project
+//////////////////////////////////////////////////////////////////////////////////////////////////////
 
-		if parseUUIDURL (url, "data/entities", "", &uuid) {
-			err := StorageEntityUpdateHandler (db, session, w, r, uuid);
-			return true, err;
-		}
-					
+func StorageProjectRenameHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, projectuuid string) error {
+	addLogMessage (session, "Renaming project: " + projectuuid, LOGTYPE_DATA_RENAME, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageRenameProjectRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_RENAMEPROJECT);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (request.ProjectName == "") {
+		return errors.New ("Invalid project name");
+	}
+	
+	project, err := RetrieveProjectByUUID (db, projectuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = renameProject (db, project.UUID, request.ProjectName);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageRenameReply;
+	reply.Protocol = PROTOCOL_RENAMEPROJECT;
+	reply.Version = PROTOCOL_VERSION;
+	reply.UUID = project.UUID;
+	reply.Name = request.ProjectName;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageFolderRenameHandler
+// handles a /folders/<uuid>/rename POST request and renames a folder
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageFolderRenameHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, folderuuid string) error {
+	addLogMessage (session, "Renaming folder: " + folderuuid, LOGTYPE_DATA_RENAME, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageRenameFolderRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_RENAMEFOLDER);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (request.FolderName == "") {
+		return errors.New ("Invalid folder name");
+	}
+	
+	folder, err := RetrieveFolderByUUID (db, folderuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = renameFolder (db, folder.UUID, request.FolderName);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageRenameReply;
+	reply.Protocol = PROTOCOL_RENAMEFOLDER;
+	reply.Version = PROTOCOL_VERSION;
+	reply.UUID = folder.UUID;
+	reply.Name = request.FolderName;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageItemRenameHandler
+// handles a /items/<uuid>/rename POST request and renames an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageItemRenameHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Renaming item: " + itemuuid, LOGTYPE_DATA_RENAME, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageRenameItemRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_RENAMEITEM);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (request.ItemName == "") {
+		return errors.New ("Invalid item name");
+	}
+	
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = renameItem (db, item.UUID, request.ItemName);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageRenameReply;
+	reply.Protocol = PROTOCOL_RENAMEITEM;
+	reply.Version = PROTOCOL_VERSION;
+	reply.UUID = item.UUID;
+	reply.Name = request.ItemName;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageFolderMoveHandler
+// handles a /folders/<uuid>/move POST request and moves a folder to a new parent folder. A folder 
+// can not be moved into itself or one of its subfolders.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageFolderMoveHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, folderuuid string) error {
+	addLogMessage (session, "Moving folder: " + folderuuid, LOGTYPE_DATA_MOVE, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageMoveFolderRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_MOVEFOLDER);
+	if (err != nil) {
+		return err;
+	}
+	
+	parentuuid, err := checkUUIDFormat (request.ParentUUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	folder, err := RetrieveFolderByUUID (db, folderuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (folder.ParentUUID == "") {
+		return errors.New ("root folders can not be moved: " + folder.UUID);
+	}
+	
+	parent, err := RetrieveFolderByUUID (db, parentuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	iscycle, err := folderIsInSubtree (db, parent.UUID, folder.UUID);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	if (iscycle) {
+		RollbackTransaction (db);
+		return errors.New ("folder can not be moved into its own subtree: " + folder.UUID);
+	}
+	
+	err = moveFolder (db, folder.UUID, parent.UUID, parent.ProjectUUID);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = CommittTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageMoveFolderReply;
+	reply.Protocol = PROTOCOL_MOVEFOLDER;
+	reply.Version = PROTOCOL_VERSION;
+	reply.FolderUUID = folder.UUID;
+	reply.ProjectUUID = parent.ProjectUUID;
+	reply.ParentUUID = parent.UUID;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageItemMoveHandler
+// handles a /items/<uuid>/move POST request and moves an item to another folder
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageItemMoveHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Moving item: " + itemuuid, LOGTYPE_DATA_MOVE, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageMoveItemRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_MOVEITEM);
+	if (err != nil) {
+		return err;
+	}
+	
+	folderuuid, err := checkUUIDFormat (request.FolderUUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	folder, err := RetrieveFolderByUUID (db, folderuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = moveItem (db, item.UUID, folder.UUID, folder.ProjectUUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageMoveItemReply;
+	reply.Protocol = PROTOCOL_MOVEITEM;
+	reply.Version = PROTOCOL_VERSION;
+	reply.ItemUUID = item.UUID;
+	reply.FolderUUID = folder.UUID;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageProjectDeleteHandler
+// handles a /projects/<uuid> DELETE request and deletes a project with all folders and items
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageProjectDeleteHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, projectuuid string) error {
+	addLogMessage (session, "Deleting project: " + projectuuid, LOGTYPE_DATA_DELETE, LOGLEVEL_CONSOLE);	
+	
+	project, err := RetrieveProjectByUUID (db, projectuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	deletionuuid := createUUID ();
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = createDeletion (db, deletionuuid, project.UUID, DELETIONTYPE_PROJECT, project.UUID, project.Name, session.UserID);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	foldercount, itemcount, err := deleteProject (db, project.UUID, deletionuuid);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = recordDeletionChanges (db, deletionuuid, CHANGETYPE_DELETE);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = CommittTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageDeleteReply;
+	reply.Protocol = PROTOCOL_DELETEPROJECT;
+	reply.Version = PROTOCOL_VERSION;
+	reply.UUID = project.UUID;
+	reply.DeletionUUID = deletionuuid;
+	reply.FolderCount = foldercount;
+	reply.ItemCount = itemcount;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageFolderDeleteHandler
+// handles a /folders/<uuid> DELETE request and deletes a folder with all subfolders and items
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageFolderDeleteHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, folderuuid string) error {
+	addLogMessage (session, "Deleting folder: " + folderuuid, LOGTYPE_DATA_DELETE, LOGLEVEL_CONSOLE);	
+	
+	folder, err := RetrieveFolderByUUID (db, folderuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (folder.ParentUUID == "") {
+		return errors.New ("root folders can only be deleted with their project: " + folder.UUID);
+	}
+	
+	deletionuuid := createUUID ();
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = createDeletion (db, deletionuuid, folder.ProjectUUID, DELETIONTYPE_FOLDER, folder.UUID, folder.Name, session.UserID);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	foldercount, itemcount, err := deleteFolder (db, folder.UUID, deletionuuid);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = recordDeletionChanges (db, deletionuuid, CHANGETYPE_DELETE);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = CommittTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageDeleteReply;
+	reply.Protocol = PROTOCOL_DELETEFOLDER;
+	reply.Version = PROTOCOL_VERSION;
+	reply.UUID = folder.UUID;
+	reply.DeletionUUID = deletionuuid;
+	reply.FolderCount = foldercount;
+	reply.ItemCount = itemcount;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageItemDeleteHandler
+// handles a /items/<uuid> DELETE request and deletes an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageItemDeleteHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Deleting item: " + itemuuid, LOGTYPE_DATA_DELETE, LOGLEVEL_CONSOLE);	
+	
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	deletionuuid := createUUID ();
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = createDeletion (db, deletionuuid, item.ProjectUUID, DELETIONTYPE_ITEM, item.UUID, item.Name, session.UserID);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = deleteItem (db, item.UUID, deletionuuid);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = recordDeletionChanges (db, deletionuuid, CHANGETYPE_DELETE);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = CommittTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageDeleteReply;
+	reply.Protocol = PROTOCOL_DELETEITEM;
+	reply.Version = PROTOCOL_VERSION;
+	reply.UUID = item.UUID;
+	reply.DeletionUUID = deletionuuid;
+	reply.FolderCount = 0;
+	reply.ItemCount = 1;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageEntityDeleteHandler
+// handles a /entities/<uuid> DELETE request and deletes a single entity of an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageEntityDeleteHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, entityuuid string) error {
+	addLogMessage (session, "Deleting entity: " + entityuuid, LOGTYPE_DATA_DELETE, LOGLEVEL_CONSOLE);	
+	
+	entity, err := RetrieveEntityByUUID (db, entityuuid, true);
+	if (err != nil) {
+		return err;
+	}
+	
+	item, err := RetrieveItemByUUID (db, entity.ItemUUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	deletionuuid := createUUID ();
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = createDeletion (db, deletionuuid, item.ProjectUUID, DELETIONTYPE_ENTITY, entity.UUID, item.Name, session.UserID);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = deleteEntity (db, entity.UUID, deletionuuid);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = recordDeletionChanges (db, deletionuuid, CHANGETYPE_DELETE);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = CommittTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageDeleteEntityReply;
+	reply.Protocol = PROTOCOL_DELETEENTITY;
+	reply.Version = PROTOCOL_VERSION;
+	reply.EntityUUID = entity.UUID;
+	reply.DeletionUUID = deletionuuid;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// Data handler
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func DataHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) (bool, error) {
+
+	url := r.URL.Path;
+	uuid := "";
+
+	if (r.Method == "GET") {		
+	
+		if urlCheckRootURL (url, "data/hubs", true) {
+			err := StorageHubsHandler (db, session, w, r);
+			return true, err;
+		}
+		
+		if parseUUIDURL (url, "data/hubs", "search", &uuid) {
+			err := StorageSearchHandler (db, session, w, r, "hub", uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/projects", "search", &uuid) {
+			err := StorageSearchHandler (db, session, w, r, "project", uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/folders", "search", &uuid) {
+			err := StorageSearchHandler (db, session, w, r, "folder", uuid);
+			return true, err;
+		}
+		
+		if urlCheckRootURL (url, "data/events", false) {
+			err := StorageEventsHandler (db, session, w, r);
+			return true, err;
+		}
+
+		if urlCheckRootURL (url, "data/changes", false) {
+			err := StorageChangesHandler (db, session, w, r, CHANGESCOPE_ALL, "");
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/hubs", "changes", &uuid) {
+			err := StorageChangesHandler (db, session, w, r, CHANGESCOPE_HUB, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/projects", "changes", &uuid) {
+			err := StorageChangesHandler (db, session, w, r, CHANGESCOPE_PROJECT, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/hubs", "usage", &uuid) {
+			err := StorageUsageHandler (db, session, w, r, QUOTATYPE_HUB, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/projects", "usage", &uuid) {
+			err := StorageUsageHandler (db, session, w, r, QUOTATYPE_PROJECT, uuid);
+			return true, err;
+		}
+		
+		if parseUUIDURL (url, "data/hubs", "", &uuid) {
+			err := StorageProjectsHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if urlCheckRootURL (url, "data/admin/hubs", true) {
+			err := StorageHubStatisticsHandler (db, session, w, r);
+			return true, err;
+		}
+
+		if urlCheckRootURL (url, "data/admin/integrity", false) {
+			err := StorageIntegrityFindingsHandler (db, session, w, r);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/projects", "rootfolders", &uuid) {
+			err := StorageRootFoldersHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+		
+		if parseUUIDURL (url, "data/folders", "subfolders", &uuid) {
+			err := StorageSubFoldersHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+		
+
+		if parseUUIDURL (url, "data/folders", "items", &uuid) {
+			err := StorageItemsHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/projects", "items", &uuid) {
+			err := StorageProjectItemsHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "properties", &uuid) {
+			err := StorageItemPropertiesHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "comments", &uuid) {
+			err := StorageCommentsHandler (db, session, w, r, COMMENTTARGET_ITEM, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/entities", "comments", &uuid) {
+			err := StorageCommentsHandler (db, session, w, r, COMMENTTARGET_ENTITY, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "entities", &uuid) {
+			err := StorageEntitiesHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "current", &uuid) {
+			err := StorageCurrentEntityHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/entities", "thumbnail", &uuid) {
+			err := StorageEntityThumbnailHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/download", "", &uuid) {
+			err := StorageDownloadHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/uploads", "", &uuid) {
+			err := StorageUploadStatusHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/projects", "trash", &uuid) {
+			err := StorageTrashHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/projects", "tree", &uuid) {
+			err := StorageFolderTreeHandler (db, session, w, r, uuid, "");
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/folders", "tree", &uuid) {
+			err := StorageFolderTreeHandler (db, session, w, r, "", uuid);
+			return true, err;
+		}
+		
+	}
+
+	if (r.Method == "HEAD") {		
+		if parseUUIDURL (url, "data/entities", "thumbnail", &uuid) {
+			err := StorageEntityThumbnailHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/download", "", &uuid) {
+			err := StorageDownloadHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+	}
+
+	if (r.Method == "POST") {		
+		if urlCheckRootURL (url, "data/hubs", true) {
+			err := StorageHubNewHandler (db, session, w, r);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/hubs", "rename", &uuid) {
+			err := StorageHubRenameHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/hubs", "quota", &uuid) {
+			err := StorageQuotaSetHandler (db, session, w, r, QUOTATYPE_HUB, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/projects", "quota", &uuid) {
+			err := StorageQuotaSetHandler (db, session, w, r, QUOTATYPE_PROJECT, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/hubs", "", &uuid) {
+			err := StorageProjectNewHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+		
+		if parseUUIDURL (url, "data/folders", "newfolder", &uuid) {
+			err := StorageFolderNewHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/folders", "newitem", &uuid) {
+			err := StorageItemNewHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+		
+		if parseUUIDURL (url, "data/upload", "", &uuid) {
+			err := StorageBinaryUploadHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/entities", "thumbnail", &uuid) {
+			err := StorageEntityThumbnailRenderHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/entities", "comments", &uuid) {
+			err := StorageNewCommentHandler (db, session, w, r, COMMENTTARGET_ENTITY, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/entities", "setcurrent", &uuid) {
+			err := StorageSetCurrentEntityHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/entities", "", &uuid) {
+			err := StorageEntityUpdateHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "newupload", &uuid) {
+			err := StorageUploadNewHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/uploads", "finalize", &uuid) {
+			err := StorageUploadFinalizeHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/projects", "rename", &uuid) {
+			err := StorageProjectRenameHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/folders", "rename", &uuid) {
+			err := StorageFolderRenameHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "rename", &uuid) {
+			err := StorageItemRenameHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/folders", "move", &uuid) {
+			err := StorageFolderMoveHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "move", &uuid) {
+			err := StorageItemMoveHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "checkout", &uuid) {
+			err := StorageItemCheckOutHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "checkin", &uuid) {
+			err := StorageItemCheckInHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "breaklock", &uuid) {
+			err := StorageItemBreakLockHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "tags", &uuid) {
+			err := StorageSetItemTagsHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "properties", &uuid) {
+			err := StorageSetItemPropertiesHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "comments", &uuid) {
+			err := StorageNewCommentHandler (db, session, w, r, COMMENTTARGET_ITEM, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/comments", "", &uuid) {
+			err := StorageEditCommentHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/trash", "restore", &uuid) {
+			err := StorageTrashRestoreHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if urlCheckRootURL (url, "data/batch", false) {
+			err := StorageBatchHandler (db, session, w, r);
+			return true, err;
+		}
+
+		if urlCheckRootURL (url, "data/admin/collectgarbage", false) {
+			err := StorageCollectGarbageHandler (db, session, w, r);
+			return true, err;
+		}
+
+		if urlCheckRootURL (url, "data/admin/purgetrash", false) {
+			err := StorageTrashPurgeHandler (db, session, w, r);
+			return true, err;
+		}
+
+		if urlCheckRootURL (url, "data/admin/scrub", false) {
+			err := StorageScrubHandler (db, session, w, r);
+			return true, err;
+		}
+					
+	}
+
+	if (r.Method == "PUT") {		
+		if parseUUIDURL (url, "data/uploads", "", &uuid) {
+			err := StorageUploadChunkHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+	}
+
+	if (r.Method == "DELETE") {		
+		if parseUUIDURL (url, "data/uploads", "", &uuid) {
+			err := StorageUploadAbortHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/hubs", "", &uuid) {
+			err := StorageHubDeactivateHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/projects", "", &uuid) {
+			err := StorageProjectDeleteHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/folders", "", &uuid) {
+			err := StorageFolderDeleteHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/items", "", &uuid) {
+			err := StorageItemDeleteHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/entities", "", &uuid) {
+			err := StorageEntityDeleteHandler (db, session, w, r, uuid);
+			return true, err;
+		}
+
+		if parseUUIDURL (url, "data/comments", "", &uuid) {
+			err := StorageDeleteCommentHandler (db, session, w, r, uuid);
+			return true, err;
+		}
 	}
 	
 	return false, nil;
@@ -57,7 +57,13 @@ func BeginTransaction (db *sql.DB) (error) {
 
 func CommittTransaction (db *sql.DB) (error) {
 	_, err := db.Exec ("COMMIT");
-	return err;
+	if (err != nil) {
+		return err;
+	}
+	
+	// changes recorded in the transaction are visible now
+	signalChangeListeners ();
+	return nil;
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -74,20 +80,26 @@ func RollbackTransaction (db *sql.DB) (error) {
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 // RetrieveHubs
-// retrieves all hubs for a user
+// retrieves the hubs for a user that match the list options
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func RetrieveHubs (db *sql.DB) ([]NetStorageHub, error) {
+func RetrieveHubs (db *sql.DB, options NetStorageListOptions) ([]NetStorageHub, NetStorageListPage, error) {
 	entries := make([] NetStorageHub, 0);
+	var page NetStorageListPage;
 	
-	statement, err := db.Prepare ("SELECT uuid, hubname, active FROM netstorage_hubs WHERE active=1");
-	if (err != nil) {
-		return entries, err;
-	}
-		
-	rows, err := statement.Query();
+	query := NetStorageListQuery {
+		Fields: "uuid, hubname, active",
+		From: "netstorage_hubs",
+		Condition: "active=1",
+		UUIDField: "uuid",
+		SortFields: map[string]string { LISTSORT_NAME: "hubname" },
+		DefaultSort: LISTSORT_NAME,
+		NameField: "hubname",
+	};
+	
+	rows, err := openList (db, query, options);
 	if (err != nil) {
-		return entries, err;
+		return entries, page, err;
 	}
 
 	for (rows.Next()) {
@@ -96,7 +108,7 @@ func RetrieveHubs (db *sql.DB) ([]NetStorageHub, error) {
 		err = rows.Scan (&entry.UUID, &entry.Name, &entry.Active);
 		if (err != nil) {
 			rows.Close ();		
-			return entries, err;
+			return entries, page, err;
 		}
 								
 		entries = append (entries, entry);
@@ -104,26 +116,34 @@ func RetrieveHubs (db *sql.DB) ([]NetStorageHub, error) {
 	
 	rows.Close ();		
 	
-	return entries, nil;
+	page, err = rows.Page ();
+	return entries, page, err;
 }
 
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 // RetrieveProjects
-// retrieves all projects for a hub
+// retrieves the projects of a hub that match the list options
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func RetrieveProjects (db *sql.DB, hubuuid string) ([]NetStorageProject, error) {
+func RetrieveProjects (db *sql.DB, hubuuid string, options NetStorageListOptions) ([]NetStorageProject, NetStorageListPage, error) {
 	entries := make([] NetStorageProject, 0);
+	var page NetStorageListPage;
 	
-	statement, err := db.Prepare ("SELECT uuid, hubuuid, projectname, active FROM netstorage_projects WHERE hubuuid=? AND active=1");
-	if (err != nil) {
-		return entries, err;
-	}
-		
-	rows, err := statement.Query(hubuuid);
+	query := NetStorageListQuery {
+		Fields: "uuid, hubuuid, projectname, active",
+		From: "netstorage_projects",
+		Condition: "hubuuid=? AND active=1 AND hubuuid IN (SELECT uuid FROM netstorage_hubs WHERE active=1)",
+		Arguments: []interface{} { hubuuid },
+		UUIDField: "uuid",
+		SortFields: map[string]string { LISTSORT_NAME: "projectname" },
+		DefaultSort: LISTSORT_NAME,
+		NameField: "projectname",
+	};
+	
+	rows, err := openList (db, query, options);
 	if (err != nil) {
-		return entries, err;
+		return entries, page, err;
 	}
 
 	for (rows.Next()) {
@@ -131,7 +151,7 @@ func RetrieveProjects (db *sql.DB, hubuuid string) ([]NetStorageProject, error)
 		err = rows.Scan (&entry.UUID, &entry.HubUUID, &entry.Name, &entry.Active);
 		if (err != nil) {
 			rows.Close ();		
-			return entries, err;
+			return entries, page, err;
 		}
 				
 		entries = append (entries, entry);
@@ -139,33 +159,42 @@ func RetrieveProjects (db *sql.DB, hubuuid string) ([]NetStorageProject, error)
 	
 	rows.Close ();		
 	
-	return entries, nil;
+	page, err = rows.Page ();
+	return entries, page, err;
 }
 
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 // RetrieveRootFoldersOfProject
-// retrieves all root folders of a project
+// retrieves the root folders of a project that match the list options
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func RetrieveRootFoldersOfProject (db *sql.DB, projectuuid string) ([]NetStorageFolder, error) {
+func RetrieveRootFoldersOfProject (db *sql.DB, projectuuid string, options NetStorageListOptions) ([]NetStorageFolder, NetStorageListPage, error) {
 	entries := make([] NetStorageFolder, 0);
+	var page NetStorageListPage;
 	
-	statement, err := db.Prepare ("SELECT uuid, projectuuid, parentuuid, foldername, active FROM netstorage_folders WHERE projectuuid=? AND parentuuid=\"\" AND active=1");
-	if (err != nil) {
-		return entries, err;
-	}
-		
-	rows, err := statement.Query(projectuuid);
+	query := NetStorageListQuery {
+		Fields: "uuid, projectuuid, parentuuid, foldername, active",
+		From: "netstorage_folders",
+		Condition: "projectuuid=? AND parentuuid=\"\" AND active=1",
+		Arguments: []interface{} { projectuuid },
+		UUIDField: "uuid",
+		SortFields: map[string]string { LISTSORT_NAME: "foldername" },
+		DefaultSort: LISTSORT_NAME,
+		NameField: "foldername",
+	};
+	
+	rows, err := openList (db, query, options);
 	if (err != nil) {
-		return entries, err;
+		return entries, page, err;
 	}
 
 	for (rows.Next()) {
-		var entry NetStorageFolder;		
+		var entry NetStorageFolder;
 		err = rows.Scan (&entry.UUID, &entry.ProjectUUID, &entry.ParentUUID, &entry.Name, &entry.Active);
 		if (err != nil) {
 			rows.Close ();		
-			return entries, err;
+			return entries, page, err;
 		}
 								
 		entries = append (entries, entry);
@@ -173,42 +202,51 @@ func RetrieveRootFoldersOfProject (db *sql.DB, projectuuid string) ([]NetStorage
 	
 	rows.Close ();		
 	
-	return entries, nil;
+	page, err = rows.Page ();
+	return entries, page, err;
 }
 
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 // RetrieveSubFoldersOfFolder
-// retrieves all subfolders of a folder
+// retrieves the subfolders of a folder that match the list options
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func RetrieveSubFoldersOfFolder (db *sql.DB, folderuuid string) ([]NetStorageFolder, error) {
+func RetrieveSubFoldersOfFolder (db *sql.DB, folderuuid string, options NetStorageListOptions) ([]NetStorageFolder, NetStorageListPage, error) {
 	entries := make([] NetStorageFolder, 0);
+	var page NetStorageListPage;
 	
-	statement, err := db.Prepare ("SELECT uuid, projectuuid, parentuuid, foldername, active FROM netstorage_folders WHERE parentuuid=? AND active=1");
-	if (err != nil) {
-		return entries, err;
-	}
-		
-	rows, err := statement.Query(folderuuid);
+	query := NetStorageListQuery {
+		Fields: "uuid, projectuuid, parentuuid, foldername, active",
+		From: "netstorage_folders",
+		Condition: "parentuuid=? AND active=1",
+		Arguments: []interface{} { folderuuid },
+		UUIDField: "uuid",
+		SortFields: map[string]string { LISTSORT_NAME: "foldername" },
+		DefaultSort: LISTSORT_NAME,
+		NameField: "foldername",
+	};
+	
+	rows, err := openList (db, query, options);
 	if (err != nil) {
-		return entries, err;
+		return entries, page, err;
 	}
 
 	for (rows.Next()) {
 		var entry NetStorageFolder;
-	
 		err = rows.Scan (&entry.UUID, &entry.ProjectUUID, &entry.ParentUUID, &entry.Name, &entry.Active);
 		if (err != nil) {
 			rows.Close ();		
-			return entries, err;
+			return entries, page, err;
 		}
-				
+								
 		entries = append (entries, entry);
 	}
 	
 	rows.Close ();		
 	
-	return entries, nil;
+	page, err = rows.Page ();
+	return entries, page, err;
 }
 
 
@@ -244,37 +282,54 @@ func RetrieveFolderByUUID (db *sql.DB, folderuuid string) (NetStorageFolder, err
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 // RetrieveItems
-// retrieves all items of a folder
+// retrieves the items of a folder that match the list options
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func RetrieveItemsOfFolder (db *sql.DB, folderuuid string) ([]NetStorageItem, error) {
+func RetrieveItemsOfFolder (db *sql.DB, folderuuid string, options NetStorageListOptions) ([]NetStorageItem, NetStorageListPage, error) {
 	entries := make([] NetStorageItem, 0);
+	var page NetStorageListPage;
 	
-	statement, err := db.Prepare ("SELECT netstorage_items.uuid, netstorage_items.folderuuid, netstorage_folders.projectuuid, netstorage_items.itemname, netstorage_items.active FROM netstorage_items LEFT JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid WHERE folderuuid=? AND netstorage_items.active=1");
-	if (err != nil) {
-		return entries, err;
-	}
-		
-	rows, err := statement.Query(folderuuid);
+	// items are sorted and filtered by their current entity
+	query := NetStorageListQuery {
+		Fields: "netstorage_items.uuid, netstorage_items.folderuuid, netstorage_folders.projectuuid, netstorage_items.itemname, netstorage_items.active, " + SQL_ITEMLOCKFIELDS,
+		From: "netstorage_items LEFT JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid",
+		Condition: "folderuuid=? AND netstorage_items.active=1",
+		Arguments: []interface{} { folderuuid },
+		UUIDField: "netstorage_items.uuid",
+		SortFields: map[string]string { 
+			LISTSORT_NAME: "netstorage_items.itemname",
+			LISTSORT_TIMESTAMP: "IFNULL((SELECT timestamp FROM netstorage_entities WHERE uuid=" + SQL_CURRENTENTITY + "), '')",
+			LISTSORT_SIZE: "IFNULL((SELECT filesize FROM netstorage_entities WHERE uuid=" + SQL_CURRENTENTITY + "), 0)",
+		},
+		DefaultSort: LISTSORT_NAME,
+		NameField: "netstorage_items.itemname",
+		DataTypeField: "(SELECT datatype FROM netstorage_entities WHERE uuid=" + SQL_CURRENTENTITY + ")",
+	};
+	
+	rows, err := openList (db, query, options);
 	if (err != nil) {
-		return entries, err;
+		return entries, page, err;
 	}
 
 	for (rows.Next()) {
 		var entry NetStorageItem;
+		var lockuserid, locknote, locktimestamp, lockexpiry string;
 	
-		err = rows.Scan (&entry.UUID, &entry.FolderUUID, &entry.ProjectUUID, &entry.Name, &entry.Active);
+		err = rows.Scan (&entry.UUID, &entry.FolderUUID, &entry.ProjectUUID, &entry.Name, &entry.Active, &lockuserid, &locknote, &locktimestamp, &lockexpiry);
 		if (err != nil) {
 			rows.Close ();		
-			return entries, err;
+			return entries, page, err;
 		}
+		
+		entry.Lock = makeItemLock (lockuserid, locknote, locktimestamp, lockexpiry);
 								
 		entries = append (entries, entry);
 	}
 	
 	rows.Close ();		
 	
-	return entries, nil;
+	page, err = rows.Page ();
+	return entries, page, err;
 }
 
 
@@ -286,7 +341,7 @@ func RetrieveItemsOfFolder (db *sql.DB, folderuuid string) ([]NetStorageItem, er
 func RetrieveItemByUUID (db *sql.DB, itemuuid string) (NetStorageItem, error) {
 	var item NetStorageItem;
 	
-	statement, err := db.Prepare ("SELECT netstorage_items.uuid, netstorage_items.folderuuid, netstorage_folders.projectuuid, netstorage_items.itemname, netstorage_items.active FROM netstorage_items LEFT JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid WHERE netstorage_items.uuid=? AND netstorage_items.active=1");
+	statement, err := db.Prepare ("SELECT netstorage_items.uuid, netstorage_items.folderuuid, netstorage_folders.projectuuid, netstorage_items.itemname, netstorage_items.active, " + SQL_ITEMLOCKFIELDS + " FROM netstorage_items LEFT JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid WHERE netstorage_items.uuid=? AND netstorage_items.active=1");
 	if (err != nil) {
 		return item, err;
 	}
@@ -301,29 +356,45 @@ func RetrieveItemByUUID (db *sql.DB, itemuuid string) (NetStorageItem, error) {
 		return item, errors.New("item not found: " + itemuuid);		
 	}	
 	
-	err = rows.Scan (&item.UUID, &item.FolderUUID, &item.ProjectUUID, &item.Name, &item.Active);
+	var lockuserid, locknote, locktimestamp, lockexpiry string;
+	err = rows.Scan (&item.UUID, &item.FolderUUID, &item.ProjectUUID, &item.Name, &item.Active, &lockuserid, &locknote, &locktimestamp, &lockexpiry);
 	rows.Close ();		
 	
-	return item, nil;
+	item.Lock = makeItemLock (lockuserid, locknote, locktimestamp, lockexpiry);
+	
+	return item, err;
 }
 
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
-// RetrieveEntityByUUID
-// retrieves a entity by uuid
+// RetrieveEntitiesOfItem
+// retrieves the entities of an item that match the list options
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func RetrieveEntitiesOfItem (db *sql.DB, itemuuid string) ([]NetStorageEntity, error) {
+func RetrieveEntitiesOfItem (db *sql.DB, itemuuid string, options NetStorageListOptions) ([]NetStorageEntity, NetStorageListPage, error) {
 	var entities []NetStorageEntity;
+	var page NetStorageListPage;
 	
-	statement, err := db.Prepare ("SELECT netstorage_entities.uuid, netstorage_entities.itemuuid, netstorage_entities.datatype, netstorage_entities.sha1, netstorage_entities.filesize, netstorage_entities.metadata, netstorage_entities.timestamp, netstorage_entities.active FROM netstorage_entities WHERE netstorage_entities.itemuuid=? ORDER BY timestamp");
-	if (err != nil) {
-		return entities, err;
-	}
-		
-	rows, err := statement.Query(itemuuid);
+	// versioned entities come first in version order, followed by the others in upload order
+	query := NetStorageListQuery {
+		Fields: "netstorage_entities.uuid, netstorage_entities.itemuuid, IFNULL(netstorage_entities.datatype, ''), IFNULL(netstorage_entities.sha1, ''), netstorage_entities.filesize, IFNULL(netstorage_entities.metadata, ''), IFNULL(netstorage_entities.timestamp, ''), netstorage_entities.active, netstorage_entities.version, netstorage_entities.userid, netstorage_entities.comment, " +
+			"(netstorage_entities.uuid=" + SQL_CURRENTENTITY + ")",
+		From: "netstorage_entities JOIN netstorage_items ON netstorage_items.uuid=netstorage_entities.itemuuid",
+		Condition: "netstorage_entities.itemuuid=? AND netstorage_entities.sourceentityuuid=''",
+		Arguments: []interface{} { itemuuid },
+		UUIDField: "netstorage_entities.uuid",
+		SortFields: map[string]string { 
+			LISTSORT_VERSION: "(CASE WHEN netstorage_entities.version>0 THEN printf('%020d', netstorage_entities.version) ELSE 'z' || IFNULL(netstorage_entities.timestamp, '') END)",
+			LISTSORT_TIMESTAMP: "IFNULL(netstorage_entities.timestamp, '')",
+			LISTSORT_SIZE: "netstorage_entities.filesize",
+		},
+		DefaultSort: LISTSORT_VERSION,
+		DataTypeField: "netstorage_entities.datatype",
+	};
+	
+	rows, err := openList (db, query, options);
 	if (err != nil) {
-		return entities, err;
+		return entities, page, err;
 	}
 	
 	defer rows.Close();
@@ -332,23 +403,23 @@ func RetrieveEntitiesOfItem (db *sql.DB, itemuuid string) ([]NetStorageEntity, e
 	for (rows.Next()) {
 		var entity NetStorageEntity;
 		
-		err = rows.Scan (&entity.UUID, &entity.ItemUUID, &entity.DataType, &entity.SHA1, &entity.FileSize, &entity.MetaData, &entity.TimeStamp, &entity.Active);
+		err = rows.Scan (&entity.UUID, &entity.ItemUUID, &entity.DataType, &entity.SHA1, &entity.FileSize, &entity.MetaData, &entity.TimeStamp, &entity.Active, &entity.VersionNumber, &entity.UserID, &entity.Comment, &entity.Current);
 		if (err != nil) {
-			return entities, err;
+			return entities, page, err;
 		}
 		
 		
 		entities = append (entities, entity);
 	}
 	
-	
-	return entities, nil;
+	page, err = rows.Page ();
+	return entities, page, err;
 }
 
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 // RetrieveEntityByUUID
-// retrieves a entity by uuid
+// retrieves a entity by uuid. Derived entities are only accessible through their source entity.
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
 func RetrieveEntityByUUID (db *sql.DB, entityuuid string, needstobeactive bool) (NetStorageEntity, error) {
@@ -359,7 +430,7 @@ func RetrieveEntityByUUID (db *sql.DB, entityuuid string, needstobeactive bool)
 		activecondition = " AND netstorage_entities.active=1"
 	}
 	
-	statement, err := db.Prepare ("SELECT netstorage_entities.uuid, netstorage_entities.itemuuid, netstorage_entities.datatype, netstorage_entities.sha1, netstorage_entities.filesize, netstorage_entities.metadata, netstorage_entities.active FROM netstorage_entities WHERE netstorage_entities.uuid=?" + activecondition);
+	statement, err := db.Prepare ("SELECT netstorage_entities.uuid, netstorage_entities.itemuuid, IFNULL(netstorage_entities.datatype, ''), IFNULL(netstorage_entities.sha1, ''), netstorage_entities.filesize, IFNULL(netstorage_entities.metadata, ''), IFNULL(netstorage_entities.timestamp, ''), netstorage_entities.active, netstorage_entities.version, netstorage_entities.userid, netstorage_entities.comment FROM netstorage_entities WHERE netstorage_entities.uuid=? AND netstorage_entities.sourceentityuuid=''" + activecondition);
 	if (err != nil) {
 		return entity, err;
 	}
@@ -375,9 +446,9 @@ func RetrieveEntityByUUID (db *sql.DB, entityuuid string, needstobeactive bool)
 		return entity, errors.New("entity not found: " + entityuuid);		
 	}	
 	
-	err = rows.Scan (&entity.UUID, &entity.ItemUUID, &entity.DataType, &entity.SHA1, &entity.FileSize, entity.MetaData, &entity.Active);
+	err = rows.Scan (&entity.UUID, &entity.ItemUUID, &entity.DataType, &entity.SHA1, &entity.FileSize, &entity.MetaData, &entity.TimeStamp, &entity.Active, &entity.VersionNumber, &entity.UserID, &entity.Comment);
 	
-	return entity, nil;
+	return entity, err;
 }
 
 
@@ -412,7 +483,11 @@ func createNewProject (db *sql.DB, projectuuid string, projectname string, hubuu
 	}
 	
 	_, err = statement2.Exec(projectuuid, projectname, hubuuid);	
-	return err;
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_PROJECT, projectuuid, CHANGETYPE_CREATE);
 
 }
 
@@ -467,7 +542,11 @@ func createNewFolder (db *sql.DB, folderuuid string, projectuuid string, foldern
 	}
 	
 	_, err = statement3.Exec(folderuuid, foldername, projectuuid, parentuuid);	
-	return err;
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_FOLDER, folderuuid, CHANGETYPE_CREATE);
 
 }
 
@@ -486,7 +565,16 @@ func createNewItem (db *sql.DB, itemuuid string, folderuuid string, itemname str
 	}
 	
 	_, err = statement1.Exec(itemuuid, itemname, folderuuid);	
-	return err;
+	if (err != nil) {
+		return err;
+	}
+	
+	err = indexSearchItem (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_ITEM, itemuuid, CHANGETYPE_CREATE);
 
 }
 
@@ -496,7 +584,7 @@ func createNewItem (db *sql.DB, itemuuid string, folderuuid string, itemname str
 // creates a new entity DB entry
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func createNewEntity (db *sql.DB, entityuuid string, itemuuid string, sha1 string, filesize int64, active bool) (error) {
+func createNewEntity (db *sql.DB, entityuuid string, itemuuid string, sha1 string, filesize int64, userid string, active bool) (error) {
 
 	var activedbfield int;
 	if (active) {
@@ -507,15 +595,78 @@ func createNewEntity (db *sql.DB, entityuuid string, itemuuid string, sha1 strin
 	
 	timestamp := time.Now().Format(time.RFC3339);
 				
-	statement1, err := db.Prepare ("INSERT INTO netstorage_entities (uuid, itemuuid, sha1, filesize, timestamp, active) VALUES (?, ?, ?, ?, ?, ?)");
+	statement1, err := db.Prepare ("INSERT INTO netstorage_entities (uuid, itemuuid, sha1, filesize, timestamp, userid, active) VALUES (?, ?, ?, ?, ?, ?, ?)");
 	if (err != nil) {
 		return err;
 	}
 		
-	_, err = statement1.Exec(entityuuid, itemuuid, sha1, filesize, timestamp, activedbfield);	
+	_, err = statement1.Exec(entityuuid, itemuuid, sha1, filesize, timestamp, userid, activedbfield);	
+	if ((err != nil) || !active) {
+		return err;
+	}
 	
+	return recordChange (db, CHANGEOBJECT_ENTITY, entityuuid, CHANGETYPE_CREATE);
+
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createDerivedEntity
+// creates the DB entry of an entity that has been derived from the content of another entity, like 
+// a thumbnail. Derived entities belong to the item of their source entity, but are no version of it.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createDerivedEntity (db *sql.DB, entityuuid string, source NetStorageEntity, derivation string, datatype string, sha1 string, filesize int64, metadata string) (error) {
+
+	timestamp := time.Now().Format(time.RFC3339);
+				
+	statement, err := db.Prepare ("INSERT INTO netstorage_entities (uuid, itemuuid, datatype, sha1, filesize, metadata, timestamp, userid, active, sourceentityuuid, derivation) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)");
+	if (err != nil) {
+		return err;
+	}
+	
+	defer statement.Close ();
+		
+	_, err = statement.Exec(entityuuid, source.ItemUUID, datatype, sha1, filesize, metadata, timestamp, source.UserID, source.UUID, derivation);
 	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveDerivedEntities
+// retrieves the entities that have been derived from an entity. An empty derivation retrieves all 
+// of them.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveDerivedEntities (db *sql.DB, sourceentityuuid string, derivation string) ([]NetStorageEntity, error) {
+	entities := make ([]NetStorageEntity, 0);
+
+	statement, err := db.Prepare ("SELECT uuid, itemuuid, IFNULL(datatype, ''), IFNULL(sha1, ''), filesize, IFNULL(metadata, ''), IFNULL(timestamp, ''), active, version, userid, comment FROM netstorage_entities WHERE sourceentityuuid=? AND (derivation=? OR ?='') ORDER BY timestamp DESC, rowid DESC");
+	if (err != nil) {
+		return entities, err;
+	}
+	
+	defer statement.Close ();
+		
+	rows, err := statement.Query(sourceentityuuid, derivation, derivation);
+	if (err != nil) {
+		return entities, err;
+	}
+	
+	defer rows.Close();
 
+	for (rows.Next()) {
+		var entity NetStorageEntity;
+		
+		err = rows.Scan (&entity.UUID, &entity.ItemUUID, &entity.DataType, &entity.SHA1, &entity.FileSize, &entity.MetaData, &entity.TimeStamp, &entity.Active, &entity.VersionNumber, &entity.UserID, &entity.Comment);
+		if (err != nil) {
+			return entities, err;
+		}
+		
+		entities = append (entities, entity);
+	}
+	
+	return entities, rows.Err ();
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -531,6 +682,23 @@ func updateEntity (db *sql.DB, entityuuid string, datatype string, metadata stri
 	} else {
 		activedbfield = 0;
 	}
+	
+	// activating an uploaded entity creates it from the point of view of the change journal
+	wasactive := 0;
+	err := db.QueryRow ("SELECT IFNULL(active, 0) FROM netstorage_entities WHERE uuid=?", entityuuid).Scan (&wasactive);
+	if ((err != nil) && (err != sql.ErrNoRows)) {
+		return err;
+	}
+	
+	change := "";
+	if (active) {
+		change = CHANGETYPE_UPDATE;
+		if (wasactive == 0) {
+			change = CHANGETYPE_CREATE;
+		}
+	} else if (wasactive != 0) {
+		change = CHANGETYPE_DELETE;
+	}
 				
 	statement1, err := db.Prepare ("UPDATE netstorage_entities SET active=?, datatype=?, metadata=? WHERE uuid=?");
 	if (err != nil) {
@@ -538,7 +706,635 @@ func updateEntity (db *sql.DB, entityuuid string, datatype string, metadata stri
 	}
 	
 	_, err = statement1.Exec(activedbfield, datatype, metadata, entityuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	if (active) {
+		err = assignEntityVersion (db, entityuuid);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	err = indexSearchEntity (db, entityuuid);
+	if ((err != nil) || (change == "")) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_ENTITY, entityuuid, change);
+
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveProjectByUUID
+// retrieves a project by uuid
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveProjectByUUID (db *sql.DB, projectuuid string) (NetStorageProject, error) {
+	var project NetStorageProject;
+	
+	statement, err := db.Prepare ("SELECT uuid, hubuuid, projectname, active FROM netstorage_projects WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return project, err;
+	}
+		
+	rows, err := statement.Query(projectuuid);
+	if (err != nil) {
+		return project, err;
+	}
+	
+	defer rows.Close();
+
+	if (!rows.Next()) {
+		return project, errors.New("project not found: " + projectuuid);		
+	}	
+	
+	err = rows.Scan (&project.UUID, &project.HubUUID, &project.Name, &project.Active);
+	
+	return project, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// Folder subtree queries
+// SQL_FOLDERSUBTREE selects the uuids of an active folder and all its active descendants, 
+// SQL_FOLDERSUBTREEWITHDELETED also selects the deleted descendants that can be restored into it
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const SQL_FOLDERSUBTREE = "WITH RECURSIVE subtree(uuid) AS (" +
+	"SELECT uuid FROM netstorage_folders WHERE uuid=? AND active=1 " +
+	"UNION SELECT netstorage_folders.uuid FROM netstorage_folders JOIN subtree ON netstorage_folders.parentuuid=subtree.uuid WHERE netstorage_folders.active=1" +
+	") SELECT uuid FROM subtree";
+
+const SQL_FOLDERSUBTREEWITHDELETED = "WITH RECURSIVE subtree(uuid) AS (" +
+	"SELECT uuid FROM netstorage_folders WHERE uuid=? AND active=1 " +
+	"UNION SELECT netstorage_folders.uuid FROM netstorage_folders JOIN subtree ON netstorage_folders.parentuuid=subtree.uuid" +
+	") SELECT uuid FROM subtree";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// folderIsInSubtree
+// checks if a folder is equal to or a descendant of another folder
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func folderIsInSubtree (db *sql.DB, folderuuid string, subtreeuuid string) (bool, error) {
+
+	statement, err := db.Prepare ("WITH RECURSIVE ancestors(uuid, parentuuid) AS (" +
+		"SELECT uuid, parentuuid FROM netstorage_folders WHERE uuid=? " +
+		"UNION SELECT netstorage_folders.uuid, netstorage_folders.parentuuid FROM netstorage_folders JOIN ancestors ON netstorage_folders.uuid=ancestors.parentuuid" +
+		") SELECT uuid FROM ancestors WHERE uuid=?");
+	if (err != nil) {
+		return false, err;
+	}
+		
+	rows, err := statement.Query(folderuuid, subtreeuuid);
+	if (err != nil) {
+		return false, err;
+	}
+	
+	defer rows.Close();
+	
+	return rows.Next(), nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// renameProject, renameFolder, renameItem
+// change the name of a DB entry
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func renameProject (db *sql.DB, projectuuid string, projectname string) (error) {
+
+	statement, err := db.Prepare ("UPDATE netstorage_projects SET projectname=? WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(projectname, projectuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_PROJECT, projectuuid, CHANGETYPE_UPDATE);
+}
+
+func renameFolder (db *sql.DB, folderuuid string, foldername string) (error) {
+
+	statement, err := db.Prepare ("UPDATE netstorage_folders SET foldername=? WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(foldername, folderuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_FOLDER, folderuuid, CHANGETYPE_UPDATE);
+}
+
+func renameItem (db *sql.DB, itemuuid string, itemname string) (error) {
+
+	statement, err := db.Prepare ("UPDATE netstorage_items SET itemname=? WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(itemname, itemuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	err = indexSearchItem (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_ITEM, itemuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// moveFolder
+// moves a folder to a new parent. If the parent belongs to another project, the whole subtree is 
+// moved to that project, including deleted folders so that they are restored into the right project.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func moveFolder (db *sql.DB, folderuuid string, parentuuid string, projectuuid string) (error) {
+
+	folder, err := RetrieveFolderByUUID (db, folderuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	// the folder leaves its old project
+	if (folder.ProjectUUID != projectuuid) {
+		err = recordChange (db, CHANGEOBJECT_FOLDER, folderuuid, CHANGETYPE_UPDATE);
+		if (err != nil) {
+			return err;
+		}
+	}
+
+	statement1, err := db.Prepare ("UPDATE netstorage_folders SET projectuuid=? WHERE uuid IN (" + SQL_FOLDERSUBTREEWITHDELETED + ")");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement1.Exec(projectuuid, folderuuid);	
+	if (err != nil) {
+		return err;
+	}
+
+	statement2, err := db.Prepare ("UPDATE netstorage_folders SET parentuuid=? WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement2.Exec(parentuuid, folderuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_FOLDER, folderuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// moveItem
+// moves an item to another folder, which may belong to another project
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func moveItem (db *sql.DB, itemuuid string, folderuuid string, projectuuid string) (error) {
+
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	// the item leaves its old project
+	if (item.ProjectUUID != projectuuid) {
+		err = recordChange (db, CHANGEOBJECT_ITEM, itemuuid, CHANGETYPE_UPDATE);
+		if (err != nil) {
+			return err;
+		}
+	}
+
+	statement, err := db.Prepare ("UPDATE netstorage_items SET folderuuid=? WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(folderuuid, itemuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_ITEM, itemuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// deleteFolder
+// soft deletes a folder with all its subfolders and items and records them with the given deletion. 
+// Returns the number of deleted folders and items.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func deleteFolder (db *sql.DB, folderuuid string, deletionuuid string) (int, int, error) {
+
+	// items first, the subtree query only follows active folders
+	statement1, err := db.Prepare ("INSERT INTO netstorage_deletedobjects (deletionuuid, objecttype, objectuuid) SELECT ?, ?, uuid FROM netstorage_items WHERE active=1 AND folderuuid IN (" + SQL_FOLDERSUBTREE + ")");
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	_, err = statement1.Exec(deletionuuid, DELETIONTYPE_ITEM, folderuuid);	
+	if (err != nil) {
+		return 0, 0, err;
+	}
+
+	statement2, err := db.Prepare ("UPDATE netstorage_items SET active=0 WHERE active=1 AND folderuuid IN (" + SQL_FOLDERSUBTREE + ")");
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	result, err := statement2.Exec(folderuuid);	
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	itemcount, err := result.RowsAffected();
+	if (err != nil) {
+		return 0, 0, err;
+	}
+
+	statement3, err := db.Prepare ("INSERT INTO netstorage_deletedobjects (deletionuuid, objecttype, objectuuid) SELECT ?, ?, uuid FROM (" + SQL_FOLDERSUBTREE + ")");
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	_, err = statement3.Exec(deletionuuid, DELETIONTYPE_FOLDER, folderuuid);	
+	if (err != nil) {
+		return 0, 0, err;
+	}
+
+	statement4, err := db.Prepare ("UPDATE netstorage_folders SET active=0 WHERE uuid IN (" + SQL_FOLDERSUBTREE + ")");
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	result, err = statement4.Exec(folderuuid);	
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	foldercount, err := result.RowsAffected();
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	return int (foldercount), int (itemcount), nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// deleteItem
+// soft deletes an item and records it with the given deletion
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func deleteItem (db *sql.DB, itemuuid string, deletionuuid string) (error) {
+
+	err := addDeletedObject (db, deletionuuid, DELETIONTYPE_ITEM, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+
+	statement, err := db.Prepare ("UPDATE netstorage_items SET active=0 WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(itemuuid);	
 	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// deleteEntity
+// soft deletes an entity and records it with the given deletion
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func deleteEntity (db *sql.DB, entityuuid string, deletionuuid string) (error) {
+
+	err := addDeletedObject (db, deletionuuid, DELETIONTYPE_ENTITY, entityuuid);
+	if (err != nil) {
+		return err;
+	}
+
+	statement, err := db.Prepare ("UPDATE netstorage_entities SET active=0 WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(entityuuid);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// deleteProject
+// soft deletes a project with all its folders and items and records them with the given deletion. 
+// Returns the number of deleted folders and items.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func deleteProject (db *sql.DB, projectuuid string, deletionuuid string) (int, int, error) {
+
+	rootfolders, _, err := RetrieveRootFoldersOfProject (db, projectuuid, NetStorageListOptions {});
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	foldercount := 0;
+	itemcount := 0;
+	for _, rootfolder := range rootfolders {
+		folders, items, err := deleteFolder (db, rootfolder.UUID, deletionuuid);
+		if (err != nil) {
+			return 0, 0, err;
+		}
+		
+		foldercount = foldercount + folders;
+		itemcount = itemcount + items;
+	}
+
+	err = addDeletedObject (db, deletionuuid, DELETIONTYPE_PROJECT, projectuuid);
+	if (err != nil) {
+		return 0, 0, err;
+	}
 
+	statement, err := db.Prepare ("UPDATE netstorage_projects SET active=0 WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	_, err = statement.Exec(projectuuid);	
+	if (err != nil) {
+		return 0, 0, err;
+	}
+	
+	return foldercount, itemcount, nil;
 }
 
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveFolderTree
+// retrieves the active folders and items below the start folders in one query. The start folders are 
+// either a single folder or all root folders of a project. Subfolders deeper than maxdepth levels are 
+// omitted, a negative maxdepth returns the whole tree. If withentities is set, the current entity 
+// of each item is returned as well.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveFolderTree (db *sql.DB, projectuuid string, folderuuid string, maxdepth int, withentities bool) ([]*NetStorageTreeFolder, error) {
+	var rootfolders []*NetStorageTreeFolder;
+
+	startcondition := "uuid=?";
+	startuuid := folderuuid;
+	if (folderuuid == "") {
+		startcondition = "projectuuid=? AND parentuuid=''";
+		startuuid = projectuuid;
+	}
+	
+	depthcondition := "";
+	if (maxdepth >= 0) {
+		depthcondition = " AND tree.depth<?";
+	}
+
+	entityfields := "'', '', '', 0, '', '', 0, 0, '', ''";
+	entityjoin := "";
+	if (withentities) {
+		entityfields = "IFNULL(netstorage_entities.uuid, ''), IFNULL(netstorage_entities.datatype, ''), IFNULL(netstorage_entities.sha1, ''), IFNULL(netstorage_entities.filesize, 0), IFNULL(netstorage_entities.metadata, ''), IFNULL(netstorage_entities.timestamp, ''), IFNULL(netstorage_entities.active, 0), IFNULL(netstorage_entities.version, 0), IFNULL(netstorage_entities.userid, ''), IFNULL(netstorage_entities.comment, '')";
+		entityjoin = " LEFT JOIN netstorage_entities ON netstorage_entities.uuid=" + SQL_CURRENTENTITY;
+	}
+
+	statement, err := db.Prepare ("WITH RECURSIVE tree(uuid, projectuuid, parentuuid, foldername, depth) AS (" +
+		"SELECT uuid, projectuuid, parentuuid, foldername, 0 FROM netstorage_folders WHERE " + startcondition + " AND active=1 " +
+		"UNION ALL SELECT netstorage_folders.uuid, netstorage_folders.projectuuid, netstorage_folders.parentuuid, netstorage_folders.foldername, tree.depth+1 FROM netstorage_folders JOIN tree ON netstorage_folders.parentuuid=tree.uuid WHERE netstorage_folders.active=1" + depthcondition +
+		") SELECT tree.uuid, tree.projectuuid, tree.parentuuid, tree.foldername, tree.depth, IFNULL(netstorage_items.uuid, ''), IFNULL(netstorage_items.itemname, ''), " +
+		"IFNULL(netstorage_items.lockuserid, ''), IFNULL(netstorage_items.locknote, ''), IFNULL(netstorage_items.locktimestamp, ''), IFNULL(netstorage_items.lockexpiry, ''), " + entityfields + 
+		" FROM tree LEFT JOIN netstorage_items ON netstorage_items.folderuuid=tree.uuid AND netstorage_items.active=1" + entityjoin + 
+		" ORDER BY tree.depth, tree.foldername, tree.uuid, netstorage_items.itemname");
+	if (err != nil) {
+		return rootfolders, err;
+	}
+	
+	var rows *sql.Rows;
+	if (maxdepth >= 0) {
+		rows, err = statement.Query(startuuid, maxdepth);
+	} else {
+		rows, err = statement.Query(startuuid);
+	}
+	if (err != nil) {
+		return rootfolders, err;
+	}
+	
+	defer rows.Close();
+
+	folders := make (map[string]*NetStorageTreeFolder);
+	
+	for (rows.Next()) {
+		var folder NetStorageTreeFolder;
+		var item NetStorageTreeItem;
+		var entity NetStorageEntity;
+		var lockuserid, locknote, locktimestamp, lockexpiry string;
+		
+		err = rows.Scan (&folder.UUID, &folder.ProjectUUID, &folder.ParentUUID, &folder.Name, &folder.Depth, &item.UUID, &item.Name, &lockuserid, &locknote, &locktimestamp, &lockexpiry,
+			&entity.UUID, &entity.DataType, &entity.SHA1, &entity.FileSize, &entity.MetaData, &entity.TimeStamp, &entity.Active, &entity.VersionNumber, &entity.UserID, &entity.Comment);
+		if (err != nil) {
+			return rootfolders, err;
+		}
+		
+		treefolder, exists := folders[folder.UUID];
+		if (!exists) {
+			treefolder = &folder;
+			treefolder.Active = 1;
+			treefolder.SubFolders = []*NetStorageTreeFolder {};
+			treefolder.Items = []NetStorageTreeItem {};
+			folders[folder.UUID] = treefolder;
+			
+			parent, hasparent := folders[folder.ParentUUID];
+			if (hasparent && (folder.Depth > 0)) {
+				parent.SubFolders = append (parent.SubFolders, treefolder);
+			} else {
+				rootfolders = append (rootfolders, treefolder);
+			}
+		}
+		
+		if (item.UUID != "") {
+			item.ProjectUUID = treefolder.ProjectUUID;
+			item.FolderUUID = treefolder.UUID;
+			item.Active = 1;
+			item.Lock = makeItemLock (lockuserid, locknote, locktimestamp, lockexpiry);
+			
+			if (entity.UUID != "") {
+				entity.ItemUUID = item.UUID;
+				entity.Current = true;
+				item.CurrentEntity = &entity;
+			}
+			
+			treefolder.Items = append (treefolder.Items, item);
+		}
+	}
+	
+	return rootfolders, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// Current entity query
+// SQL_CURRENTENTITY selects the uuid of the current entity of netstorage_items. This is the pinned 
+// entity, if it is still active, and the highest active version otherwise. Derived entities are never 
+// current.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const SQL_CURRENTENTITY = "(SELECT latest.uuid FROM netstorage_entities AS latest WHERE latest.itemuuid=netstorage_items.uuid AND latest.active=1 AND latest.sourceentityuuid='' " +
+	"ORDER BY (latest.uuid=netstorage_items.currententityuuid) DESC, latest.version DESC, latest.timestamp DESC, latest.rowid DESC LIMIT 1)";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// assignEntityVersion
+// gives an activated entity the next version number of its item and makes it the current entity. 
+// Entities that already have a version number keep it.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func assignEntityVersion (db *sql.DB, entityuuid string) (error) {
+
+	statement1, err := db.Prepare ("UPDATE netstorage_entities SET version=(SELECT IFNULL(MAX(version), 0) + 1 FROM netstorage_entities AS previous WHERE previous.itemuuid=netstorage_entities.itemuuid) WHERE uuid=? AND version=0 AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	result, err := statement1.Exec(entityuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	count, err := result.RowsAffected();
+	if ((err != nil) || (count == 0)) {
+		return err;
+	}
+
+	// a new version replaces a pinned older version
+	statement2, err := db.Prepare ("UPDATE netstorage_items SET currententityuuid='' WHERE uuid=(SELECT itemuuid FROM netstorage_entities WHERE uuid=?)");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement2.Exec(entityuuid);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// updateEntityComment
+// sets the version comment of an entity
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func updateEntityComment (db *sql.DB, entityuuid string, comment string) (error) {
+
+	statement, err := db.Prepare ("UPDATE netstorage_entities SET comment=? WHERE uuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(comment, entityuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_ENTITY, entityuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// updateEntityMetadata
+// replaces the metadata of an entity
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func updateEntityMetadata (db *sql.DB, entityuuid string, metadata string) (error) {
+
+	statement, err := db.Prepare ("UPDATE netstorage_entities SET metadata=? WHERE uuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(metadata, entityuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	err = indexSearchEntity (db, entityuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_ENTITY, entityuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// setCurrentEntity
+// pins an entity as the current entity of its item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func setCurrentEntity (db *sql.DB, itemuuid string, entityuuid string) (error) {
+
+	statement, err := db.Prepare ("UPDATE netstorage_items SET currententityuuid=? WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(entityuuid, itemuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_ITEM, itemuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveCurrentEntityOfItem
+// retrieves the current entity of an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveCurrentEntityOfItem (db *sql.DB, itemuuid string) (NetStorageEntity, error) {
+	var entity NetStorageEntity;
+
+	statement, err := db.Prepare ("SELECT " + SQL_CURRENTENTITY + " FROM netstorage_items WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return entity, err;
+	}
+		
+	rows, err := statement.Query(itemuuid);
+	if (err != nil) {
+		return entity, err;
+	}
+	
+	var entityuuid sql.NullString;
+	if (rows.Next()) {
+		err = rows.Scan (&entityuuid);
+	}
+	rows.Close ();
+	if (err != nil) {
+		return entity, err;
+	}
+	
+	if (!entityuuid.Valid) {
+		return entity, errors.New("item has no current entity: " + itemuuid);		
+	}
+	
+	entity, err = RetrieveEntityByUUID (db, entityuuid.String, true);
+	entity.Current = true;
+	
+	return entity, err;
+}
@@ -0,0 +1,294 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_events.go
+// Live notifications as Server-Sent Events. A client keeps a /events request open and receives the 
+// entries of the change journal that concern the projects, folders and tasks it has subscribed to. 
+// The event id is the journal sequence number, so that a reconnecting client can resume with the 
+// Last-Event-ID header without missing changes.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+
+const EVENTS_KEEPALIVESECONDS = 15;
+const EVENTS_BATCHSIZE = 100;
+
+// Closed and replaced whenever new changes might have been committed
+var ChangeSignal = make (chan struct{});
+var ChangeSignalMutex sync.Mutex;
+
+
+// The objects an event stream has subscribed to
+type NetStorageEventSubscription struct {
+	Projects []string
+	Folders []string
+	Tasks []string
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// signalChangeListeners, waitForChanges
+// wake up all open event streams. A stream retrieves the signal channel before it reads the journal 
+// and waits until the channel is closed.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func signalChangeListeners () {
+	ChangeSignalMutex.Lock ();
+	defer ChangeSignalMutex.Unlock ();
+	
+	close (ChangeSignal);
+	ChangeSignal = make (chan struct{});
+}
+
+func waitForChanges () (chan struct{}) {
+	ChangeSignalMutex.Lock ();
+	defer ChangeSignalMutex.Unlock ();
+	
+	return ChangeSignal;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// parseEventSubscription
+// parses and checks the project=<uuid>, folder=<uuid> and task=<uuid> query parameters of an event 
+// stream request. Each parameter can be repeated.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func parseEventSubscription (db *sql.DB, r *http.Request) (NetStorageEventSubscription, error) {
+	var subscription NetStorageEventSubscription;
+
+	parameters := r.URL.Query ();
+	subscription.Projects = parameters["project"];
+	subscription.Folders = parameters["folder"];
+	subscription.Tasks = parameters["task"];
+	
+	if ((len (subscription.Projects) == 0) && (len (subscription.Folders) == 0) && (len (subscription.Tasks) == 0)) {
+		return subscription, errors.New ("No project, folder or task to subscribe to");
+	}
+	
+	for _, projectuuid := range subscription.Projects {
+		_, err := RetrieveProjectByUUID (db, projectuuid);
+		if (err != nil) {
+			return subscription, err;
+		}
+	}
+	
+	for _, folderuuid := range subscription.Folders {
+		_, err := RetrieveFolderByUUID (db, folderuuid);
+		if (err != nil) {
+			return subscription, err;
+		}
+	}
+	
+	for _, taskuuid := range subscription.Tasks {
+		count := 0;
+		err := db.QueryRow ("SELECT COUNT(*) FROM netstorage_tasks WHERE uuid=?", taskuuid).Scan (&count);
+		if (err != nil) {
+			return subscription, err;
+		}
+		if (count == 0) {
+			return subscription, errors.New ("task not found: " + taskuuid);
+		}
+	}
+	
+	return subscription, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// matchEventSubscription
+// checks if a change concerns a subscription. Folder subscriptions include the whole subtree.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func matchEventSubscription (db *sql.DB, subscription NetStorageEventSubscription, change NetStorageChange) (bool, error) {
+
+	for _, projectuuid := range subscription.Projects {
+		if (change.ProjectUUID == projectuuid) {
+			return true, nil;
+		}
+	}
+	
+	if (change.ObjectType == CHANGEOBJECT_TASK) {
+		for _, taskuuid := range subscription.Tasks {
+			if (change.ObjectUUID == taskuuid) {
+				return true, nil;
+			}
+		}
+	}
+	
+	for _, folderuuid := range subscription.Folders {
+		if ((change.ObjectType == CHANGEOBJECT_FOLDER) && (change.ObjectUUID == folderuuid)) {
+			return true, nil;
+		}
+		
+		if (change.FolderUUID != "") {
+			insubtree, err := folderIsInSubtree (db, change.FolderUUID, folderuuid);
+			if ((err != nil) || insubtree) {
+				return insubtree, err;
+			}
+		}
+	}
+	
+	return false, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// writeEvent
+// writes a Server-Sent Event. The data is sent as one JSON line.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func writeEvent (w http.ResponseWriter, id string, event string, data interface{}) (error) {
+
+	jsondata, err := json.Marshal (data);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (id != "") {
+		_, err = fmt.Fprintf (w, "id: %s\n", id);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	_, err = fmt.Fprintf (w, "event: %s\ndata: %s\n\n", event, string (jsondata));
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageEventsHandler
+// handles a /events GET request and streams the changes of the subscribed projects, folders and 
+// tasks as Server-Sent Events until the client disconnects or its session expires. Without cursor 
+// query parameter or Last-Event-ID header, the stream starts with the next change.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageEventsHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
+	addLogMessage (session, "Opening event stream: " + r.URL.RawQuery, LOGTYPE_DATA_EVENTS, LOGLEVEL_CONSOLE);	
+
+	flusher, canflush := w.(http.Flusher);
+	if (!canflush) {
+		return errors.New ("Event streams are not supported by this connection");
+	}
+	
+	subscription, err := parseEventSubscription (db, r);
+	if (err != nil) {
+		return err;
+	}
+	
+	cursor := r.Header.Get ("Last-Event-ID");
+	if (cursor == "") {
+		cursor = r.URL.Query().Get ("cursor");
+	}
+	if (cursor == "") {
+		cursor = CHANGES_LATESTCURSOR;
+	}
+	
+	_, after, err := parseChangeCursor (db, strings.TrimSpace (cursor));
+	if (err != nil) {
+		return err;
+	}
+	
+	w.Header().Set ("Content-Type", "text/event-stream");
+	w.Header().Set ("Cache-Control", "no-cache");
+	w.Header().Set ("X-Accel-Buffering", "no");
+	w.WriteHeader (http.StatusOK);
+	flusher.Flush ();
+	
+	keepalive := time.NewTicker (EVENTS_KEEPALIVESECONDS * time.Second);
+	defer keepalive.Stop ();
+	
+	// errors after the stream has started are sent as event, the response header cannot be changed anymore
+	for {
+		signal := waitForChanges ();
+	
+		changes, hasmore, err := RetrieveChanges (db, CHANGESCOPE_ALL, "", after, EVENTS_BATCHSIZE);
+		if (err != nil) {
+			writeEvent (w, "", "error", err.Error ());
+			return nil;
+		}
+		
+		for _, change := range changes {
+			after = change.Sequence;
+			
+			match, err := matchEventSubscription (db, subscription, change);
+			if (err != nil) {
+				writeEvent (w, "", "error", err.Error ());
+				return nil;
+			}
+			
+			if (match) {
+				err = writeEvent (w, fmt.Sprintf ("%d", change.Sequence), "change", &change);
+				if (err != nil) {
+					return nil;
+				}
+			}
+		}
+		flusher.Flush ();
+		
+		if (hasmore) {
+			continue;
+		}
+		
+		select {
+			case <- signal:
+			
+			case <- keepalive.C:
+				_, err = retrieveSessionByToken (session.Token, GlobalConfig.Authentication.DurationOfSessions);
+				if (err != nil) {
+					writeEvent (w, "", "error", err.Error ());
+					addLogMessage (session, "Closing event stream: " + err.Error (), LOGTYPE_DATA_EVENTS, LOGLEVEL_CONSOLE);	
+					return nil;
+				}
+				
+				_, err = fmt.Fprintf (w, ": keepalive\n\n");
+				if (err != nil) {
+					return nil;
+				}
+				flusher.Flush ();
+			
+			case <- r.Context().Done():
+				addLogMessage (session, "Event stream closed by client", LOGTYPE_DATA_EVENTS, LOGLEVEL_CONSOLE);	
+				return nil;
+		}
+	}
+}
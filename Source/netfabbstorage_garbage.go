@@ -0,0 +1,496 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_garbage.go
+// Garbage collection of the data directory. Removes entities that have been uploaded but never 
+// activated, abandoned chunked uploads and files that are not referenced by the database anymore. 
+// Only objects older than the grace period are collected, so that running uploads are not affected.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"net/http"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"database/sql"
+)
+
+
+// Serializes background and manually triggered collections
+var GarbageCollectionMutex sync.Mutex;
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// isOlderThan
+// checks if a RFC3339 timestamp lies before a given time. Invalid timestamps are never old.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func isOlderThan (timestamp string, cutoff time.Time) bool {
+
+	parsedtime, err := time.Parse (time.RFC3339, timestamp);
+	if (err != nil) {
+		return false;
+	}
+	
+	return parsedtime.Before (cutoff);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveStaleEntities
+// retrieves the inactive entities that are not in the trash and have been created before the cutoff. 
+// These are uploads that have never been activated by an entity update.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveStaleEntities (db *sql.DB, cutoff time.Time) ([]NetStorageEntity, error) {
+	entities := make ([]NetStorageEntity, 0);
+	
+	statement, err := db.Prepare ("SELECT uuid, itemuuid, IFNULL(datatype, ''), IFNULL(sha1, ''), filesize, IFNULL(metadata, ''), IFNULL(timestamp, ''), active, version, userid, comment " +
+		"FROM netstorage_entities WHERE active=0 AND uuid NOT IN (SELECT objectuuid FROM netstorage_deletedobjects WHERE objecttype=?)");
+	if (err != nil) {
+		return entities, err;
+	}
+		
+	rows, err := statement.Query(DELETIONTYPE_ENTITY);
+	if (err != nil) {
+		return entities, err;
+	}
+	
+	defer rows.Close();
+
+	for (rows.Next()) {
+		var entity NetStorageEntity;
+		
+		err = rows.Scan (&entity.UUID, &entity.ItemUUID, &entity.DataType, &entity.SHA1, &entity.FileSize, &entity.MetaData, &entity.TimeStamp, &entity.Active, &entity.VersionNumber, &entity.UserID, &entity.Comment);
+		if (err != nil) {
+			return entities, err;
+		}
+		
+		if (isOlderThan (entity.TimeStamp, cutoff)) {
+			entities = append (entities, entity);
+		}
+	}
+	
+	return entities, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveStaleUploads
+// retrieves the open chunked uploads that have been initiated before the cutoff
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveStaleUploads (db *sql.DB, cutoff time.Time) ([]NetStorageUpload, error) {
+	uploads := make ([]NetStorageUpload, 0);
+	
+	statement, err := db.Prepare ("SELECT uuid, itemuuid, userid, filesize, hashoffset, hashstate, status, timestamp FROM netstorage_uploads WHERE status=?");
+	if (err != nil) {
+		return uploads, err;
+	}
+		
+	rows, err := statement.Query(UPLOADSTATUS_NEW);
+	if (err != nil) {
+		return uploads, err;
+	}
+	
+	defer rows.Close();
+
+	for (rows.Next()) {
+		var upload NetStorageUpload;
+		
+		err = rows.Scan (&upload.UUID, &upload.ItemUUID, &upload.UserID, &upload.FileSize, &upload.HashOffset, &upload.HashState, &upload.Status, &upload.TimeStamp);
+		if (err != nil) {
+			return uploads, err;
+		}
+		
+		if (isOlderThan (upload.TimeStamp, cutoff)) {
+			uploads = append (uploads, upload);
+		}
+	}
+	
+	return uploads, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// retrieveKeySet
+// retrieves the values of the first column of a query as a set
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func retrieveKeySet (db *sql.DB, query string, args ...interface{}) (map[string]bool, error) {
+	keys := make (map[string]bool);
+	
+	statement, err := db.Prepare (query);
+	if (err != nil) {
+		return keys, err;
+	}
+		
+	rows, err := statement.Query(args...);
+	if (err != nil) {
+		return keys, err;
+	}
+	
+	defer rows.Close();
+
+	for (rows.Next()) {
+		var key string;
+		
+		err = rows.Scan (&key);
+		if (err != nil) {
+			return keys, err;
+		}
+		
+		keys[key] = true;
+	}
+	
+	return keys, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveUnreferencedFiles
+// scans the local data directory for upload parts and the storage backend for legacy entity files 
+// and blob files that have been modified before the cutoff and are not referenced by the database. 
+// Other files are left alone.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveUnreferencedFiles (db *sql.DB, cutoff time.Time) ([]NetStorageGarbageFile, error) {
+	files := make ([]NetStorageGarbageFile, 0);
+	
+	entities, err := retrieveKeySet (db, "SELECT uuid FROM netstorage_entities");
+	if (err != nil) {
+		return files, err;
+	}
+
+	uploads, err := retrieveKeySet (db, "SELECT uuid FROM netstorage_uploads WHERE status=?", UPLOADSTATUS_NEW);
+	if (err != nil) {
+		return files, err;
+	}
+
+	blobs, err := retrieveKeySet (db, "SELECT sha1 FROM netstorage_blobs");
+	if (err != nil) {
+		return files, err;
+	}
+	
+	addFile := func (name string, size int64, modtime time.Time) {
+		var file NetStorageGarbageFile;
+		file.Name = name;
+		file.FileSize = size;
+		file.TimeStamp = modtime.Format (time.RFC3339);
+		files = append (files, file);
+	}
+	
+	// uploads are always staged in the local data directory
+	infos, err := ioutil.ReadDir (GlobalConfig.Data.Directory);
+	if (err != nil) {
+		return files, err;
+	}
+	
+	for _, info := range infos {
+		if (!info.Mode().IsRegular() || !info.ModTime().Before (cutoff) || (path.Ext (info.Name ()) != ".part")) {
+			continue;
+		}
+		
+		if (!uploads[strings.TrimSuffix (info.Name (), ".part")]) {
+			addFile (info.Name (), info.Size (), info.ModTime ());
+		}
+	}
+	
+	storedfiles, err := StorageBackend.List ("");
+	if (err != nil) {
+		return files, err;
+	}
+	
+	for _, storedfile := range storedfiles {
+		if (!storedfile.ModTime.Before (cutoff)) {
+			continue;
+		}
+		
+		name := storedfile.Name;
+		dir, base := path.Split (name);
+		extension := path.Ext (base);
+		key := strings.TrimSuffix (base, extension);
+		
+		var referenced bool;
+		switch {
+			case (dir == "") && (extension == ".dat"):
+				referenced = entities[key];
+			case strings.HasPrefix (dir, "blobs/") && (extension == ".dat") && (len (key) > 2) && (name == getBlobStorageName (key)):
+				referenced = blobs[key];
+			default:
+				continue;
+		}
+		
+		if (!referenced) {
+			addFile (name, storedfile.Size, storedfile.ModTime);
+		}
+	}
+	
+	return files, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// removeUnreferencedFile
+// removes a file found by RetrieveUnreferencedFiles. Blob files are checked again while holding the 
+// blob store lock, as a new upload might have referenced the blob in the meantime.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func removeUnreferencedFile (db *sql.DB, file NetStorageGarbageFile) (bool, error) {
+
+	if (path.Ext (file.Name) == ".part") {
+		err := os.Remove (filepath.Join (GlobalConfig.Data.Directory, file.Name));
+		if (os.IsNotExist (err)) {
+			return false, nil;
+		}
+		
+		return (err == nil), err;
+	}
+
+	if (strings.HasPrefix (file.Name, "blobs/")) {
+		BlobStoreMutex.Lock();
+		defer BlobStoreMutex.Unlock();
+		
+		blobs, err := retrieveKeySet (db, "SELECT sha1 FROM netstorage_blobs WHERE sha1=?", strings.TrimSuffix (path.Base (file.Name), ".dat"));
+		if (err != nil) {
+			return false, err;
+		}
+		
+		if (len (blobs) > 0) {
+			return false, nil;
+		}
+	}
+	
+	err := StorageBackend.Remove (file.Name);
+	
+	return (err == nil), err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// collectGarbage
+// finds and, unless it is a dry run, removes stale entities, stale uploads and unreferenced files 
+// that are older than the grace period in hours. Also prunes the change journal.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func collectGarbage (db *sql.DB, session * NetStorageSession, graceperiod int, dryrun bool) (NetStorageCollectGarbageReply, error) {
+	var result NetStorageCollectGarbageReply;
+	result.DryRun = dryrun;
+	result.GracePeriod = graceperiod;
+
+	GarbageCollectionMutex.Lock();
+	defer GarbageCollectionMutex.Unlock();
+	
+	cutoff := time.Now().Add (- time.Duration (graceperiod) * time.Hour);
+	
+	// entities that have never been activated
+	entities, err := RetrieveStaleEntities (db, cutoff);
+	if (err != nil) {
+		return result, err;
+	}
+	result.StaleEntities = entities;
+	
+	for _, entity := range entities {
+		addLogMessage (session, fmt.Sprintf ("Stale entity %s of item %s (%s bytes)", entity.UUID, entity.ItemUUID, entity.FileSize), LOGTYPE_DATA_GARBAGE, LOGLEVEL_CONSOLE);	
+	}
+	
+	if (!dryrun && (len (entities) > 0)) {
+		err = BeginTransaction (db);
+		if (err != nil) {
+			return result, err;
+		}
+		
+		purgedentities, err := purgeEntities (db, entities);
+		if (err != nil) {
+			RollbackTransaction (db);
+			return result, err;
+		}
+		
+		err = CommittTransaction (db);
+		if (err != nil) {
+			return result, err;
+		}
+		
+		for _, entity := range purgedentities {
+			err = releaseEntityContent (db, entity);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			filesize, err := strconv.ParseInt (entity.FileSize, 10, 64);
+			if (err == nil) {
+				result.ReleasedSize = result.ReleasedSize + filesize;
+			}
+		}
+	}
+	
+	// chunked uploads that have been abandoned
+	uploads, err := RetrieveStaleUploads (db, cutoff);
+	if (err != nil) {
+		return result, err;
+	}
+	result.StaleUploads = uploads;
+	
+	for _, upload := range uploads {
+		addLogMessage (session, fmt.Sprintf ("Stale upload %s of item %s (%d of %d bytes)", upload.UUID, upload.ItemUUID, upload.HashOffset, upload.FileSize), LOGTYPE_DATA_GARBAGE, LOGLEVEL_CONSOLE);	
+		
+		if (!dryrun) {
+			err = updateUploadStatus (db, upload.UUID, UPLOADSTATUS_ABORTED);
+			if (err != nil) {
+				return result, err;
+			}
+			
+			info, err := os.Stat (getUploadStorageName (upload.UUID));
+			if (err == nil) {
+				err = os.Remove (getUploadStorageName (upload.UUID));
+				if (err != nil) {
+					return result, err;
+				}
+				result.ReleasedSize = result.ReleasedSize + info.Size ();
+			}
+		}
+	}
+	
+	// journal entries that are older than the retention period
+	if (!dryrun && (GlobalConfig.Changes.RetentionDays > 0)) {
+		result.PrunedChanges, err = pruneChanges (db, GlobalConfig.Changes.RetentionDays);
+		if (err != nil) {
+			return result, err;
+		}
+	}
+	
+	// files that are not referenced anymore
+	files, err := RetrieveUnreferencedFiles (db, cutoff);
+	if (err != nil) {
+		return result, err;
+	}
+	result.UnreferencedFiles = files;
+	
+	for _, file := range files {
+		addLogMessage (session, fmt.Sprintf ("Unreferenced file %s (%d bytes)", file.Name, file.FileSize), LOGTYPE_DATA_GARBAGE, LOGLEVEL_CONSOLE);	
+		
+		if (!dryrun) {
+			removed, err := removeUnreferencedFile (db, file);
+			if (err != nil) {
+				return result, err;
+			}
+			if (removed) {
+				result.ReleasedSize = result.ReleasedSize + file.FileSize;
+			}
+		}
+	}
+	
+	return result, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// runGarbageCollector
+// runs the garbage collection in the configured interval
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func runGarbageCollector (config ConfigDefinitionGarbageCollection) {
+
+	for {
+		time.Sleep (time.Duration (config.IntervalMinutes) * time.Minute);
+		
+		session := createEmptySession ();
+		
+		db, err := OpenDB (GlobalConfig.Database.Type, GlobalConfig.Database.FileName);
+		if (err != nil) {
+			addLogMessage (&session, "Garbage collection failed: " + err.Error (), LOGTYPE_DATA_GARBAGE, LOGLEVEL_CONSOLE);	
+			continue;
+		}
+		
+		result, err := collectGarbage (db, &session, config.GracePeriodHours, config.DryRun);
+		db.Close ();
+		
+		if (err != nil) {
+			addLogMessage (&session, "Garbage collection failed: " + err.Error (), LOGTYPE_DATA_GARBAGE, LOGLEVEL_CONSOLE);	
+			continue;
+		}
+		
+		if ((len (result.StaleEntities) > 0) || (len (result.StaleUploads) > 0) || (len (result.UnreferencedFiles) > 0)) {
+			addLogMessage (&session, fmt.Sprintf ("Garbage collection found %d stale entities, %d stale uploads and %d unreferenced files, released %d bytes", 
+				len (result.StaleEntities), len (result.StaleUploads), len (result.UnreferencedFiles), result.ReleasedSize), LOGTYPE_DATA_GARBAGE, LOGLEVEL_CONSOLE);	
+		}
+	}
+	
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageCollectGarbageHandler
+// handles a /admin/collectgarbage POST request and runs a garbage collection. A dry run only reports 
+// what would be removed.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageCollectGarbageHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
+	addLogMessage (session, "Collecting garbage", LOGTYPE_DATA_GARBAGE, LOGLEVEL_CONSOLE);	
+	
+	err := checkAdministratorSession (session);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Parse JSON request
+	var request NetStorageCollectGarbageRequest;
+	err = parseJSONRequest (r, &request, PROTOCOL_COLLECTGARBAGE);
+	if (err != nil) {
+		return err;
+	}
+	
+	// a grace period of 0 uses the configured one
+	graceperiod := request.GracePeriod;
+	if (graceperiod <= 0) {
+		graceperiod = GlobalConfig.GarbageCollection.GracePeriodHours;
+	}
+	
+	if (request.DryRun) {
+		addLogMessage (session, "Dry run, nothing will be removed", LOGTYPE_DATA_GARBAGE, LOGLEVEL_CONSOLE);	
+	}
+	
+	result, err := collectGarbage (db, session, graceperiod, request.DryRun);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	reply := result;
+	reply.Protocol = PROTOCOL_COLLECTGARBAGE;
+	reply.Version = PROTOCOL_VERSION;
+	return sendJSON (w, &reply);			
+}
@@ -0,0 +1,328 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_hubs.go
+// Hub administration. Hubs are created, renamed and deactivated by administrators.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"net/http"
+	"errors"
+	"database/sql"
+)
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveHubByUUID
+// retrieves an active hub by uuid
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveHubByUUID (db *sql.DB, hubuuid string) (NetStorageHub, error) {
+	var hub NetStorageHub;
+	
+	statement, err := db.Prepare ("SELECT uuid, hubname, active FROM netstorage_hubs WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return hub, err;
+	}
+		
+	rows, err := statement.Query(hubuuid);
+	if (err != nil) {
+		return hub, err;
+	}
+	
+	defer rows.Close();
+
+	if (!rows.Next()) {
+		return hub, errors.New("hub not found: " + hubuuid);		
+	}	
+	
+	err = rows.Scan (&hub.UUID, &hub.Name, &hub.Active);
+	
+	return hub, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveHubStatistics
+// retrieves all hubs, including deactivated ones, with their number of projects, items and the 
+// storage they use. The entity count and the used storage count the entities like the hub quota, 
+// the blob size counts shared content and thumbnails once per hub and the stored size is the size 
+// of these blobs after compression.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveHubStatistics (db *sql.DB) ([]NetStorageHubStatistics, error) {
+	entries := make([] NetStorageHubStatistics, 0);
+
+	hubentities := "SELECT netstorage_entities.sha1, netstorage_entities.filesize " + SQL_QUOTAENTITIES + 
+		"WHERE netstorage_projects.hubuuid=netstorage_hubs.uuid";
+	quotaentities := hubentities + " AND netstorage_entities.sourceentityuuid=''";
+	
+	statement, err := db.Prepare ("SELECT uuid, hubname, active, " +
+		"(SELECT COUNT(*) FROM netstorage_projects WHERE netstorage_projects.hubuuid=netstorage_hubs.uuid AND netstorage_projects.active=1), " +
+		"(SELECT COUNT(*) FROM netstorage_items JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid " +
+		"JOIN netstorage_projects ON netstorage_projects.uuid=netstorage_folders.projectuuid WHERE netstorage_projects.hubuuid=netstorage_hubs.uuid AND netstorage_items.active=1), " +
+		"(SELECT COUNT(*) FROM (" + quotaentities + ")), " +
+		"(SELECT IFNULL(SUM(filesize), 0) FROM (" + quotaentities + ")), " +
+		"(SELECT IFNULL(SUM(filesize), 0) FROM netstorage_blobs WHERE sha1 IN (SELECT sha1 FROM (" + hubentities + "))), " +
+		"(SELECT IFNULL(SUM(storedsize), 0) FROM netstorage_blobs WHERE sha1 IN (SELECT sha1 FROM (" + hubentities + "))) " +
+		"FROM netstorage_hubs ORDER BY hubname");
+	if (err != nil) {
+		return entries, err;
+	}
+		
+	rows, err := statement.Query();
+	if (err != nil) {
+		return entries, err;
+	}
+	
+	defer rows.Close();
+
+	for (rows.Next()) {
+		var entry NetStorageHubStatistics;
+		
+		err = rows.Scan (&entry.UUID, &entry.Name, &entry.Active, &entry.ProjectCount, &entry.ItemCount, &entry.EntityCount, &entry.StorageUsed, &entry.BlobSize, &entry.StoredSize);
+		if (err != nil) {
+			return entries, err;
+		}
+								
+		entries = append (entries, entry);
+	}
+	
+	return entries, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createNewHub
+// creates a new hub DB entry
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createNewHub (db *sql.DB, hubuuid string, hubname string) (error) {
+
+	statement, err := db.Prepare ("INSERT INTO netstorage_hubs (uuid, hubname, active) VALUES (?, ?, 1)");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(hubuuid, hubname);	
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_HUB, hubuuid, CHANGETYPE_CREATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// renameHub
+// changes the name of a hub
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func renameHub (db *sql.DB, hubuuid string, hubname string) (error) {
+
+	statement, err := db.Prepare ("UPDATE netstorage_hubs SET hubname=? WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(hubname, hubuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_HUB, hubuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// deactivateHub
+// deactivates a hub. Its projects are kept, but are not listed anymore and no new projects can be 
+// created in the hub.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func deactivateHub (db *sql.DB, hubuuid string) (error) {
+
+	statement, err := db.Prepare ("UPDATE netstorage_hubs SET active=0 WHERE uuid=? AND active=1");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(hubuuid);	
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_HUB, hubuuid, CHANGETYPE_DELETE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageHubNewHandler
+// handles a /hubs POST request and creates a new hub
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageHubNewHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
+	addLogMessage (session, "Creating new hub", LOGTYPE_DATA_HUBADMIN, LOGLEVEL_CONSOLE);	
+	
+	err := checkAdministratorSession (session);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Parse JSON request
+	var request NetStorageNewHubRequest;
+	err = parseJSONRequest (r, &request, PROTOCOL_NEWHUB);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (request.HubName == "") {
+		return errors.New ("Invalid hub name");
+	}
+	
+	hubuuid := createUUID ();
+	
+	err = createNewHub (db, hubuuid, request.HubName);
+	if (err != nil) {
+		return err;
+	}
+	
+	addLogMessage (session, "Created hub " + hubuuid + ": " + request.HubName, LOGTYPE_DATA_HUBADMIN, LOGLEVEL_DBONLY);	
+	
+	// Send reply JSON	
+	var reply NetStorageNewHubReply;
+	reply.Protocol = PROTOCOL_NEWHUB;
+	reply.Version = PROTOCOL_VERSION;
+	reply.HubUUID = hubuuid;
+	reply.HubName = request.HubName;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageHubRenameHandler
+// handles a /hubs/<uuid>/rename POST request and renames a hub
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageHubRenameHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, hubuuid string) error {
+	addLogMessage (session, "Renaming hub: " + hubuuid, LOGTYPE_DATA_HUBADMIN, LOGLEVEL_CONSOLE);	
+	
+	err := checkAdministratorSession (session);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Parse JSON request
+	var request NetStorageRenameHubRequest;
+	err = parseJSONRequest (r, &request, PROTOCOL_RENAMEHUB);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (request.HubName == "") {
+		return errors.New ("Invalid hub name");
+	}
+	
+	hub, err := RetrieveHubByUUID (db, hubuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = renameHub (db, hub.UUID, request.HubName);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageRenameReply;
+	reply.Protocol = PROTOCOL_RENAMEHUB;
+	reply.Version = PROTOCOL_VERSION;
+	reply.UUID = hub.UUID;
+	reply.Name = request.HubName;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageHubDeactivateHandler
+// handles a /hubs/<uuid> DELETE request and deactivates a hub
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageHubDeactivateHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, hubuuid string) error {
+	addLogMessage (session, "Deactivating hub: " + hubuuid, LOGTYPE_DATA_HUBADMIN, LOGLEVEL_CONSOLE);	
+	
+	err := checkAdministratorSession (session);
+	if (err != nil) {
+		return err;
+	}
+	
+	hub, err := RetrieveHubByUUID (db, hubuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = deactivateHub (db, hub.UUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageDeactivateHubReply;
+	reply.Protocol = PROTOCOL_DEACTIVATEHUB;
+	reply.Version = PROTOCOL_VERSION;
+	reply.HubUUID = hub.UUID;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageHubStatisticsHandler
+// handles a /admin/hubs GET request and lists all hubs with their statistics
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageHubStatisticsHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
+	addLogMessage (session, "Retrieving hub statistics", LOGTYPE_DATA_HUBADMIN, LOGLEVEL_CONSOLE);	
+	
+	err := checkAdministratorSession (session);
+	if (err != nil) {
+		return err;
+	}
+	
+	hubs, err := RetrieveHubStatistics (db);
+	if (err == nil) {	
+		var reply NetStorageHubStatisticsReply;
+		reply.Protocol = PROTOCOL_HUBSTATISTICS;
+		reply.Version = PROTOCOL_VERSION;
+		reply.Hubs = hubs;
+		return sendJSON (w, &reply);			
+	}			
+	
+	return err;
+}
@@ -0,0 +1,386 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_integrity.go
+// Integrity scrubbing of the stored files. Every stored file is hashed again and compared with the 
+// SHA1 sum and file size of the entities that reference it. Problems are recorded as findings, which 
+// are removed again once the entity passes a later scrub or does not exist anymore.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"net/http"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"crypto/sha1"
+	"database/sql"
+)
+
+
+const INTEGRITYPROBLEM_MISSING = "missing";
+const INTEGRITYPROBLEM_READERROR = "readerror";
+const INTEGRITYPROBLEM_SIZEMISMATCH = "sizemismatch";
+const INTEGRITYPROBLEM_HASHMISMATCH = "hashmismatch";
+
+const SQL_INTEGRITYFINDINGFIELDS = "entityuuid, itemuuid, storagename, problem, expectedsha1, actualsha1, expectedsize, actualsize, detected, lastchecked";
+
+// Serializes scheduled and manually triggered scrubs
+var ScrubMutex sync.Mutex;
+
+
+// The result of hashing a stored file
+type NetStorageFileChecksum struct {
+	SHA1 string
+	FileSize int64
+	Problem string
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveIntegrityFindings
+// retrieves all current integrity findings
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveIntegrityFindings (db *sql.DB) ([]NetStorageIntegrityFinding, error) {
+	findings := make ([]NetStorageIntegrityFinding, 0);
+	
+	statement, err := db.Prepare ("SELECT " + SQL_INTEGRITYFINDINGFIELDS + " FROM netstorage_integrityfindings ORDER BY detected");
+	if (err != nil) {
+		return findings, err;
+	}
+		
+	rows, err := statement.Query();
+	if (err != nil) {
+		return findings, err;
+	}
+	
+	defer rows.Close();
+
+	for (rows.Next()) {
+		var finding NetStorageIntegrityFinding;
+		
+		err = rows.Scan (&finding.EntityUUID, &finding.ItemUUID, &finding.StorageName, &finding.Problem, &finding.ExpectedSHA1, &finding.ActualSHA1, 
+			&finding.ExpectedSize, &finding.ActualSize, &finding.Detected, &finding.LastChecked);
+		if (err != nil) {
+			return findings, err;
+		}
+		
+		findings = append (findings, finding);
+	}
+	
+	return findings, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// storeIntegrityFinding
+// records a finding. The time of the first detection is kept if the entity has been reported before.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func storeIntegrityFinding (db *sql.DB, finding NetStorageIntegrityFinding) (error) {
+
+	statement, err := db.Prepare ("INSERT INTO netstorage_integrityfindings (" + SQL_INTEGRITYFINDINGFIELDS + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+		"ON CONFLICT(entityuuid) DO UPDATE SET storagename=excluded.storagename, problem=excluded.problem, expectedsha1=excluded.expectedsha1, actualsha1=excluded.actualsha1, " +
+		"expectedsize=excluded.expectedsize, actualsize=excluded.actualsize, lastchecked=excluded.lastchecked");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(finding.EntityUUID, finding.ItemUUID, finding.StorageName, finding.Problem, finding.ExpectedSHA1, finding.ActualSHA1, 
+		finding.ExpectedSize, finding.ActualSize, finding.Detected, finding.LastChecked);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// removeIntegrityFinding
+// removes the finding of an entity that has passed the scrub
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func removeIntegrityFinding (db *sql.DB, entityuuid string) (error) {
+
+	statement, err := db.Prepare ("DELETE FROM netstorage_integrityfindings WHERE entityuuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(entityuuid);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// computeFileChecksum
+// reads a stored file and computes the SHA1 sum and size of its uncompressed content
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func computeFileChecksum (storagename string, compression string) (NetStorageFileChecksum) {
+	var checksum NetStorageFileChecksum;
+
+	exists, err := StorageBackend.Exists (storagename);
+	if (err != nil) {
+		checksum.Problem = INTEGRITYPROBLEM_READERROR;
+		return checksum;
+	}
+	
+	if (!exists) {
+		checksum.Problem = INTEGRITYPROBLEM_MISSING;
+		return checksum;
+	}
+
+	file, err := StorageBackend.Open (storagename);
+	if (err != nil) {
+		checksum.Problem = INTEGRITYPROBLEM_READERROR;
+		return checksum;
+	}
+	defer file.Close ();
+	
+	var content io.Reader = file;
+	if (compression != BLOBCOMPRESSION_NONE) {
+		decompressor, err := createDecompressor (compression, file);
+		if (err != nil) {
+			checksum.Problem = INTEGRITYPROBLEM_READERROR;
+			return checksum;
+		}
+		defer decompressor.Close ();
+		
+		content = decompressor;
+	}
+	
+	hasher := sha1.New ();
+	filesize, err := io.Copy (hasher, content);
+	if (err != nil) {
+		checksum.Problem = INTEGRITYPROBLEM_READERROR;
+		return checksum;
+	}
+	
+	checksum.SHA1 = fmt.Sprintf("%x", hasher.Sum (nil));
+	checksum.FileSize = filesize;
+	
+	return checksum;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// scrubStorage
+// verifies the stored files of all entities, including inactive ones, and updates the findings. 
+// Files that are shared by several entities are only read once.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func scrubStorage (db *sql.DB, session * NetStorageSession) (NetStorageScrubReply, error) {
+	var result NetStorageScrubReply;
+	result.Findings = make ([]NetStorageIntegrityFinding, 0);
+
+	ScrubMutex.Lock();
+	defer ScrubMutex.Unlock();
+	
+	statement, err := db.Prepare ("SELECT netstorage_entities.uuid, netstorage_entities.itemuuid, IFNULL(netstorage_entities.sha1, ''), netstorage_entities.filesize, (netstorage_blobs.sha1 IS NOT NULL), IFNULL(netstorage_blobs.compression, '') " +
+		"FROM netstorage_entities LEFT JOIN netstorage_blobs ON netstorage_blobs.sha1=netstorage_entities.sha1");
+	if (err != nil) {
+		return result, err;
+	}
+		
+	rows, err := statement.Query();
+	if (err != nil) {
+		return result, err;
+	}
+	
+	var expected []NetStorageIntegrityFinding;
+	compressions := make (map[string]string);
+	for (rows.Next()) {
+		var finding NetStorageIntegrityFinding;
+		var isblob bool;
+		var compression string;
+		
+		err = rows.Scan (&finding.EntityUUID, &finding.ItemUUID, &finding.ExpectedSHA1, &finding.ExpectedSize, &isblob, &compression);
+		if (err != nil) {
+			rows.Close();
+			return result, err;
+		}
+		
+		if (isblob) {
+			finding.StorageName = getBlobStorageName (finding.ExpectedSHA1);
+		} else {
+			finding.StorageName = getUUIDStorageName (finding.EntityUUID);
+		}
+		compressions[finding.StorageName] = compression;
+		
+		expected = append (expected, finding);
+	}
+	rows.Close();
+	
+	checksums := make (map[string]NetStorageFileChecksum);
+	
+	for _, finding := range expected {
+		checksum, found := checksums[finding.StorageName];
+		if (!found) {
+			checksum = computeFileChecksum (finding.StorageName, compressions[finding.StorageName]);
+			checksums[finding.StorageName] = checksum;
+			
+			result.CheckedFiles++;
+			result.CheckedSize = result.CheckedSize + checksum.FileSize;
+		}
+		result.CheckedEntities++;
+		
+		// entities that have been uploaded before SHA1 sums were stored can only be checked by size
+		finding.Problem = checksum.Problem;
+		finding.ActualSHA1 = checksum.SHA1;
+		finding.ActualSize = checksum.FileSize;
+		if (finding.Problem == "") {
+			if (finding.ActualSize != finding.ExpectedSize) {
+				finding.Problem = INTEGRITYPROBLEM_SIZEMISMATCH;
+			} else if ((finding.ExpectedSHA1 != "") && (finding.ActualSHA1 != finding.ExpectedSHA1)) {
+				finding.Problem = INTEGRITYPROBLEM_HASHMISMATCH;
+			}
+		}
+		
+		if (finding.Problem == "") {
+			err = removeIntegrityFinding (db, finding.EntityUUID);
+			if (err != nil) {
+				return result, err;
+			}
+			continue;
+		}
+		
+		timestamp := time.Now().Format(time.RFC3339);
+		finding.Detected = timestamp;
+		finding.LastChecked = timestamp;
+		
+		err = storeIntegrityFinding (db, finding);
+		if (err != nil) {
+			return result, err;
+		}
+		
+		addLogMessage (session, fmt.Sprintf ("Integrity problem %s of entity %s in %s", finding.Problem, finding.EntityUUID, finding.StorageName), LOGTYPE_DATA_SCRUB, LOGLEVEL_CONSOLE);	
+	}
+	
+	// findings of purged entities are obsolete
+	_, err = db.Exec ("DELETE FROM netstorage_integrityfindings WHERE entityuuid NOT IN (SELECT uuid FROM netstorage_entities)");
+	if (err != nil) {
+		return result, err;
+	}
+	
+	result.Findings, err = RetrieveIntegrityFindings (db);
+	
+	return result, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// runIntegrityScrubber
+// scrubs the storage in the configured interval
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func runIntegrityScrubber (config ConfigDefinitionScrub) {
+
+	for {
+		time.Sleep (time.Duration (config.IntervalMinutes) * time.Minute);
+		
+		session := createEmptySession ();
+		
+		db, err := OpenDB (GlobalConfig.Database.Type, GlobalConfig.Database.FileName);
+		if (err != nil) {
+			addLogMessage (&session, "Integrity scrub failed: " + err.Error (), LOGTYPE_DATA_SCRUB, LOGLEVEL_CONSOLE);	
+			continue;
+		}
+		
+		result, err := scrubStorage (db, &session);
+		db.Close ();
+		
+		if (err != nil) {
+			addLogMessage (&session, "Integrity scrub failed: " + err.Error (), LOGTYPE_DATA_SCRUB, LOGLEVEL_CONSOLE);	
+			continue;
+		}
+		
+		addLogMessage (&session, fmt.Sprintf ("Integrity scrub checked %d files of %d entities (%d bytes), %d findings", 
+			result.CheckedFiles, result.CheckedEntities, result.CheckedSize, len (result.Findings)), LOGTYPE_DATA_SCRUB, LOGLEVEL_CONSOLE);	
+	}
+	
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageScrubHandler
+// handles a /admin/scrub POST request and verifies all stored files
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageScrubHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
+	addLogMessage (session, "Scrubbing storage", LOGTYPE_DATA_SCRUB, LOGLEVEL_CONSOLE);	
+	
+	err := checkAdministratorSession (session);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Parse JSON request
+	var request NetStorageScrubRequest;
+	err = parseJSONRequest (r, &request, PROTOCOL_SCRUB);
+	if (err != nil) {
+		return err;
+	}
+	
+	result, err := scrubStorage (db, session);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	reply := result;
+	reply.Protocol = PROTOCOL_SCRUB;
+	reply.Version = PROTOCOL_VERSION;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageIntegrityFindingsHandler
+// handles a /admin/integrity GET request and lists the current integrity findings
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageIntegrityFindingsHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
+	addLogMessage (session, "Retrieving integrity findings", LOGTYPE_DATA_SCRUB, LOGLEVEL_DBONLY);	
+	
+	err := checkAdministratorSession (session);
+	if (err != nil) {
+		return err;
+	}
+	
+	findings, err := RetrieveIntegrityFindings (db);
+	if (err == nil) {	
+		var reply NetStorageIntegrityFindingsReply;
+		reply.Protocol = PROTOCOL_INTEGRITYFINDINGS;
+		reply.Version = PROTOCOL_VERSION;
+		reply.Findings = findings;
+		return sendJSON (w, &reply);			
+	}			
+	
+	return err;
+}
@@ -0,0 +1,311 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_listing.go
+// Pagination, sorting and filtering of the data listings. Listings are paged with an opaque cursor 
+// that holds the sort value and uuid of the last returned row, so that pages stay consistent while 
+// rows are added or removed. Without a limit, a listing returns all rows as before.
+//
+// Query parameters of all listing endpoints:
+//   limit     maximum number of rows of a page
+//   cursor    the cursor of the previous page
+//   sort      name, timestamp or size, depending on the listing
+//   order     asc or desc
+//   name      name prefix filter
+//   datatype  datatype filter of entity and item listings
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+
+const LISTING_MAXLIMIT = 1000;
+
+const LISTSORT_NAME = "name";
+const LISTSORT_TIMESTAMP = "timestamp";
+const LISTSORT_SIZE = "size";
+const LISTSORT_VERSION = "version";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// NetStorageListQuery
+// describes a listing: the selected fields, the tables and base condition, and the SQL expressions 
+// that can be sorted and filtered by
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type NetStorageListQuery struct {
+	Fields string
+	From string
+	Condition string
+	Arguments []interface{}
+	UUIDField string
+	SortFields map[string]string
+	DefaultSort string
+	NameField string
+	DataTypeField string
+}
+
+// The position of a listing after the last row of a page
+type NetStorageListCursor struct {
+	Sort string `json:"s"`
+	Descending bool `json:"d"`
+	Value interface{} `json:"v"`
+	UUID string `json:"u"`
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// parseListOptions
+// parses the pagination, sort and filter query parameters of a listing request
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func parseListOptions (r *http.Request) (NetStorageListOptions, error) {
+	var options NetStorageListOptions;
+
+	parameters := r.URL.Query ();
+	
+	limitparameter := parameters.Get ("limit");
+	if (limitparameter != "") {
+		limit, err := strconv.Atoi (limitparameter);
+		if ((err != nil) || (limit < 1) || (limit > LISTING_MAXLIMIT)) {
+			return options, errors.New ("Invalid listing limit: " + limitparameter);
+		}
+		options.Limit = limit;
+	}
+	
+	switch (parameters.Get ("order")) {
+		case "", "asc":
+			options.Descending = false;
+		case "desc":
+			options.Descending = true;
+		default:
+			return options, errors.New ("Invalid listing order: " + parameters.Get ("order"));
+	}
+	
+	options.Cursor = parameters.Get ("cursor");
+	options.Sort = parameters.Get ("sort");
+	options.NamePrefix = parameters.Get ("name");
+	options.DataType = parameters.Get ("datatype");
+	
+	return options, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// escapeLikePattern
+// escapes the wildcards of a LIKE pattern, using \ as escape character
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func escapeLikePattern (value string) string {
+	return strings.NewReplacer ("\\", "\\\\", "%", "\\%", "_", "\\_").Replace (value);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// encodeListCursor, decodeListCursor
+// convert list cursors from and to their opaque string form
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func encodeListCursor (cursor NetStorageListCursor) (string, error) {
+
+	// the SQLite driver returns expression values of text columns as bytes
+	bytevalue, isbytes := cursor.Value.([]byte);
+	if (isbytes) {
+		cursor.Value = string (bytevalue);
+	}
+
+	data, err := json.Marshal (&cursor);
+	if (err != nil) {
+		return "", err;
+	}
+	
+	return base64.RawURLEncoding.EncodeToString (data), nil;
+}
+
+func decodeListCursor (value string) (NetStorageListCursor, error) {
+	var cursor NetStorageListCursor;
+
+	data, err := base64.RawURLEncoding.DecodeString (value);
+	if (err == nil) {
+		err = json.Unmarshal (data, &cursor);
+	}
+	if ((err != nil) || (cursor.UUID == "")) {
+		return cursor, errors.New ("Invalid listing cursor");
+	}
+	
+	return cursor, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// NetStorageListRows
+// iterates over the rows of one page of a listing. Scan appends the sort value and uuid of the row 
+// to the scanned fields, which are remembered for the cursor of the next page.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type NetStorageListRows struct {
+	Rows *sql.Rows
+	Sort string
+	Descending bool
+	Limit int
+	Count int
+	TotalCount int
+	SortValue interface{}
+	UUID string
+	HasMore bool
+}
+
+func (list *NetStorageListRows) Next () bool {
+
+	if (!list.Rows.Next ()) {
+		return false;
+	}
+	
+	// one row more than the limit is queried to know if there is a next page
+	if ((list.Limit > 0) && (list.Count >= list.Limit)) {
+		list.HasMore = true;
+		return false;
+	}
+	
+	list.Count++;
+	return true;
+}
+
+func (list *NetStorageListRows) Scan (fields ...interface{}) error {
+	fields = append (fields, &list.SortValue, &list.UUID);
+	return list.Rows.Scan (fields...);
+}
+
+func (list *NetStorageListRows) Close () error {
+	return list.Rows.Close ();
+}
+
+func (list *NetStorageListRows) Page () (NetStorageListPage, error) {
+	var page NetStorageListPage;
+	page.TotalCount = list.TotalCount;
+	
+	err := list.Rows.Err ();
+	if (err != nil) {
+		return page, err;
+	}
+	
+	if (list.HasMore) {
+		page.Cursor, err = encodeListCursor (NetStorageListCursor { Sort: list.Sort, Descending: list.Descending, Value: list.SortValue, UUID: list.UUID });
+	}
+	
+	return page, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// openList
+// counts the rows of a listing that match the filters and queries the page after the cursor
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func openList (db *sql.DB, query NetStorageListQuery, options NetStorageListOptions) (*NetStorageListRows, error) {
+
+	sort := options.Sort;
+	if (sort == "") {
+		sort = query.DefaultSort;
+	}
+	
+	sortfield, found := query.SortFields[sort];
+	if (!found) {
+		return nil, errors.New ("Invalid sort field for this listing: " + sort);
+	}
+	
+	condition := query.Condition;
+	arguments := append ([]interface{} {}, query.Arguments...);
+	
+	if (options.NamePrefix != "") {
+		if (query.NameField == "") {
+			return nil, errors.New ("This listing cannot be filtered by name");
+		}
+		condition += " AND " + query.NameField + " LIKE ? ESCAPE '\\'";
+		arguments = append (arguments, escapeLikePattern (options.NamePrefix) + "%");
+	}
+	
+	if (options.DataType != "") {
+		if (query.DataTypeField == "") {
+			return nil, errors.New ("This listing cannot be filtered by datatype");
+		}
+		condition += " AND LOWER(" + query.DataTypeField + ")=LOWER(?)";
+		arguments = append (arguments, options.DataType);
+	}
+	
+	list := &NetStorageListRows { Sort: sort, Descending: options.Descending, Limit: options.Limit };
+	
+	err := db.QueryRow ("SELECT COUNT(*) FROM " + query.From + " WHERE " + condition, arguments...).Scan (&list.TotalCount);
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	direction := " ASC";
+	comparison := ">";
+	if (options.Descending) {
+		direction = " DESC";
+		comparison = "<";
+	}
+	
+	if (options.Cursor != "") {
+		cursor, err := decodeListCursor (options.Cursor);
+		if (err != nil) {
+			return nil, err;
+		}
+		
+		if ((cursor.Sort != sort) || (cursor.Descending != options.Descending)) {
+			return nil, errors.New ("Listing cursor does not match the sort order");
+		}
+		
+		condition += " AND (" + sortfield + comparison + "? OR (" + sortfield + "=? AND " + query.UUIDField + comparison + "?))";
+		arguments = append (arguments, cursor.Value, cursor.Value, cursor.UUID);
+	}
+	
+	statementtext := "SELECT " + query.Fields + ", " + sortfield + ", " + query.UUIDField + " FROM " + query.From + " WHERE " + condition + 
+		" ORDER BY " + sortfield + direction + ", " + query.UUIDField + direction;
+	if (options.Limit > 0) {
+		statementtext += " LIMIT ?";
+		arguments = append (arguments, options.Limit + 1);
+	}
+	
+	list.Rows, err = db.Query (statementtext, arguments...);
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	return list, nil;
+}
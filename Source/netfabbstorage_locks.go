@@ -0,0 +1,305 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_locks.go
+// Item check-out and check-in. A checked out item can only receive new entities from the user who 
+// holds the lock, until the lock is checked in, expires or is broken by an administrator.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"net/http"
+	"fmt"
+	"time"
+	"errors"
+	"database/sql"
+)
+
+
+const ITEMLOCK_DEFAULTDURATION = 8 * 3600;
+const ITEMLOCK_MAXDURATION = 30 * 24 * 3600;
+
+// The lock columns of netstorage_items, in the order makeItemLock expects them
+const SQL_ITEMLOCKFIELDS = "netstorage_items.lockuserid, netstorage_items.locknote, netstorage_items.locktimestamp, netstorage_items.lockexpiry";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// makeItemLock
+// creates the lock state of an item from its DB fields. Returns nil if the item is not locked or the 
+// lock has expired.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func makeItemLock (userid string, note string, timestamp string, expiry string) (* NetStorageItemLock) {
+
+	if (userid == "") {
+		return nil;
+	}
+	
+	expirytime, err := time.Parse (time.RFC3339, expiry);
+	if ((err != nil) || time.Now().After (expirytime)) {
+		return nil;
+	}
+	
+	var lock NetStorageItemLock;
+	lock.UserID = userid;
+	lock.Note = note;
+	lock.TimeStamp = timestamp;
+	lock.Expiry = expiry;
+	
+	return &lock;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// checkItemLock
+// checks that an item is not checked out by another user
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func checkItemLock (item NetStorageItem, userid string) (error) {
+
+	if ((item.Lock != nil) && (item.Lock.UserID != userid)) {
+		return errors.New (fmt.Sprintf ("item is checked out by %s until %s: %s", item.Lock.UserID, item.Lock.Expiry, item.UUID));
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// checkOutItem
+// locks an item for a user. Fails if the item is checked out by another user. Checking out an item 
+// again extends the lock.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func checkOutItem (db *sql.DB, itemuuid string, userid string, note string, duration int) (bool, error) {
+
+	// expiry times are stored in UTC so that they can be compared as strings
+	now := time.Now().UTC();
+	timestamp := now.Format(time.RFC3339);
+	expiry := now.Add (time.Duration (duration) * time.Second).Format(time.RFC3339);
+
+	statement, err := db.Prepare ("UPDATE netstorage_items SET lockuserid=?, locknote=?, locktimestamp=?, lockexpiry=? WHERE uuid=? AND active=1 AND (lockuserid='' OR lockuserid=? OR lockexpiry<?)");
+	if (err != nil) {
+		return false, err;
+	}
+	
+	result, err := statement.Exec(userid, note, timestamp, expiry, itemuuid, userid, timestamp);	
+	if (err != nil) {
+		return false, err;
+	}
+	
+	count, err := result.RowsAffected();
+	if ((err != nil) || (count == 0)) {
+		return false, err;
+	}
+	
+	return true, recordChange (db, CHANGEOBJECT_ITEM, itemuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// releaseItemLock
+// removes the lock of an item. If userid is not empty, only a lock of this user is removed.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func releaseItemLock (db *sql.DB, itemuuid string, userid string) (bool, error) {
+
+	condition := "";
+	args := []interface{} { itemuuid };
+	if (userid != "") {
+		condition = " AND lockuserid=?";
+		args = append (args, userid);
+	}
+
+	statement, err := db.Prepare ("UPDATE netstorage_items SET lockuserid='', locknote='', locktimestamp='', lockexpiry='' WHERE uuid=? AND lockuserid<>''" + condition);
+	if (err != nil) {
+		return false, err;
+	}
+	
+	result, err := statement.Exec(args...);	
+	if (err != nil) {
+		return false, err;
+	}
+	
+	count, err := result.RowsAffected();
+	if ((err != nil) || (count == 0)) {
+		return false, err;
+	}
+	
+	return true, recordChange (db, CHANGEOBJECT_ITEM, itemuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// sendItemLock
+// sends the lock state of an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func sendItemLock (db *sql.DB, w http.ResponseWriter, protocol string, itemuuid string) error {
+
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+
+	var reply NetStorageItemLockReply;
+	reply.Protocol = protocol;
+	reply.Version = PROTOCOL_VERSION;
+	reply.ItemUUID = item.UUID;
+	reply.Lock = item.Lock;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageItemCheckOutHandler
+// handles a /items/<uuid>/checkout POST request and locks an item for the session user
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageItemCheckOutHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Checking out item: " + itemuuid, LOGTYPE_DATA_ITEMLOCK, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageCheckOutItemRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_CHECKOUTITEM);
+	if (err != nil) {
+		return err;
+	}
+	
+	duration := request.Duration;
+	if (duration == 0) {
+		duration = ITEMLOCK_DEFAULTDURATION;
+	}
+	
+	if ((duration < 0) || (duration > ITEMLOCK_MAXDURATION)) {
+		return errors.New (fmt.Sprintf ("Invalid lock duration: %d", request.Duration));
+	}
+	
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	success, err := checkOutItem (db, item.UUID, session.UserID, request.Note, duration);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (!success) {
+		item, err = RetrieveItemByUUID (db, itemuuid);
+		if (err != nil) {
+			return err;
+		}
+	
+		err = checkItemLock (item, session.UserID);
+		if (err != nil) {
+			return err;
+		}
+		
+		return errors.New ("could not check out item: " + item.UUID);
+	}
+	
+	return sendItemLock (db, w, PROTOCOL_CHECKOUTITEM, item.UUID);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageItemCheckInHandler
+// handles a /items/<uuid>/checkin POST request and releases the lock of the session user
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageItemCheckInHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Checking in item: " + itemuuid, LOGTYPE_DATA_ITEMLOCK, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageCheckInItemRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_CHECKINITEM);
+	if (err != nil) {
+		return err;
+	}
+	
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkItemLock (item, session.UserID);
+	if (err != nil) {
+		return err;
+	}
+	
+	success, err := releaseItemLock (db, item.UUID, session.UserID);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (!success) {
+		return errors.New ("item is not checked out: " + item.UUID);
+	}
+	
+	return sendItemLock (db, w, PROTOCOL_CHECKINITEM, item.UUID);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageItemBreakLockHandler
+// handles a /items/<uuid>/breaklock POST request and removes the lock of any user
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageItemBreakLockHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Breaking lock of item: " + itemuuid, LOGTYPE_DATA_ITEMLOCK, LOGLEVEL_CONSOLE);	
+	
+	err := checkAdministratorSession (session);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Parse JSON request
+	var request NetStorageBreakItemLockRequest;
+	err = parseJSONRequest (r, &request, PROTOCOL_BREAKITEMLOCK);
+	if (err != nil) {
+		return err;
+	}
+	
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (item.Lock != nil) {
+		addLogMessage (session, fmt.Sprintf ("Breaking lock of %s on item %s", item.Lock.UserID, item.UUID), LOGTYPE_DATA_ITEMLOCK, LOGLEVEL_DBONLY);	
+	}
+	
+	_, err = releaseItemLock (db, item.UUID, "");
+	if (err != nil) {
+		return err;
+	}
+	
+	return sendItemLock (db, w, PROTOCOL_BREAKITEMLOCK, item.UUID);
+}
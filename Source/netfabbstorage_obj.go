@@ -0,0 +1,191 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_obj.go
+// Wavefront OBJ file reading and writing. Only the vertex positions and faces are used, texture 
+// coordinates, normals, groups and materials are ignored. Polygonal faces are split into triangle 
+// fans.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+
+const OBJ_HEADER = "# Written by Netfabb Application Server";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// parseOBJVertexIndex
+// parses a vertex reference of a face statement into a zero based vertex index. References may carry 
+// texture and normal indices and may be relative to the end of the vertex list.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func parseOBJVertexIndex (reference string, vertexcount int) (int, error) {
+
+	position := strings.SplitN (reference, "/", 2)[0];
+	
+	index, err := strconv.Atoi (position);
+	if (err != nil) {
+		return 0, errors.New ("invalid OBJ face vertex: " + reference);
+	}
+	
+	if (index < 0) {
+		index = vertexcount + index;
+	} else {
+		index = index - 1;
+	}
+	
+	if ((index < 0) || (index >= vertexcount)) {
+		return 0, errors.New ("OBJ face vertex out of range: " + reference);
+	}
+	
+	return index, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// readOBJTriangles
+// reads an OBJ file and calls the callback for every triangle of its faces. The vertices are held in 
+// memory, as faces may reference any vertex that has been read before.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func readOBJTriangles (file io.Reader, callback func (triangle NetStorageSTLTriangle)) (error) {
+
+	scanner := bufio.NewScanner (file);
+	scanner.Buffer (make ([]byte, 64 * 1024), 1024 * 1024);
+	
+	vertices := make ([][3]float32, 0);
+	indices := make ([]int, 0);
+	
+	for (scanner.Scan ()) {
+		fields := strings.Fields (scanner.Text ());
+		if (len (fields) == 0) {
+			continue;
+		}
+		
+		switch (fields[0]) {
+			case "v":
+				if (len (fields) < 4) {
+					return errors.New ("incomplete OBJ vertex: " + scanner.Text ());
+				}
+				
+				var vertex [3]float32;
+				for coordinate := 0; coordinate < 3; coordinate++ {
+					value, err := strconv.ParseFloat (fields[coordinate + 1], 32);
+					if (err != nil) {
+						return errors.New ("invalid OBJ vertex coordinate: " + fields[coordinate + 1]);
+					}
+					vertex[coordinate] = float32 (value);
+				}
+				if (!isFiniteVertex (vertex)) {
+					return errors.New ("invalid OBJ vertex, coordinates must be finite: " + scanner.Text ());
+				}
+				vertices = append (vertices, vertex);
+			
+			case "f":
+				if (len (fields) < 4) {
+					return errors.New ("OBJ face has less than three vertices: " + scanner.Text ());
+				}
+				
+				indices = indices[:0];
+				for _, reference := range fields[1:] {
+					index, err := parseOBJVertexIndex (reference, len (vertices));
+					if (err != nil) {
+						return err;
+					}
+					indices = append (indices, index);
+				}
+				
+				for corner := 1; corner < len (indices) - 1; corner++ {
+					callback (NetStorageSTLTriangle { vertices[indices[0]], vertices[indices[corner]], vertices[indices[corner + 1]] });
+				}
+		}
+	}
+	
+	return scanner.Err ();
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// writeOBJ
+// writes the triangles of a mesh source as OBJ file and returns the number of triangles. Equal 
+// vertices are merged and every vertex is written before the first face that uses it. Triangles 
+// that collapse to an edge or a point are left out.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func writeOBJ (file io.Writer, source NetStorageTriangleSource) (int64, error) {
+
+	writer := bufio.NewWriterSize (file, 1024 * 1024);
+	formatvalue := func (value float32) string {
+		return strconv.FormatFloat (float64 (value), 'g', -1, 32);
+	};
+	
+	fmt.Fprintf (writer, "%s\n", OBJ_HEADER);
+	
+	vertexindices := make (map[[3]float32]int64);
+	var count int64;
+	
+	err := source (func (triangle NetStorageSTLTriangle) {
+		var face [3]int64;
+		for vertex := 0; vertex < 3; vertex++ {
+			index, exists := vertexindices[triangle[vertex]];
+			if (!exists) {
+				index = int64 (len (vertexindices)) + 1;
+				vertexindices[triangle[vertex]] = index;
+				fmt.Fprintf (writer, "v %s %s %s\n", formatvalue (triangle[vertex][0]), formatvalue (triangle[vertex][1]), formatvalue (triangle[vertex][2]));
+			}
+			face[vertex] = index;
+		}
+		
+		if ((face[0] == face[1]) || (face[1] == face[2]) || (face[2] == face[0])) {
+			return;
+		}
+		
+		fmt.Fprintf (writer, "f %d %d %d\n", face[0], face[1], face[2]);
+		count++;
+	});
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	// write errors are sticky and reported by the flush
+	err = writer.Flush ();
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	return count, nil;
+}
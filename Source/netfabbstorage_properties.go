@@ -0,0 +1,629 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_properties.go
+// User defined tags and typed properties of items. Tags are case insensitive labels, properties are 
+// named string, number or boolean values. Both can be used to list the items of a whole project, 
+// independent of their folders.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+
+const ITEMPROPERTYTYPE_STRING = "string";
+const ITEMPROPERTYTYPE_NUMBER = "number";
+const ITEMPROPERTYTYPE_BOOLEAN = "boolean";
+
+const ITEMTAG_MAXLENGTH = 64;
+const ITEMPROPERTY_MAXNAMELENGTH = 64;
+const ITEMPROPERTY_MAXVALUELENGTH = 1024;
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// checkItemTag, checkItemPropertyName
+// trim and validate a tag or property name
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func checkItemTag (tag string) (string, error) {
+
+	tag = strings.TrimSpace (tag);
+	if ((tag == "") || (len (tag) > ITEMTAG_MAXLENGTH)) {
+		return "", errors.New ("Invalid item tag: " + tag);
+	}
+	
+	return tag, nil;
+}
+
+func checkItemPropertyName (name string) (string, error) {
+
+	name = strings.TrimSpace (name);
+	if ((name == "") || (len (name) > ITEMPROPERTY_MAXNAMELENGTH)) {
+		return "", errors.New ("Invalid item property name: " + name);
+	}
+	
+	return name, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// encodeItemPropertyValue
+// converts the value of a property into its stored text form. If the property has no type, it is 
+// derived from the JSON value.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func encodeItemPropertyValue (property NetStorageItemProperty) (string, string, error) {
+
+	propertytype := property.Type;
+	if (propertytype == "") {
+		switch property.Value.(type) {
+			case float64:
+				propertytype = ITEMPROPERTYTYPE_NUMBER;
+			case bool:
+				propertytype = ITEMPROPERTYTYPE_BOOLEAN;
+			default:
+				propertytype = ITEMPROPERTYTYPE_STRING;
+		}
+	}
+	
+	invalid := errors.New (fmt.Sprintf ("Invalid %s value of item property %s: %v", propertytype, property.Name, property.Value));
+
+	switch (propertytype) {
+		case ITEMPROPERTYTYPE_STRING:
+			value, isstring := property.Value.(string);
+			if ((!isstring) || (len (value) > ITEMPROPERTY_MAXVALUELENGTH)) {
+				return "", "", invalid;
+			}
+			return propertytype, value, nil;
+		
+		case ITEMPROPERTYTYPE_NUMBER:
+			value, isnumber := property.Value.(float64);
+			if (!isnumber) {
+				text, isstring := property.Value.(string);
+				if (!isstring) {
+					return "", "", invalid;
+				}
+				
+				var err error;
+				value, err = strconv.ParseFloat (strings.TrimSpace (text), 64);
+				if (err != nil) {
+					return "", "", invalid;
+				}
+			}
+			
+			// NaN and Inf cannot be sent back as JSON
+			if (math.IsNaN (value) || math.IsInf (value, 0)) {
+				return "", "", invalid;
+			}
+			return propertytype, strconv.FormatFloat (value, 'g', -1, 64), nil;
+		
+		case ITEMPROPERTYTYPE_BOOLEAN:
+			value, isbool := property.Value.(bool);
+			if (!isbool) {
+				text, isstring := property.Value.(string);
+				if (!isstring) {
+					return "", "", invalid;
+				}
+				
+				var err error;
+				value, err = strconv.ParseBool (strings.TrimSpace (text));
+				if (err != nil) {
+					return "", "", invalid;
+				}
+			}
+			return propertytype, strconv.FormatBool (value), nil;
+	}
+	
+	return "", "", errors.New ("Invalid item property type: " + propertytype);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// decodeItemPropertyValue
+// converts a stored property value back into its JSON value
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func decodeItemPropertyValue (propertytype string, text string) interface{} {
+
+	switch (propertytype) {
+		case ITEMPROPERTYTYPE_NUMBER:
+			// values stored before non-finite numbers were rejected are returned as text
+			value, err := strconv.ParseFloat (text, 64);
+			if ((err == nil) && !math.IsNaN (value) && !math.IsInf (value, 0)) {
+				return value;
+			}
+		
+		case ITEMPROPERTYTYPE_BOOLEAN:
+			value, err := strconv.ParseBool (text);
+			if (err == nil) {
+				return value;
+			}
+	}
+	
+	return text;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveItemTags
+// retrieves the tags of an item in alphabetical order
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveItemTags (db *sql.DB, itemuuid string) ([]string, error) {
+	tags := make ([]string, 0);
+
+	statement, err := db.Prepare ("SELECT tag FROM netstorage_itemtags WHERE itemuuid=? ORDER BY tag");
+	if (err != nil) {
+		return tags, err;
+	}
+	
+	defer statement.Close ();
+
+	rows, err := statement.Query (itemuuid);
+	if (err != nil) {
+		return tags, err;
+	}
+	
+	defer rows.Close ();
+	
+	for (rows.Next()) {
+		tag := "";
+		err = rows.Scan (&tag);
+		if (err != nil) {
+			return tags, err;
+		}
+		
+		tags = append (tags, tag);
+	}
+	
+	return tags, rows.Err ();
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveItemProperties
+// retrieves the properties of an item in alphabetical order
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveItemProperties (db *sql.DB, itemuuid string) ([]NetStorageItemProperty, error) {
+	properties := make ([]NetStorageItemProperty, 0);
+
+	statement, err := db.Prepare ("SELECT name, type, value FROM netstorage_itemproperties WHERE itemuuid=? ORDER BY name");
+	if (err != nil) {
+		return properties, err;
+	}
+	
+	defer statement.Close ();
+
+	rows, err := statement.Query (itemuuid);
+	if (err != nil) {
+		return properties, err;
+	}
+	
+	defer rows.Close ();
+	
+	for (rows.Next()) {
+		var property NetStorageItemProperty;
+		value := "";
+		
+		err = rows.Scan (&property.Name, &property.Type, &value);
+		if (err != nil) {
+			return properties, err;
+		}
+		
+		property.Value = decodeItemPropertyValue (property.Type, value);
+		properties = append (properties, property);
+	}
+	
+	return properties, rows.Err ();
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// setItemTags
+// adds and removes tags of an item and updates its search index entry. Adding an existing tag or 
+// removing a missing one is not an error.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func setItemTags (db *sql.DB, itemuuid string, addtags []string, removetags []string) (error) {
+
+	deletestatement, err := db.Prepare ("DELETE FROM netstorage_itemtags WHERE itemuuid=? AND tag=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	defer deletestatement.Close ();
+
+	for _, tag := range removetags {
+		tag, err := checkItemTag (tag);
+		if (err != nil) {
+			return err;
+		}
+		
+		_, err = deletestatement.Exec (itemuuid, tag);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	insertstatement, err := db.Prepare ("INSERT OR IGNORE INTO netstorage_itemtags (itemuuid, tag) VALUES (?, ?)");
+	if (err != nil) {
+		return err;
+	}
+	
+	defer insertstatement.Close ();
+	
+	for _, tag := range addtags {
+		tag, err := checkItemTag (tag);
+		if (err != nil) {
+			return err;
+		}
+		
+		_, err = insertstatement.Exec (itemuuid, tag);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	err = indexSearchItem (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_ITEM, itemuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// setItemProperties
+// sets and removes properties of an item and updates its search index entry. Setting an existing 
+// property replaces its type and value.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func setItemProperties (db *sql.DB, itemuuid string, properties []NetStorageItemProperty, removeproperties []string) (error) {
+
+	deletestatement, err := db.Prepare ("DELETE FROM netstorage_itemproperties WHERE itemuuid=? AND name=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	defer deletestatement.Close ();
+
+	for _, name := range removeproperties {
+		name, err := checkItemPropertyName (name);
+		if (err != nil) {
+			return err;
+		}
+		
+		_, err = deletestatement.Exec (itemuuid, name);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	insertstatement, err := db.Prepare ("INSERT OR REPLACE INTO netstorage_itemproperties (itemuuid, name, type, value) VALUES (?, ?, ?, ?)");
+	if (err != nil) {
+		return err;
+	}
+	
+	defer insertstatement.Close ();
+	
+	for _, property := range properties {
+		name, err := checkItemPropertyName (property.Name);
+		if (err != nil) {
+			return err;
+		}
+		
+		propertytype, value, err := encodeItemPropertyValue (property);
+		if (err != nil) {
+			return err;
+		}
+		
+		_, err = insertstatement.Exec (itemuuid, name, propertytype, value);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	err = indexSearchItem (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_ITEM, itemuuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveItemsOfProject
+// retrieves the active items of all folders of a project that carry all given tags and match all 
+// given property filters. A property filter is either a name, which matches all items that have the 
+// property, or name=value.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveItemsOfProject (db *sql.DB, projectuuid string, tags []string, propertyfilters []string, options NetStorageListOptions) ([]NetStorageItem, NetStorageListPage, error) {
+	entries := make([] NetStorageItem, 0);
+	var page NetStorageListPage;
+	
+	condition := "netstorage_folders.projectuuid=? AND netstorage_folders.active=1 AND netstorage_items.active=1";
+	arguments := []interface{} { projectuuid };
+	
+	for _, tag := range tags {
+		tag, err := checkItemTag (tag);
+		if (err != nil) {
+			return entries, page, err;
+		}
+		
+		condition += " AND EXISTS (SELECT 1 FROM netstorage_itemtags WHERE itemuuid=netstorage_items.uuid AND tag=?)";
+		arguments = append (arguments, tag);
+	}
+	
+	for _, filter := range propertyfilters {
+		parts := strings.SplitN (filter, "=", 2);
+		name, err := checkItemPropertyName (parts[0]);
+		if (err != nil) {
+			return entries, page, err;
+		}
+		
+		propertycondition := "name=?";
+		arguments = append (arguments, name);
+		
+		if (len (parts) == 2) {
+			value := parts[1];
+			
+			// numbers are compared by value, so that 2.50 matches a stored 2.5
+			number, err := strconv.ParseFloat (strings.TrimSpace (value), 64);
+			if (err == nil) {
+				propertycondition += " AND (value=? OR (type='" + ITEMPROPERTYTYPE_NUMBER + "' AND CAST(value AS REAL)=?))";
+				arguments = append (arguments, value, number);
+			} else {
+				propertycondition += " AND value=?";
+				arguments = append (arguments, value);
+			}
+		}
+		
+		condition += " AND EXISTS (SELECT 1 FROM netstorage_itemproperties WHERE itemuuid=netstorage_items.uuid AND " + propertycondition + ")";
+	}
+	
+	query := NetStorageListQuery {
+		Fields: "netstorage_items.uuid, netstorage_items.folderuuid, netstorage_folders.projectuuid, netstorage_items.itemname, netstorage_items.active, " + SQL_ITEMLOCKFIELDS,
+		From: "netstorage_items JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid",
+		Condition: condition,
+		Arguments: arguments,
+		UUIDField: "netstorage_items.uuid",
+		SortFields: map[string]string { 
+			LISTSORT_NAME: "netstorage_items.itemname",
+			LISTSORT_TIMESTAMP: "IFNULL((SELECT timestamp FROM netstorage_entities WHERE uuid=" + SQL_CURRENTENTITY + "), '')",
+			LISTSORT_SIZE: "IFNULL((SELECT filesize FROM netstorage_entities WHERE uuid=" + SQL_CURRENTENTITY + "), 0)",
+		},
+		DefaultSort: LISTSORT_NAME,
+		NameField: "netstorage_items.itemname",
+		DataTypeField: "(SELECT datatype FROM netstorage_entities WHERE uuid=" + SQL_CURRENTENTITY + ")",
+	};
+	
+	rows, err := openList (db, query, options);
+	if (err != nil) {
+		return entries, page, err;
+	}
+
+	for (rows.Next()) {
+		var entry NetStorageItem;
+		var lockuserid, locknote, locktimestamp, lockexpiry string;
+	
+		err = rows.Scan (&entry.UUID, &entry.FolderUUID, &entry.ProjectUUID, &entry.Name, &entry.Active, &lockuserid, &locknote, &locktimestamp, &lockexpiry);
+		if (err != nil) {
+			rows.Close ();		
+			return entries, page, err;
+		}
+		
+		entry.Lock = makeItemLock (lockuserid, locknote, locktimestamp, lockexpiry);
+								
+		entries = append (entries, entry);
+	}
+	
+	rows.Close ();		
+	
+	page, err = rows.Page ();
+	return entries, page, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// sendItemProperties
+// sends the tags and properties of an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func sendItemProperties (db *sql.DB, w http.ResponseWriter, protocol string, itemuuid string) error {
+
+	tags, err := RetrieveItemTags (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	properties, err := RetrieveItemProperties (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+
+	var reply NetStorageItemPropertiesReply;
+	reply.Protocol = protocol;
+	reply.Version = PROTOCOL_VERSION;
+	reply.ItemUUID = itemuuid;
+	reply.Tags = tags;
+	reply.Properties = properties;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageItemPropertiesHandler
+// handles a /items/<uuid>/properties GET request and retrieves the tags and properties of an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageItemPropertiesHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Retrieving properties of item: " + itemuuid, LOGTYPE_DATA_PROPERTIES, LOGLEVEL_CONSOLE);	
+	
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	return sendItemProperties (db, w, PROTOCOL_ITEMPROPERTIES, item.UUID);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageSetItemTagsHandler
+// handles a /items/<uuid>/tags POST request and adds and removes tags of an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageSetItemTagsHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Setting tags of item: " + itemuuid, LOGTYPE_DATA_PROPERTIES, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageSetItemTagsRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_SETITEMTAGS);
+	if (err != nil) {
+		return err;
+	}
+	
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkItemLock (item, session.UserID);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = setItemTags (db, item.UUID, request.AddTags, request.RemoveTags);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = CommittTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	return sendItemProperties (db, w, PROTOCOL_SETITEMTAGS, item.UUID);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageSetItemPropertiesHandler
+// handles a /items/<uuid>/properties POST request and sets and removes properties of an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageSetItemPropertiesHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Setting properties of item: " + itemuuid, LOGTYPE_DATA_PROPERTIES, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageSetItemPropertiesRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_SETITEMPROPERTIES);
+	if (err != nil) {
+		return err;
+	}
+	
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkItemLock (item, session.UserID);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = setItemProperties (db, item.UUID, request.Properties, request.RemoveProperties);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = CommittTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	return sendItemProperties (db, w, PROTOCOL_SETITEMPROPERTIES, item.UUID);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageProjectItemsHandler
+// handles a /projects/<uuid>/items GET request and lists the items of a project by tag and property. 
+// The query parameter tag=<tag> can be repeated, all tags need to match. The same applies to 
+// property=<name> and property=<name>=<value>. The listing parameters are the same as for the items 
+// of a folder.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageProjectItemsHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, projectuuid string) error {
+	addLogMessage (session, "Retrieving Items for Project: " + projectuuid, LOGTYPE_DATA_PROPERTIES, LOGLEVEL_CONSOLE);	
+
+	options, err := parseListOptions (r);
+	if (err != nil) {
+		return err;
+	}
+	
+	parameters := r.URL.Query ();
+
+	items, page, err := RetrieveItemsOfProject (db, projectuuid, parameters["tag"], parameters["property"], options);
+	if (err == nil) {	
+		var reply NetStorageItemsReply;
+		reply.Protocol = PROTOCOL_PROJECTITEMS;
+		reply.Version = PROTOCOL_VERSION;
+		reply.NetStorageListPage = page;
+		reply.Items = items;		
+		return sendJSON (w, &reply);			
+	}			
+	
+	return err;
+}
@@ -0,0 +1,327 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_quotas.go
+// Storage quotas of hubs and projects. The usage is the size and number of all entities of a hub or 
+// project, including inactive entities that still occupy storage until they are purged. The default 
+// limits are configured in the quotas section, administrators can override them per hub or project.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"net/http"
+	"fmt"
+	"errors"
+	"database/sql"
+)
+
+
+const QUOTATYPE_HUB = "hub";
+const QUOTATYPE_PROJECT = "project";
+
+// Joins the entities with the projects they belong to. Derived entities are generated by the server 
+// and do not count against quotas.
+const SQL_QUOTAENTITIES = "FROM netstorage_entities " +
+	"JOIN netstorage_items ON netstorage_items.uuid=netstorage_entities.itemuuid " +
+	"JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid " +
+	"JOIN netstorage_projects ON netstorage_projects.uuid=netstorage_folders.projectuuid ";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveQuotaUsage
+// retrieves the limits and the current usage of a hub or project
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveQuotaUsage (db *sql.DB, objecttype string, objectuuid string, name string) (NetStorageQuotaUsage, error) {
+	var usage NetStorageQuotaUsage;
+	usage.ObjectType = objecttype;
+	usage.UUID = objectuuid;
+	usage.Name = name;
+	
+	var condition string;
+	switch (objecttype) {
+		case QUOTATYPE_HUB:
+			usage.MaxBytes = GlobalConfig.Quotas.HubBytes;
+			usage.MaxEntities = GlobalConfig.Quotas.HubEntities;
+			condition = "WHERE netstorage_entities.sourceentityuuid='' AND netstorage_projects.hubuuid=?";
+		case QUOTATYPE_PROJECT:
+			usage.MaxBytes = GlobalConfig.Quotas.ProjectBytes;
+			usage.MaxEntities = GlobalConfig.Quotas.ProjectEntities;
+			condition = "WHERE netstorage_entities.sourceentityuuid='' AND netstorage_projects.uuid=?";
+		default:
+			return usage, errors.New ("invalid quota type: " + objecttype);
+	}
+	usage.IsDefault = true;
+	
+	statement, err := db.Prepare ("SELECT maxbytes, maxentities FROM netstorage_quotas WHERE objecttype=? AND objectuuid=?");
+	if (err != nil) {
+		return usage, err;
+	}
+		
+	rows, err := statement.Query(objecttype, objectuuid);
+	if (err != nil) {
+		return usage, err;
+	}
+	
+	if (rows.Next()) {
+		err = rows.Scan (&usage.MaxBytes, &usage.MaxEntities);
+		if (err != nil) {
+			rows.Close();
+			return usage, err;
+		}
+		usage.IsDefault = false;
+	}
+	rows.Close();
+	
+	statement, err = db.Prepare ("SELECT COUNT(*), IFNULL(SUM(netstorage_entities.filesize), 0) " + SQL_QUOTAENTITIES + condition);
+	if (err != nil) {
+		return usage, err;
+	}
+		
+	rows, err = statement.Query(objectuuid);
+	if (err != nil) {
+		return usage, err;
+	}
+	
+	defer rows.Close();
+
+	if (rows.Next()) {
+		err = rows.Scan (&usage.UsedEntities, &usage.UsedBytes);
+	}
+	
+	return usage, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// setQuota
+// overrides the default limits of a hub or project. A limit of 0 means unlimited.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func setQuota (db *sql.DB, objecttype string, objectuuid string, maxbytes int64, maxentities int64) (error) {
+
+	statement, err := db.Prepare ("INSERT OR REPLACE INTO netstorage_quotas (objecttype, objectuuid, maxbytes, maxentities) VALUES (?, ?, ?, ?)");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(objecttype, objectuuid, maxbytes, maxentities);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// resetQuota
+// removes the limits of a hub or project, so that the configured defaults apply again
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func resetQuota (db *sql.DB, objecttype string, objectuuid string) (error) {
+
+	statement, err := db.Prepare ("DELETE FROM netstorage_quotas WHERE objecttype=? AND objectuuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(objecttype, objectuuid);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// checkQuotaUsage
+// checks that one more entity of the given size fits into the limits of a quota
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func checkQuotaUsage (usage NetStorageQuotaUsage, filesize int64) (error) {
+
+	if ((usage.MaxEntities > 0) && (usage.UsedEntities + 1 > usage.MaxEntities)) {
+		return errors.New (fmt.Sprintf ("quota exceeded: %s %s already has %d of %d entities", usage.ObjectType, usage.UUID, usage.UsedEntities, usage.MaxEntities));
+	}
+	
+	if ((usage.MaxBytes > 0) && (usage.UsedBytes + filesize > usage.MaxBytes)) {
+		return errors.New (fmt.Sprintf ("quota exceeded: %s %s uses %d of %d bytes, upload of %d bytes does not fit", usage.ObjectType, usage.UUID, usage.UsedBytes, usage.MaxBytes, filesize));
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// checkQuotas
+// checks that a new entity of the given size fits into the quotas of the project and the hub of an 
+// item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func checkQuotas (db *sql.DB, item NetStorageItem, filesize int64) (error) {
+
+	project, err := RetrieveProjectByUUID (db, item.ProjectUUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	usage, err := RetrieveQuotaUsage (db, QUOTATYPE_PROJECT, project.UUID, project.Name);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkQuotaUsage (usage, filesize);
+	if (err != nil) {
+		return err;
+	}
+	
+	usage, err = RetrieveQuotaUsage (db, QUOTATYPE_HUB, project.HubUUID, "");
+	if (err != nil) {
+		return err;
+	}
+	
+	return checkQuotaUsage (usage, filesize);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// retrieveQuotaObject
+// retrieves the name of a hub or project and checks that it exists
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func retrieveQuotaObject (db *sql.DB, objecttype string, objectuuid string) (string, error) {
+
+	if (objecttype == QUOTATYPE_HUB) {
+		hub, err := RetrieveHubByUUID (db, objectuuid);
+		return hub.Name, err;
+	}
+	
+	project, err := RetrieveProjectByUUID (db, objectuuid);
+	return project.Name, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// sendQuotaUsage
+// sends the usage of a hub or project. The usage of a hub includes the usage of each of its projects.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func sendQuotaUsage (db *sql.DB, w http.ResponseWriter, protocol string, objecttype string, objectuuid string, name string) error {
+
+	usage, err := RetrieveQuotaUsage (db, objecttype, objectuuid, name);
+	if (err != nil) {
+		return err;
+	}
+	
+	projectusages := make ([]NetStorageQuotaUsage, 0);
+	if (objecttype == QUOTATYPE_HUB) {
+		projects, _, err := RetrieveProjects (db, objectuuid, NetStorageListOptions {});
+		if (err != nil) {
+			return err;
+		}
+		
+		for _, project := range projects {
+			projectusage, err := RetrieveQuotaUsage (db, QUOTATYPE_PROJECT, project.UUID, project.Name);
+			if (err != nil) {
+				return err;
+			}
+			
+			projectusages = append (projectusages, projectusage);
+		}
+	}
+
+	var reply NetStorageUsageReply;
+	reply.Protocol = protocol;
+	reply.Version = PROTOCOL_VERSION;
+	reply.Usage = usage;
+	reply.Projects = projectusages;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageUsageHandler
+// handles a /hubs/<uuid>/usage or /projects/<uuid>/usage GET request and reports the usage of a hub 
+// or project versus its limits
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageUsageHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, objecttype string, objectuuid string) error {
+	addLogMessage (session, "Retrieving usage of " + objecttype + ": " + objectuuid, LOGTYPE_DATA_QUOTA, LOGLEVEL_CONSOLE);	
+	
+	name, err := retrieveQuotaObject (db, objecttype, objectuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	return sendQuotaUsage (db, w, PROTOCOL_USAGE, objecttype, objectuuid, name);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageQuotaSetHandler
+// handles a /hubs/<uuid>/quota or /projects/<uuid>/quota POST request and sets the limits of a hub 
+// or project
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageQuotaSetHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, objecttype string, objectuuid string) error {
+	addLogMessage (session, "Setting quota of " + objecttype + ": " + objectuuid, LOGTYPE_DATA_QUOTA, LOGLEVEL_CONSOLE);	
+	
+	err := checkAdministratorSession (session);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Parse JSON request
+	var request NetStorageSetQuotaRequest;
+	err = parseJSONRequest (r, &request, PROTOCOL_SETQUOTA);
+	if (err != nil) {
+		return err;
+	}
+	
+	if ((request.MaxBytes < 0) || (request.MaxEntities < 0)) {
+		return errors.New ("Invalid quota limits");
+	}
+	
+	name, err := retrieveQuotaObject (db, objecttype, objectuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (request.Reset) {
+		err = resetQuota (db, objecttype, objectuuid);
+		if (err != nil) {
+			return err;
+		}
+		
+		addLogMessage (session, "Reset quota of " + objecttype + " " + objectuuid + " to default", LOGTYPE_DATA_QUOTA, LOGLEVEL_DBONLY);	
+	} else {
+		err = setQuota (db, objecttype, objectuuid, request.MaxBytes, request.MaxEntities);
+		if (err != nil) {
+			return err;
+		}
+		
+		addLogMessage (session, fmt.Sprintf ("Set quota of %s %s to %d bytes and %d entities", objecttype, objectuuid, request.MaxBytes, request.MaxEntities), LOGTYPE_DATA_QUOTA, LOGLEVEL_DBONLY);	
+	}
+	
+	return sendQuotaUsage (db, w, PROTOCOL_SETQUOTA, objecttype, objectuuid, name);
+}
@@ -0,0 +1,297 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_render.go
+// Software rendering of mesh thumbnails. Meshes are drawn in an isometric view with a depth buffer 
+// and simple two sided diffuse lighting, supersampled and scaled down to the thumbnail size. The 
+// triangles are streamed twice: once to find the bounding box and once to draw them.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+
+const RENDER_SUPERSAMPLING = 2;
+const RENDER_MARGIN = 0.05;
+const RENDER_AMBIENT = 0.25;
+const RENDER_DIFFUSE = 0.75;
+
+// The model color, the background stays transparent
+var RenderModelColor = [3]float64 { 110, 170, 230 };
+
+
+// Streams the triangles of a mesh into a callback
+type NetStorageTriangleSource func (callback func (triangle NetStorageSTLTriangle)) error
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// NetStorageRenderView
+// an orthographic camera looking at the model from the front right top corner
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type NetStorageRenderView struct {
+	Right [3]float64
+	Up [3]float64
+	Forward [3]float64
+	Light [3]float64
+	Center [3]float64
+	Scale float64
+	Size int
+}
+
+func normalizeVector (vector [3]float64) ([3]float64) {
+	length := math.Sqrt (vector[0] * vector[0] + vector[1] * vector[1] + vector[2] * vector[2]);
+	if (length == 0) {
+		return vector;
+	}
+	return [3]float64 { vector[0] / length, vector[1] / length, vector[2] / length };
+}
+
+func dotVectors (a [3]float64, b [3]float64) float64 {
+	return a[0] * b[0] + a[1] * b[1] + a[2] * b[2];
+}
+
+func createRenderView (boundingbox NetStorageBoundingBox, size int) (NetStorageRenderView) {
+	var view NetStorageRenderView;
+	view.Right = normalizeVector ([3]float64 { 1, 1, 0 });
+	view.Up = normalizeVector ([3]float64 { -1, 1, 2 });
+	view.Forward = normalizeVector ([3]float64 { -1, 1, -1 });
+	view.Light = normalizeVector ([3]float64 { 0.5, -1, 1.5 });
+	view.Size = size;
+	
+	for coordinate := 0; coordinate < 3; coordinate++ {
+		view.Center[coordinate] = (boundingbox.Min[coordinate] + boundingbox.Max[coordinate]) / 2;
+	}
+	
+	// fit the projected bounding box corners into the image
+	extent := 0.0;
+	for corner := 0; corner < 8; corner++ {
+		var point [3]float64;
+		for coordinate := 0; coordinate < 3; coordinate++ {
+			if ((corner >> uint (coordinate)) & 1 == 0) {
+				point[coordinate] = boundingbox.Min[coordinate] - view.Center[coordinate];
+			} else {
+				point[coordinate] = boundingbox.Max[coordinate] - view.Center[coordinate];
+			}
+		}
+		extent = math.Max (extent, math.Abs (dotVectors (point, view.Right)));
+		extent = math.Max (extent, math.Abs (dotVectors (point, view.Up)));
+	}
+	
+	if (extent > 0) {
+		view.Scale = float64 (size) * (0.5 - RENDER_MARGIN) / extent;
+	}
+	
+	return view;
+}
+
+// projects a point to image coordinates and depth
+func (view *NetStorageRenderView) project (vertex [3]float32) ([3]float64) {
+	point := [3]float64 { float64 (vertex[0]) - view.Center[0], float64 (vertex[1]) - view.Center[1], float64 (vertex[2]) - view.Center[2] };
+	return [3]float64 {
+		float64 (view.Size) / 2 + dotVectors (point, view.Right) * view.Scale,
+		float64 (view.Size) / 2 - dotVectors (point, view.Up) * view.Scale,
+		dotVectors (point, view.Forward),
+	};
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// rasterizeTriangle
+// draws a shaded triangle into the color and depth buffers
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func rasterizeTriangle (view * NetStorageRenderView, triangle NetStorageSTLTriangle, colors []float64, depths []float64) {
+
+	var points [3][3]float64;
+	var vertices [3][3]float64;
+	for vertex := 0; vertex < 3; vertex++ {
+		points[vertex] = view.project (triangle[vertex]);
+		vertices[vertex] = [3]float64 { float64 (triangle[vertex][0]), float64 (triangle[vertex][1]), float64 (triangle[vertex][2]) };
+		
+		// non-finite coordinates would turn the pixel bounds into invalid buffer indices
+		for coordinate := 0; coordinate < 3; coordinate++ {
+			if (math.IsNaN (points[vertex][coordinate]) || math.IsInf (points[vertex][coordinate], 0)) {
+				return;
+			}
+		}
+	}
+	
+	// flat shading with the face normal, lit from both sides as STL orientation is unreliable
+	a := [3]float64 { vertices[1][0] - vertices[0][0], vertices[1][1] - vertices[0][1], vertices[1][2] - vertices[0][2] };
+	b := [3]float64 { vertices[2][0] - vertices[0][0], vertices[2][1] - vertices[0][1], vertices[2][2] - vertices[0][2] };
+	normal := normalizeVector ([3]float64 { a[1] * b[2] - a[2] * b[1], a[2] * b[0] - a[0] * b[2], a[0] * b[1] - a[1] * b[0] });
+	intensity := RENDER_AMBIENT + RENDER_DIFFUSE * math.Abs (dotVectors (normal, view.Light));
+	
+	area := (points[1][0] - points[0][0]) * (points[2][1] - points[0][1]) - (points[2][0] - points[0][0]) * (points[1][1] - points[0][1]);
+	if (area == 0) {
+		return;
+	}
+	
+	minx := int (math.Max (0, math.Floor (math.Min (points[0][0], math.Min (points[1][0], points[2][0])))));
+	maxx := int (math.Min (float64 (view.Size - 1), math.Ceil (math.Max (points[0][0], math.Max (points[1][0], points[2][0])))));
+	miny := int (math.Max (0, math.Floor (math.Min (points[0][1], math.Min (points[1][1], points[2][1])))));
+	maxy := int (math.Min (float64 (view.Size - 1), math.Ceil (math.Max (points[0][1], math.Max (points[1][1], points[2][1])))));
+	
+	for y := miny; y <= maxy; y++ {
+		for x := minx; x <= maxx; x++ {
+			px := float64 (x) + 0.5;
+			py := float64 (y) + 0.5;
+			
+			// barycentric coordinates, independent of the triangle orientation
+			w0 := ((points[1][0] - px) * (points[2][1] - py) - (points[2][0] - px) * (points[1][1] - py)) / area;
+			w1 := ((points[2][0] - px) * (points[0][1] - py) - (points[0][0] - px) * (points[2][1] - py)) / area;
+			w2 := 1 - w0 - w1;
+			if ((w0 < 0) || (w1 < 0) || (w2 < 0)) {
+				continue;
+			}
+			
+			depth := w0 * points[0][2] + w1 * points[1][2] + w2 * points[2][2];
+			index := y * view.Size + x;
+			if (depth >= depths[index]) {
+				continue;
+			}
+			
+			depths[index] = depth;
+			colors[index] = intensity;
+		}
+	}
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// renderMeshThumbnail
+// renders the triangles of a mesh into a square PNG image of the given size
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func renderMeshThumbnail (source NetStorageTriangleSource, size int) ([]byte, error) {
+
+	if (size <= 0) {
+		return nil, errors.New ("invalid thumbnail size");
+	}
+
+	var boundingbox NetStorageBoundingBox;
+	count := 0;
+	err := source (func (triangle NetStorageSTLTriangle) {
+		for vertex := 0; vertex < 3; vertex++ {
+			for coordinate := 0; coordinate < 3; coordinate++ {
+				value := float64 (triangle[vertex][coordinate]);
+				if ((count == 0) && (vertex == 0)) {
+					boundingbox.Min[coordinate] = value;
+					boundingbox.Max[coordinate] = value;
+				} else {
+					boundingbox.Min[coordinate] = math.Min (boundingbox.Min[coordinate], value);
+					boundingbox.Max[coordinate] = math.Max (boundingbox.Max[coordinate], value);
+				}
+			}
+		}
+		count++;
+	});
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	if (count == 0) {
+		return nil, errors.New ("mesh has no triangles");
+	}
+	
+	for coordinate := 0; coordinate < 3; coordinate++ {
+		for _, value := range []float64 { boundingbox.Min[coordinate], boundingbox.Max[coordinate] } {
+			if (math.IsNaN (value) || math.IsInf (value, 0)) {
+				return nil, errors.New ("mesh has non-finite vertex coordinates");
+			}
+		}
+	}
+	
+	renderview := createRenderView (boundingbox, size * RENDER_SUPERSAMPLING);
+	if (math.IsNaN (renderview.Scale) || math.IsInf (renderview.Scale, 0)) {
+		return nil, errors.New ("mesh extent cannot be rendered");
+	}
+	
+	// negative intensities mark the background
+	pixelcount := renderview.Size * renderview.Size;
+	colors := make ([]float64, pixelcount);
+	depths := make ([]float64, pixelcount);
+	for index := 0; index < pixelcount; index++ {
+		colors[index] = -1;
+		depths[index] = math.Inf (1);
+	}
+	
+	err = source (func (triangle NetStorageSTLTriangle) {
+		rasterizeTriangle (&renderview, triangle, colors, depths);
+	});
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	// average the supersamples, the coverage becomes the alpha value
+	thumbnail := image.NewNRGBA (image.Rect (0, 0, size, size));
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			intensity := 0.0;
+			covered := 0;
+			for sy := 0; sy < RENDER_SUPERSAMPLING; sy++ {
+				for sx := 0; sx < RENDER_SUPERSAMPLING; sx++ {
+					value := colors[(y * RENDER_SUPERSAMPLING + sy) * renderview.Size + x * RENDER_SUPERSAMPLING + sx];
+					if (value >= 0) {
+						intensity += value;
+						covered++;
+					}
+				}
+			}
+			
+			if (covered == 0) {
+				continue;
+			}
+			
+			intensity = intensity / float64 (covered);
+			thumbnail.SetNRGBA (x, y, color.NRGBA {
+				uint8 (math.Min (255, RenderModelColor[0] * intensity)),
+				uint8 (math.Min (255, RenderModelColor[1] * intensity)),
+				uint8 (math.Min (255, RenderModelColor[2] * intensity)),
+				uint8 (255 * covered / (RENDER_SUPERSAMPLING * RENDER_SUPERSAMPLING)),
+			});
+		}
+	}
+	
+	var buffer bytes.Buffer;
+	err = png.Encode (&buffer, thumbnail);
+	if (err != nil) {
+		return nil, err;
+	}
+	
+	return buffer.Bytes (), nil;
+}
@@ -0,0 +1,257 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_schema.go
+// Creates the storage tables and columns that are not part of the shipped database
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"database/sql"
+)
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// initStorageSchema
+// creates all missing storage tables and columns in the application database. Is called on every 
+// server start.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func initStorageSchema (db *sql.DB) (error) {
+
+	queries := []string {
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_uploads` (" +
+		"`uuid`	varchar ( 64 ) NOT NULL UNIQUE, " +
+		"`itemuuid`	varchar ( 64 ) NOT NULL, " +
+		"`userid`	varchar ( 64 ) NOT NULL, " +
+		"`filesize`	INTEGER DEFAULT 0, " +
+		"`hashoffset`	INTEGER DEFAULT 0, " +
+		"`hashstate`	TEXT NOT NULL DEFAULT '', " +
+		"`status`	varchar ( 16 ) NOT NULL, " +
+		"`timestamp`	TEXT NOT NULL" +
+		")",
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_uploadchunks` (" +
+		"`uploaduuid`	varchar ( 64 ) NOT NULL, " +
+		"`chunkoffset`	INTEGER NOT NULL, " +
+		"`chunksize`	INTEGER NOT NULL" +
+		")",
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_blobs` (" +
+		"`sha1`	varchar ( 64 ) NOT NULL UNIQUE, " +
+		"`filesize`	INTEGER DEFAULT 0, " +
+		"`refcount`	INTEGER DEFAULT 0" +
+		")",
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_deletions` (" +
+		"`uuid`	varchar ( 64 ) NOT NULL UNIQUE, " +
+		"`projectuuid`	varchar ( 64 ) NOT NULL, " +
+		"`objecttype`	varchar ( 16 ) NOT NULL, " +
+		"`objectuuid`	varchar ( 64 ) NOT NULL, " +
+		"`name`	varchar ( 256 ) NOT NULL DEFAULT '', " +
+		"`userid`	varchar ( 64 ) NOT NULL, " +
+		"`timestamp`	TEXT NOT NULL" +
+		")",
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_deletedobjects` (" +
+		"`deletionuuid`	varchar ( 64 ) NOT NULL, " +
+		"`objecttype`	varchar ( 16 ) NOT NULL, " +
+		"`objectuuid`	varchar ( 64 ) NOT NULL" +
+		")",
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_quotas` (" +
+		"`objecttype`	varchar ( 16 ) NOT NULL, " +
+		"`objectuuid`	varchar ( 64 ) NOT NULL UNIQUE, " +
+		"`maxbytes`	INTEGER DEFAULT 0, " +
+		"`maxentities`	INTEGER DEFAULT 0" +
+		")",
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_integrityfindings` (" +
+		"`entityuuid`	varchar ( 64 ) NOT NULL UNIQUE, " +
+		"`itemuuid`	varchar ( 64 ) NOT NULL, " +
+		"`storagename`	varchar ( 256 ) NOT NULL, " +
+		"`problem`	varchar ( 16 ) NOT NULL, " +
+		"`expectedsha1`	varchar ( 64 ) NOT NULL DEFAULT '', " +
+		"`actualsha1`	varchar ( 64 ) NOT NULL DEFAULT '', " +
+		"`expectedsize`	INTEGER DEFAULT 0, " +
+		"`actualsize`	INTEGER DEFAULT 0, " +
+		"`detected`	TEXT NOT NULL, " +
+		"`lastchecked`	TEXT NOT NULL" +
+		")",
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_itemtags` (" +
+		"`itemuuid`	varchar ( 64 ) NOT NULL, " +
+		"`tag`	varchar ( 64 ) NOT NULL COLLATE NOCASE, " +
+		"UNIQUE (`itemuuid`, `tag`)" +
+		")",
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_itemproperties` (" +
+		"`itemuuid`	varchar ( 64 ) NOT NULL, " +
+		"`name`	varchar ( 64 ) NOT NULL COLLATE NOCASE, " +
+		"`type`	varchar ( 16 ) NOT NULL, " +
+		"`value`	TEXT NOT NULL DEFAULT '', " +
+		"UNIQUE (`itemuuid`, `name`)" +
+		")",
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_comments` (" +
+		"`uuid`	varchar ( 64 ) NOT NULL UNIQUE, " +
+		"`itemuuid`	varchar ( 64 ) NOT NULL, " +
+		"`entityuuid`	varchar ( 64 ) NOT NULL DEFAULT '', " +
+		"`parentuuid`	varchar ( 64 ) NOT NULL DEFAULT '', " +
+		"`userid`	varchar ( 64 ) NOT NULL, " +
+		"`text`	TEXT NOT NULL DEFAULT '', " +
+		"`timestamp`	TEXT NOT NULL, " +
+		"`edited`	TEXT NOT NULL DEFAULT '', " +
+		"`deleted`	INTEGER DEFAULT 0" +
+		")",
+
+		"CREATE TABLE IF NOT EXISTS `netstorage_changes` (" +
+		"`sequence`	INTEGER PRIMARY KEY AUTOINCREMENT, " +
+		"`objecttype`	varchar ( 16 ) NOT NULL, " +
+		"`objectuuid`	varchar ( 64 ) NOT NULL, " +
+		"`change`	varchar ( 16 ) NOT NULL, " +
+		"`hubuuid`	varchar ( 64 ) NOT NULL DEFAULT '', " +
+		"`projectuuid`	varchar ( 64 ) NOT NULL DEFAULT '', " +
+		"`timestamp`	TEXT NOT NULL" +
+		")",
+
+		"CREATE VIRTUAL TABLE IF NOT EXISTS `netstorage_search` USING fts4 (" +
+		"`objecttype`, `objectuuid`, `itemuuid`, `content`, " +
+		"notindexed=`objecttype`, notindexed=`objectuuid`, notindexed=`itemuuid`" +
+		")",
+	}
+
+	for _, query := range queries {
+		_, err := db.Exec (query);
+		if (err != nil) {
+			return err;
+		}
+	}
+
+	entityversions, err := addMissingColumn (db, "netstorage_entities", "version", "INTEGER NOT NULL DEFAULT 0");
+	if (err != nil) {
+		return err;
+	}
+	
+	blobsizes, err := addMissingColumn (db, "netstorage_blobs", "storedsize", "INTEGER NOT NULL DEFAULT 0");
+	if (err != nil) {
+		return err;
+	}
+	
+	columns := [][]string {
+		{ "netstorage_entities", "userid", "varchar ( 64 ) NOT NULL DEFAULT ''" },
+		{ "netstorage_entities", "comment", "TEXT NOT NULL DEFAULT ''" },
+		{ "netstorage_entities", "sourceentityuuid", "varchar ( 64 ) NOT NULL DEFAULT ''" },
+		{ "netstorage_entities", "derivation", "varchar ( 16 ) NOT NULL DEFAULT ''" },
+		{ "netstorage_items", "currententityuuid", "varchar ( 64 ) NOT NULL DEFAULT ''" },
+		{ "netstorage_items", "lockuserid", "varchar ( 64 ) NOT NULL DEFAULT ''" },
+		{ "netstorage_items", "locknote", "TEXT NOT NULL DEFAULT ''" },
+		{ "netstorage_items", "locktimestamp", "varchar ( 64 ) NOT NULL DEFAULT ''" },
+		{ "netstorage_items", "lockexpiry", "varchar ( 64 ) NOT NULL DEFAULT ''" },
+		{ "netstorage_changes", "folderuuid", "varchar ( 64 ) NOT NULL DEFAULT ''" },
+		{ "netstorage_blobs", "compression", "varchar ( 16 ) NOT NULL DEFAULT ''" },
+	}
+	
+	for _, column := range columns {
+		_, err := addMissingColumn (db, column[0], column[1], column[2]);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	// number the existing active entities of every item in the order of their upload
+	if (entityversions) {
+		_, err := db.Exec ("UPDATE netstorage_entities SET version=(SELECT COUNT(*) FROM netstorage_entities AS previous " +
+			"WHERE previous.itemuuid=netstorage_entities.itemuuid AND previous.active=1 AND " +
+			"(previous.timestamp<netstorage_entities.timestamp OR (previous.timestamp=netstorage_entities.timestamp AND previous.rowid<=netstorage_entities.rowid))) " +
+			"WHERE active=1");
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	// existing blobs are stored uncompressed
+	if (blobsizes) {
+		_, err := db.Exec ("UPDATE netstorage_blobs SET storedsize=filesize");
+		if (err != nil) {
+			return err;
+		}
+	}
+
+	return initSearchIndex (db);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// addMissingColumn
+// adds a column to one of the shipped tables, if it does not exist yet. Returns true if the column 
+// has been added.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func addMissingColumn (db *sql.DB, table string, column string, definition string) (bool, error) {
+
+	rows, err := db.Query ("PRAGMA table_info(`" + table + "`)");
+	if (err != nil) {
+		return false, err;
+	}
+	
+	exists := false;
+	for (rows.Next()) {
+		var cid int;
+		var name string;
+		var datatype string;
+		var notnull int;
+		var defaultvalue sql.NullString;
+		var primarykey int;
+		
+		err = rows.Scan (&cid, &name, &datatype, &notnull, &defaultvalue, &primarykey);
+		if (err != nil) {
+			rows.Close ();
+			return false, err;
+		}
+		
+		if (name == column) {
+			exists = true;
+		}
+	}
+	rows.Close ();
+	
+	if (exists) {
+		return false, nil;
+	}
+	
+	_, err = db.Exec ("ALTER TABLE `" + table + "` ADD COLUMN `" + column + "` " + definition);
+	if (err != nil) {
+		return false, err;
+	}
+	
+	return true, nil;
+}
@@ -0,0 +1,339 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_search.go
+// Full text search over item names and entity data types and metadata. The SQLite FTS index is 
+// updated whenever an item or an entity is written and references the owning item of every row.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"net/http"
+	"fmt"
+	"strconv"
+	"strings"
+	"errors"
+	"database/sql"
+)
+
+
+const SEARCHTYPE_ITEM = "item";
+const SEARCHTYPE_ENTITY = "entity";
+
+const SEARCH_DEFAULTLIMIT = 100;
+const SEARCH_MAXLIMIT = 1000;
+
+// The indexed content of an item: its name, its tags and the values of its string properties
+const SQL_ITEMSEARCHCONTENT = "netstorage_items.itemname" +
+	" || IFNULL((SELECT ' ' || group_concat(tag, ' ') FROM netstorage_itemtags WHERE itemuuid=netstorage_items.uuid), '')" +
+	" || IFNULL((SELECT ' ' || group_concat(value, ' ') FROM netstorage_itemproperties WHERE itemuuid=netstorage_items.uuid AND type='string'), '')";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// removeSearchObject
+// removes an item or entity from the search index
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func removeSearchObject (db *sql.DB, objectuuid string) (error) {
+
+	statement, err := db.Prepare ("DELETE FROM netstorage_search WHERE objectuuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(objectuuid);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// indexSearchItem
+// (re)indexes the name, tags and string properties of an item
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func indexSearchItem (db *sql.DB, itemuuid string) (error) {
+
+	err := removeSearchObject (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+
+	statement, err := db.Prepare ("INSERT INTO netstorage_search (objecttype, objectuuid, itemuuid, content) SELECT ?, uuid, uuid, " + SQL_ITEMSEARCHCONTENT + " FROM netstorage_items WHERE uuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(SEARCHTYPE_ITEM, itemuuid);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// indexSearchEntity
+// (re)indexes the data type and metadata of an entity
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func indexSearchEntity (db *sql.DB, entityuuid string) (error) {
+
+	err := removeSearchObject (db, entityuuid);
+	if (err != nil) {
+		return err;
+	}
+
+	statement, err := db.Prepare ("INSERT INTO netstorage_search (objecttype, objectuuid, itemuuid, content) SELECT ?, uuid, itemuuid, IFNULL(datatype, '') || ' ' || IFNULL(metadata, '') FROM netstorage_entities WHERE uuid=? AND sourceentityuuid=''");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(SEARCHTYPE_ENTITY, entityuuid);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// initSearchIndex
+// fills the search index with all existing items and entities, if it is empty. Is called on every 
+// server start, after the schema has been created.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func initSearchIndex (db *sql.DB) (error) {
+
+	rows, err := db.Query ("SELECT COUNT(*) FROM netstorage_search");
+	if (err != nil) {
+		return err;
+	}
+	
+	count := 0;
+	if (rows.Next()) {
+		err = rows.Scan (&count);
+	}
+	rows.Close ();
+	if ((err != nil) || (count > 0)) {
+		return err;
+	}
+	
+	queries := []string {
+		"INSERT INTO netstorage_search (objecttype, objectuuid, itemuuid, content) SELECT '" + SEARCHTYPE_ITEM + "', uuid, uuid, " + SQL_ITEMSEARCHCONTENT + " FROM netstorage_items",
+		"INSERT INTO netstorage_search (objecttype, objectuuid, itemuuid, content) SELECT '" + SEARCHTYPE_ENTITY + "', uuid, itemuuid, IFNULL(datatype, '') || ' ' || IFNULL(metadata, '') FROM netstorage_entities WHERE sourceentityuuid=''",
+	}
+	
+	for _, query := range queries {
+		_, err := db.Exec (query);
+		if (err != nil) {
+			return err;
+		}
+	}
+
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// buildSearchMatch
+// converts a user search string into an FTS match expression. Every word is searched as prefix and 
+// all words need to match.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func buildSearchMatch (query string) (string, error) {
+
+	var terms []string;
+	
+	words := strings.FieldsFunc (query, func (c rune) bool {
+		return !(((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')) || ((c >= '0') && (c <= '9')) || (c > 127));
+	});
+	
+	for _, word := range words {
+		terms = append (terms, "\"" + word + "*\"");
+	}
+	
+	if (len (terms) == 0) {
+		return "", errors.New ("Invalid search query: " + query);
+	}
+	
+	return strings.Join (terms, " "), nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveFolderPath
+// returns the path of a folder, starting with the project name instead of the root folder name
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveFolderPath (db *sql.DB, folderuuid string) (string, error) {
+
+	statement, err := db.Prepare ("WITH RECURSIVE ancestors(uuid, parentuuid, projectuuid, foldername, depth) AS (" +
+		"SELECT uuid, parentuuid, projectuuid, foldername, 0 FROM netstorage_folders WHERE uuid=? " +
+		"UNION ALL SELECT netstorage_folders.uuid, netstorage_folders.parentuuid, netstorage_folders.projectuuid, netstorage_folders.foldername, ancestors.depth+1 FROM netstorage_folders JOIN ancestors ON netstorage_folders.uuid=ancestors.parentuuid" +
+		") SELECT ancestors.foldername, IFNULL(netstorage_projects.projectname, '') FROM ancestors LEFT JOIN netstorage_projects ON netstorage_projects.uuid=ancestors.projectuuid ORDER BY ancestors.depth DESC");
+	if (err != nil) {
+		return "", err;
+	}
+		
+	rows, err := statement.Query(folderuuid);
+	if (err != nil) {
+		return "", err;
+	}
+	
+	defer rows.Close();
+	
+	var names []string;
+	for (rows.Next()) {
+		foldername := "";
+		projectname := "";
+		err = rows.Scan (&foldername, &projectname);
+		if (err != nil) {
+			return "", err;
+		}
+		
+		// the root folder is represented by its project
+		if (len (names) == 0) {
+			names = append (names, projectname);
+		} else {
+			names = append (names, foldername);
+		}
+	}
+	
+	return strings.Join (names, "/"), nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// SearchItems
+// searches the active items and entities of a hub, project or folder subtree
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func SearchItems (db *sql.DB, scope string, scopeuuid string, query string, limit int) ([]NetStorageSearchHit, error) {
+	var hits []NetStorageSearchHit;
+
+	match, err := buildSearchMatch (query);
+	if (err != nil) {
+		return hits, err;
+	}
+	
+	scopecondition := "";
+	switch (scope) {
+		case "hub":
+			scopecondition = "netstorage_projects.hubuuid=?";
+		case "project":
+			scopecondition = "netstorage_projects.uuid=?";
+		case "folder":
+			scopecondition = "netstorage_items.folderuuid IN (" + SQL_FOLDERSUBTREE + ")";
+		default:
+			return hits, errors.New ("Invalid search scope: " + scope);
+	}
+	
+	statement, err := db.Prepare ("SELECT netstorage_search.objecttype, netstorage_search.objectuuid, netstorage_items.uuid, netstorage_items.itemname, netstorage_items.folderuuid, netstorage_folders.projectuuid, IFNULL(netstorage_entities.datatype, '') " +
+		"FROM netstorage_search JOIN netstorage_items ON netstorage_items.uuid=netstorage_search.itemuuid AND netstorage_items.active=1 " +
+		"JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid AND netstorage_folders.active=1 " +
+		"JOIN netstorage_projects ON netstorage_projects.uuid=netstorage_folders.projectuuid AND netstorage_projects.active=1 " +
+		"LEFT JOIN netstorage_entities ON netstorage_entities.uuid=netstorage_search.objectuuid " +
+		"WHERE netstorage_search MATCH ? AND (netstorage_search.objecttype=? OR netstorage_entities.active=1) AND " + scopecondition + 
+		" ORDER BY netstorage_items.itemname, netstorage_items.uuid, netstorage_search.objecttype DESC LIMIT ?");
+	if (err != nil) {
+		return hits, err;
+	}
+		
+	rows, err := statement.Query(match, SEARCHTYPE_ITEM, scopeuuid, limit);
+	if (err != nil) {
+		return hits, err;
+	}
+	
+	for (rows.Next()) {
+		var hit NetStorageSearchHit;
+		objectuuid := "";
+		
+		err = rows.Scan (&hit.MatchType, &objectuuid, &hit.ItemUUID, &hit.ItemName, &hit.FolderUUID, &hit.ProjectUUID, &hit.DataType);
+		if (err != nil) {
+			rows.Close ();
+			return hits, err;
+		}
+		
+		if (hit.MatchType == SEARCHTYPE_ENTITY) {
+			hit.EntityUUID = objectuuid;
+		}
+		
+		hits = append (hits, hit);
+	}
+	rows.Close ();
+	
+	// resolve the paths after the result set has been closed
+	paths := make (map[string]string);
+	for index := range hits {
+		path, exists := paths[hits[index].FolderUUID];
+		if (!exists) {
+			path, err = RetrieveFolderPath (db, hits[index].FolderUUID);
+			if (err != nil) {
+				return hits, err;
+			}
+			paths[hits[index].FolderUUID] = path;
+		}
+		
+		hits[index].Path = path + "/" + hits[index].ItemName;
+	}
+	
+	return hits, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageSearchHandler
+// handles a /hubs/<uuid>/search, /projects/<uuid>/search or /folders/<uuid>/search GET request. The 
+// search string is passed as query parameter q=<words>, the number of hits can be limited with 
+// limit=<n>.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageSearchHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, scope string, scopeuuid string) error {
+	query := r.URL.Query().Get ("q");
+	addLogMessage (session, fmt.Sprintf ("Searching %s %s for \"%s\"", scope, scopeuuid, query), LOGTYPE_DATA_SEARCH, LOGLEVEL_CONSOLE);	
+
+	limit := SEARCH_DEFAULTLIMIT;
+	limitparameter := r.URL.Query().Get ("limit");
+	if (limitparameter != "") {
+		value, err := strconv.Atoi (limitparameter);
+		if ((err != nil) || (value <= 0) || (value > SEARCH_MAXLIMIT)) {
+			return errors.New ("Invalid search limit: " + limitparameter);
+		}
+		limit = value;
+	}
+
+	hits, err := SearchItems (db, scope, scopeuuid, query, limit);
+	if (err == nil) {	
+		var reply NetStorageSearchReply;
+		reply.Protocol = PROTOCOL_SEARCH;
+		reply.Version = PROTOCOL_VERSION;
+		reply.Query = query;
+		reply.Hits = hits;
+		if (reply.Hits == nil) {
+			reply.Hits = []NetStorageSearchHit {};
+		}
+		return sendJSON (w, &reply);			
+	}			
+	
+	return err;
+}
@@ -0,0 +1,456 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_stl.go
+// STL file reading, writing and geometry analysis. STL files are streamed triangle by triangle, so 
+// that large meshes do not need to be held in memory. Only the edge analysis keeps the vertices and 
+// edges of a mesh and is therefore limited to smaller meshes. STL files carry no unit, all values are 
+// in file units.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+
+const STL_FORMAT_ASCII = "ascii";
+const STL_FORMAT_BINARY = "binary";
+
+const STL_BINARYHEADERSIZE = 84;
+const STL_BINARYTRIANGLESIZE = 50;
+
+// Binary headers must not start with "solid", as some readers take them for ASCII files
+const STL_BINARYHEADER = "Binary STL written by Netfabb Application Server";
+
+// Edge analysis needs memory proportional to the mesh size, roughly 100 bytes per triangle, and is 
+// skipped for larger meshes
+const STL_MAXEDGEANALYSISTRIANGLES = 1000000;
+
+
+// A triangle given by its three vertices
+type NetStorageSTLTriangle [3][3]float32
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// isFiniteVertex
+// checks that no coordinate of a vertex is NaN or infinite. Mesh readers reject such vertices, as 
+// they break bounding boxes, rendering and the JSON encoding of the metadata.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func isFiniteVertex (vertex [3]float32) bool {
+
+	for coordinate := 0; coordinate < 3; coordinate++ {
+		value := float64 (vertex[coordinate]);
+		if (math.IsNaN (value) || math.IsInf (value, 0)) {
+			return false;
+		}
+	}
+	
+	return true;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// readSTLTriangles
+// reads an ASCII or binary STL file and calls the callback for every triangle. Returns the detected 
+// format. A file is read as binary if its size matches the triangle count of the binary header.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func readSTLTriangles (file io.ReadSeeker, filesize int64, callback func (triangle NetStorageSTLTriangle)) (string, error) {
+
+	header := make ([]byte, STL_BINARYHEADERSIZE);
+	headersize, err := io.ReadFull (file, header);
+	if ((err != nil) && (err != io.ErrUnexpectedEOF)) {
+		return "", err;
+	}
+	
+	if (headersize == STL_BINARYHEADERSIZE) {
+		count := int64 (binary.LittleEndian.Uint32 (header[80:84]));
+		if (STL_BINARYHEADERSIZE + count * STL_BINARYTRIANGLESIZE == filesize) {
+			return STL_FORMAT_BINARY, readBinarySTLTriangles (file, count, callback);
+		}
+	}
+	
+	if (!bytes.HasPrefix (bytes.TrimLeft (header[:headersize], " \t\r\n"), []byte ("solid"))) {
+		return "", errors.New ("not a valid STL file");
+	}
+	
+	_, err = file.Seek (0, io.SeekStart);
+	if (err != nil) {
+		return "", err;
+	}
+	
+	return STL_FORMAT_ASCII, readASCIISTLTriangles (file, callback);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// readBinarySTLTriangles
+// reads the triangles of a binary STL file, the header has already been read
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func readBinarySTLTriangles (file io.Reader, count int64, callback func (triangle NetStorageSTLTriangle)) (error) {
+
+	reader := bufio.NewReaderSize (file, 1024 * 1024);
+	record := make ([]byte, STL_BINARYTRIANGLESIZE);
+	
+	var index int64;
+	for index = 0; index < count; index++ {
+		_, err := io.ReadFull (reader, record);
+		if (err != nil) {
+			return err;
+		}
+		
+		// the normal in the first 12 bytes is ignored
+		var triangle NetStorageSTLTriangle;
+		for vertex := 0; vertex < 3; vertex++ {
+			for coordinate := 0; coordinate < 3; coordinate++ {
+				offset := 12 + vertex * 12 + coordinate * 4;
+				triangle[vertex][coordinate] = math.Float32frombits (binary.LittleEndian.Uint32 (record[offset:offset + 4]));
+			}
+			
+			if (!isFiniteVertex (triangle[vertex])) {
+				return errors.New (fmt.Sprintf ("invalid STL vertex in triangle %d", index));
+			}
+		}
+		
+		callback (triangle);
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// readASCIISTLTriangles
+// reads the triangles of an ASCII STL file. Every three vertex statements form a triangle.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func readASCIISTLTriangles (file io.Reader, callback func (triangle NetStorageSTLTriangle)) (error) {
+
+	scanner := bufio.NewScanner (file);
+	scanner.Buffer (make ([]byte, 64 * 1024), 1024 * 1024);
+	scanner.Split (bufio.ScanWords);
+	
+	var triangle NetStorageSTLTriangle;
+	vertex := 0;
+	
+	for (scanner.Scan ()) {
+		if (scanner.Text () != "vertex") {
+			continue;
+		}
+		
+		for coordinate := 0; coordinate < 3; coordinate++ {
+			if (!scanner.Scan ()) {
+				return errors.New ("unexpected end of STL file");
+			}
+			
+			value, err := strconv.ParseFloat (scanner.Text (), 32);
+			if (err != nil) {
+				return errors.New ("invalid STL vertex coordinate: " + scanner.Text ());
+			}
+			triangle[vertex][coordinate] = float32 (value);
+		}
+		
+		if (!isFiniteVertex (triangle[vertex])) {
+			return errors.New ("invalid STL vertex, coordinates must be finite");
+		}
+		
+		vertex++;
+		if (vertex == 3) {
+			callback (triangle);
+			vertex = 0;
+		}
+	}
+	
+	if (vertex != 0) {
+		return errors.New ("incomplete triangle in STL file");
+	}
+	
+	return scanner.Err ();
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// analyzeSTLGeometry
+// computes the triangle count, bounding box, surface area and volume of an STL file and checks if 
+// the mesh is watertight. A mesh is watertight if every edge is shared by exactly two triangles 
+// with opposite orientation.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func analyzeSTLGeometry (file io.ReadSeeker, filesize int64) (NetStorageSTLGeometry, error) {
+	var geometry NetStorageSTLGeometry;
+	
+	// vertices are identified by their exact coordinates, edges are directed vertex index pairs
+	vertices := make (map[[3]float32]uint32);
+	edges := make (map[uint64]int32);
+	analyzeedges := true;
+	
+	var volume float64;
+	
+	format, err := readSTLTriangles (file, filesize, func (triangle NetStorageSTLTriangle) {
+		var points [3][3]float64;
+		for vertex := 0; vertex < 3; vertex++ {
+			for coordinate := 0; coordinate < 3; coordinate++ {
+				value := float64 (triangle[vertex][coordinate]);
+				points[vertex][coordinate] = value;
+				
+				if ((geometry.TriangleCount == 0) && (vertex == 0)) {
+					geometry.BoundingBox.Min[coordinate] = value;
+					geometry.BoundingBox.Max[coordinate] = value;
+				} else {
+					geometry.BoundingBox.Min[coordinate] = math.Min (geometry.BoundingBox.Min[coordinate], value);
+					geometry.BoundingBox.Max[coordinate] = math.Max (geometry.BoundingBox.Max[coordinate], value);
+				}
+			}
+		}
+		geometry.TriangleCount++;
+		
+		// area from the cross product of two edges, volume as sum of signed tetrahedra volumes
+		a := [3]float64 { points[1][0] - points[0][0], points[1][1] - points[0][1], points[1][2] - points[0][2] };
+		b := [3]float64 { points[2][0] - points[0][0], points[2][1] - points[0][1], points[2][2] - points[0][2] };
+		cross := [3]float64 { a[1] * b[2] - a[2] * b[1], a[2] * b[0] - a[0] * b[2], a[0] * b[1] - a[1] * b[0] };
+		geometry.SurfaceArea += 0.5 * math.Sqrt (cross[0] * cross[0] + cross[1] * cross[1] + cross[2] * cross[2]);
+		
+		volume += (points[0][0] * (points[1][1] * points[2][2] - points[1][2] * points[2][1]) +
+			points[0][1] * (points[1][2] * points[2][0] - points[1][0] * points[2][2]) +
+			points[0][2] * (points[1][0] * points[2][1] - points[1][1] * points[2][0])) / 6.0;
+		
+		if (geometry.TriangleCount > STL_MAXEDGEANALYSISTRIANGLES) {
+			analyzeedges = false;
+		}
+		if (!analyzeedges) {
+			return;
+		}
+		
+		var indices [3]uint32;
+		for vertex := 0; vertex < 3; vertex++ {
+			// -0 and +0 are the same coordinate
+			key := triangle[vertex];
+			for coordinate := 0; coordinate < 3; coordinate++ {
+				if (key[coordinate] == 0) {
+					key[coordinate] = 0;
+				}
+			}
+			
+			index, found := vertices[key];
+			if (!found) {
+				index = uint32 (len (vertices));
+				vertices[key] = index;
+			}
+			indices[vertex] = index;
+		}
+		
+		for vertex := 0; vertex < 3; vertex++ {
+			from := indices[vertex];
+			to := indices[(vertex + 1) % 3];
+			if (from != to) {
+				edges[uint64 (from) << 32 | uint64 (to)]++;
+			}
+		}
+	});
+	if (err != nil) {
+		return geometry, err;
+	}
+	
+	geometry.Format = format;
+	geometry.Volume = math.Abs (volume);
+	for coordinate := 0; coordinate < 3; coordinate++ {
+		geometry.Size[coordinate] = geometry.BoundingBox.Max[coordinate] - geometry.BoundingBox.Min[coordinate];
+	}
+	
+	if (analyzeedges) {
+		geometry.VertexCount = len (vertices);
+		
+		// every undirected edge is counted once
+		for edge, count := range edges {
+			reverse := edge << 32 | edge >> 32;
+			reversecount := edges[reverse];
+			if ((reversecount > 0) && (edge > reverse)) {
+				continue;
+			}
+			
+			if ((count > 1) || (reversecount > 1)) {
+				geometry.NonManifoldEdges++;
+			} else if (reversecount == 0) {
+				geometry.BoundaryEdges++;
+			}
+		}
+		
+		watertight := (geometry.TriangleCount > 0) && (geometry.NonManifoldEdges == 0) && (geometry.BoundaryEdges == 0);
+		geometry.Watertight = &watertight;
+	}
+	
+	return geometry, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// computeSTLNormal
+// computes the unit normal of a triangle from its counterclockwise vertex order. Degenerate 
+// triangles get a zero normal.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func computeSTLNormal (triangle NetStorageSTLTriangle) ([3]float32) {
+	var normal [3]float32;
+
+	var edge1 [3]float64;
+	var edge2 [3]float64;
+	for coordinate := 0; coordinate < 3; coordinate++ {
+		edge1[coordinate] = float64 (triangle[1][coordinate] - triangle[0][coordinate]);
+		edge2[coordinate] = float64 (triangle[2][coordinate] - triangle[0][coordinate]);
+	}
+	
+	cross := [3]float64 {
+		edge1[1] * edge2[2] - edge1[2] * edge2[1],
+		edge1[2] * edge2[0] - edge1[0] * edge2[2],
+		edge1[0] * edge2[1] - edge1[1] * edge2[0],
+	};
+	
+	length := math.Sqrt (cross[0] * cross[0] + cross[1] * cross[1] + cross[2] * cross[2]);
+	if (length > 0) {
+		for coordinate := 0; coordinate < 3; coordinate++ {
+			normal[coordinate] = float32 (cross[coordinate] / length);
+		}
+	}
+	
+	return normal;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// writeBinarySTL
+// writes the triangles of a mesh source as binary STL file and returns the number of triangles. The 
+// triangle count of the header is written once the source has been read.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func writeBinarySTL (file io.WriteSeeker, source NetStorageTriangleSource) (int64, error) {
+
+	header := make ([]byte, STL_BINARYHEADERSIZE);
+	copy (header, STL_BINARYHEADER);
+	
+	_, err := file.Write (header);
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	writer := bufio.NewWriterSize (file, 1024 * 1024);
+	record := make ([]byte, STL_BINARYTRIANGLESIZE);
+	var count int64;
+	
+	err = source (func (triangle NetStorageSTLTriangle) {
+		normal := computeSTLNormal (triangle);
+		for coordinate := 0; coordinate < 3; coordinate++ {
+			binary.LittleEndian.PutUint32 (record[coordinate * 4:], math.Float32bits (normal[coordinate]));
+		}
+		for vertex := 0; vertex < 3; vertex++ {
+			for coordinate := 0; coordinate < 3; coordinate++ {
+				offset := 12 + vertex * 12 + coordinate * 4;
+				binary.LittleEndian.PutUint32 (record[offset:], math.Float32bits (triangle[vertex][coordinate]));
+			}
+		}
+		
+		writer.Write (record);
+		count++;
+	});
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	if (count > math.MaxUint32) {
+		return 0, errors.New ("too many triangles for a binary STL file");
+	}
+	
+	// write errors are sticky and reported by the flush
+	err = writer.Flush ();
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	_, err = file.Seek (80, io.SeekStart);
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	binary.LittleEndian.PutUint32 (header[80:84], uint32 (count));
+	_, err = file.Write (header[80:84]);
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	return count, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// writeASCIISTL
+// writes the triangles of a mesh source as ASCII STL file and returns the number of triangles
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func writeASCIISTL (file io.Writer, name string, source NetStorageTriangleSource) (int64, error) {
+
+	writer := bufio.NewWriterSize (file, 1024 * 1024);
+	formatvalue := func (value float32) string {
+		return strconv.FormatFloat (float64 (value), 'e', -1, 32);
+	};
+	
+	fmt.Fprintf (writer, "solid %s\n", name);
+	
+	var count int64;
+	err := source (func (triangle NetStorageSTLTriangle) {
+		normal := computeSTLNormal (triangle);
+		fmt.Fprintf (writer, "  facet normal %s %s %s\n    outer loop\n", formatvalue (normal[0]), formatvalue (normal[1]), formatvalue (normal[2]));
+		for vertex := 0; vertex < 3; vertex++ {
+			fmt.Fprintf (writer, "      vertex %s %s %s\n", formatvalue (triangle[vertex][0]), formatvalue (triangle[vertex][1]), formatvalue (triangle[vertex][2]));
+		}
+		fmt.Fprintf (writer, "    endloop\n  endfacet\n");
+		count++;
+	});
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	fmt.Fprintf (writer, "endsolid %s\n", name);
+	
+	// write errors are sticky and reported by the flush
+	err = writer.Flush ();
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	return count, nil;
+}
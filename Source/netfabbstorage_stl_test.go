@@ -0,0 +1,111 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_stl_test.go
+// Tests of the STL geometry analysis.
+// Run with
+//   go test netfabbstorage_stl_test.go netfabbapplicationserver.go netfabbstorage_config.go <common_source>
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createTestBinarySTL
+// writes triangles as binary STL with zero normals
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createTestBinarySTL (triangles []NetStorageSTLTriangle) []byte {
+
+	var buffer bytes.Buffer;
+	buffer.Write (make ([]byte, 80));
+	binary.Write (&buffer, binary.LittleEndian, uint32 (len (triangles)));
+	
+	for _, triangle := range triangles {
+		binary.Write (&buffer, binary.LittleEndian, [3]float32 {});
+		binary.Write (&buffer, binary.LittleEndian, triangle);
+		binary.Write (&buffer, binary.LittleEndian, uint16 (0));
+	}
+	
+	return buffer.Bytes ();
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// TestAnalyzeSTLSignedZero
+// a closed tetrahedron stays watertight if its faces write the zero coordinates with different signs
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func TestAnalyzeSTLSignedZero (t *testing.T) {
+
+	negativezero := float32 (math.Copysign (0, -1));
+	if (math.Float32bits (negativezero) == 0) {
+		t.Fatal ("negative zero is not distinct from zero");
+	}
+	
+	origin := [3]float32 { 0, 0, 0 };
+	negativeorigin := [3]float32 { negativezero, negativezero, negativezero };
+	x := [3]float32 { 1, 0, 0 };
+	y := [3]float32 { negativezero, 1, 0 };
+	z := [3]float32 { 0, negativezero, 1 };
+	negativex := [3]float32 { 1, negativezero, negativezero };
+	
+	data := createTestBinarySTL ([]NetStorageSTLTriangle {
+		{ origin, y, x },
+		{ negativeorigin, negativex, z },
+		{ origin, z, y },
+		{ x, y, z },
+	});
+	
+	geometry, err := analyzeSTLGeometry (bytes.NewReader (data), int64 (len (data)));
+	if (err != nil) {
+		t.Fatal (err);
+	}
+	
+	if (geometry.Format != STL_FORMAT_BINARY) {
+		t.Errorf ("format %s, expected binary", geometry.Format);
+	}
+	if ((geometry.TriangleCount != 4) || (geometry.VertexCount != 4)) {
+		t.Errorf ("%d triangles and %d vertices, expected 4 and 4", geometry.TriangleCount, geometry.VertexCount);
+	}
+	if ((geometry.Watertight == nil) || !*geometry.Watertight || (geometry.BoundaryEdges != 0) || (geometry.NonManifoldEdges != 0)) {
+		t.Errorf ("mesh is not watertight: %d boundary edges, %d non-manifold edges", geometry.BoundaryEdges, geometry.NonManifoldEdges);
+	}
+	if (math.Abs (geometry.Volume - 1.0 / 6.0) > 1e-6) {
+		t.Errorf ("volume %f, expected %f", geometry.Volume, 1.0 / 6.0);
+	}
+}
@@ -0,0 +1,265 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_thumbnails.go
+// Thumbnail images of entities. Thumbnails are either embedded in 3MF packages or rendered from the 
+// mesh of STL and 3MF entities. They are stored as entities derived from the entity they show, with 
+// their content in the blob store, and are purged together with it.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"net/http"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"database/sql"
+)
+
+
+const THUMBNAILSOURCE_3MF = "3mf";
+const THUMBNAILSOURCE_RENDERED = "rendered";
+
+const THUMBNAIL_MAXSIZE = 2048;
+
+const ENTITYDERIVATION_THUMBNAIL = "thumbnail";
+const ENTITYMETADATA_THUMBNAIL = "thumbnail";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveEntityThumbnail
+// retrieves the thumbnail of an entity and the derived entity that stores it
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveEntityThumbnail (db *sql.DB, entityuuid string) (NetStorageThumbnail, NetStorageEntity, error) {
+	var thumbnail NetStorageThumbnail;
+	var thumbnailentity NetStorageEntity;
+	
+	entities, err := RetrieveDerivedEntities (db, entityuuid, ENTITYDERIVATION_THUMBNAIL);
+	if (err != nil) {
+		return thumbnail, thumbnailentity, err;
+	}
+	
+	if (len (entities) == 0) {
+		return thumbnail, thumbnailentity, errors.New("entity has no thumbnail: " + entityuuid);		
+	}
+	thumbnailentity = entities[0];
+	
+	var metadata map[string]NetStorageThumbnailMetaData;
+	err = json.Unmarshal ([]byte (thumbnailentity.MetaData), &metadata);
+	if (err != nil) {
+		return thumbnail, thumbnailentity, err;
+	}
+	
+	thumbnail.EntityUUID = entityuuid;
+	thumbnail.ThumbnailEntityUUID = thumbnailentity.UUID;
+	thumbnail.ContentType = thumbnailentity.DataType;
+	thumbnail.Source = metadata[ENTITYMETADATA_THUMBNAIL].Source;
+	thumbnail.TimeStamp = thumbnailentity.TimeStamp;
+	
+	thumbnail.FileSize, err = strconv.ParseInt (thumbnailentity.FileSize, 10, 64);
+	
+	return thumbnail, thumbnailentity, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// storeEntityThumbnail
+// stores the thumbnail image of an entity as derived entity, replacing an existing one
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func storeEntityThumbnail (db *sql.DB, entity NetStorageEntity, contenttype string, source string, data []byte) (error) {
+
+	tempfilename := getUploadStorageName (createUUID ());
+	err := ioutil.WriteFile (tempfilename, data, 0644);
+	if (err != nil) {
+		os.Remove (tempfilename);
+		return err;
+	}
+	
+	sha1sum, filesize, err := hashTemporaryFile (tempfilename);
+	if (err != nil) {
+		os.Remove (tempfilename);
+		return err;
+	}
+	
+	var thumbnailmetadata NetStorageThumbnailMetaData;
+	thumbnailmetadata.Source = source;
+	
+	metadata, err := mergeEntityMetadata ("", ENTITYMETADATA_THUMBNAIL, thumbnailmetadata);
+	if (err != nil) {
+		os.Remove (tempfilename);
+		return err;
+	}
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		os.Remove (tempfilename);
+		return err;
+	}
+	
+	var replacedentities []NetStorageEntity;
+	previousentities, err := RetrieveDerivedEntities (db, entity.UUID, ENTITYDERIVATION_THUMBNAIL);
+	if (err == nil) {
+		replacedentities, err = purgeEntities (db, previousentities);
+	}
+	if (err == nil) {
+		err = createDerivedEntity (db, createUUID (), entity, ENTITYDERIVATION_THUMBNAIL, contenttype, sha1sum, filesize, metadata);
+	}
+	if (err == nil) {
+		err = storeBlob (db, tempfilename, sha1sum, filesize);
+	}
+	if (err == nil) {
+		err = recordChange (db, CHANGEOBJECT_ENTITY, entity.UUID, CHANGETYPE_UPDATE);
+	}
+	if (err == nil) {
+		err = CommittTransaction (db);
+	}
+	if (err != nil) {
+		RollbackTransaction (db);
+		os.Remove (tempfilename);
+		discardOrphanBlob (db, sha1sum);
+		return err;
+	}
+	
+	for _, replacedentity := range replacedentities {
+		err = releaseEntityContent (db, replacedentity);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// renderEntityThumbnail
+// renders a PNG thumbnail of an STL or 3MF entity and stores it as thumbnail of the entity
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func renderEntityThumbnail (db *sql.DB, entity NetStorageEntity, size int) (NetStorageThumbnail, error) {
+	var thumbnail NetStorageThumbnail;
+
+	contenttype := getDataTypeContentType (entity.DataType);
+	if ((contenttype != "model/stl") && (contenttype != "model/3mf")) {
+		return thumbnail, errors.New ("cannot render thumbnails of datatype " + entity.DataType);
+	}
+	
+	file, filesize, err := openEntityContent (db, entity);
+	if (err != nil) {
+		return thumbnail, err;
+	}
+	defer file.Close ();
+	
+	source, err := createMeshSource (file, filesize, contenttype);
+	if (err != nil) {
+		return thumbnail, err;
+	}
+	
+	data, err := renderMeshThumbnail (source, size);
+	if (err != nil) {
+		return thumbnail, err;
+	}
+	
+	err = storeEntityThumbnail (db, entity, "image/png", THUMBNAILSOURCE_RENDERED, data);
+	if (err != nil) {
+		return thumbnail, err;
+	}
+	
+	thumbnail, _, err = RetrieveEntityThumbnail (db, entity.UUID);
+	return thumbnail, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageEntityThumbnailRenderHandler
+// handles a /entities/<uuid>/thumbnail POST request and renders the thumbnail of an entity again
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageEntityThumbnailRenderHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, entityuuid string) error {
+	addLogMessage (session, "Rendering thumbnail of entity: " + entityuuid, LOGTYPE_DATA_ANALYSIS, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageRenderThumbnailRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_RENDERTHUMBNAIL);
+	if (err != nil) {
+		return err;
+	}
+	
+	// a size of 0 uses the configured one
+	size := request.Size;
+	if (size == 0) {
+		size = GlobalConfig.Thumbnails.Size;
+	}
+	if ((size < 0) || (size > THUMBNAIL_MAXSIZE)) {
+		return errors.New ("Invalid thumbnail size");
+	}
+			
+	entity, err := RetrieveEntityByUUID (db, entityuuid, true);
+	if (err != nil) {
+		return err;
+	}
+	
+	thumbnail, err := renderEntityThumbnail (db, entity, size);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageRenderThumbnailReply;
+	reply.Protocol = PROTOCOL_RENDERTHUMBNAIL;
+	reply.Version = PROTOCOL_VERSION;
+	reply.Thumbnail = thumbnail;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageEntityThumbnailHandler
+// handles a /entities/<uuid>/thumbnail GET or HEAD request and sends the thumbnail image of an entity
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageEntityThumbnailHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, entityuuid string) error {
+	addLogMessage (session, "Downloading thumbnail of entity: " + entityuuid, LOGTYPE_DATA_DOWNLOADENTITY, LOGLEVEL_DBONLY);	
+			
+	entity, err := RetrieveEntityByUUID (db, entityuuid, false);
+	if (err != nil) {
+		return err;
+	}
+	
+	thumbnail, thumbnailentity, err := RetrieveEntityThumbnail (db, entity.UUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	return serveEntityContent (db, session, w, r, thumbnailentity, thumbnail.ContentType);
+}
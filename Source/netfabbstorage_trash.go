@@ -0,0 +1,711 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_trash.go
+// Recycle bin. Every delete operation is recorded as a deletion with the list of all objects it has 
+// deactivated, so that exactly this set can be restored again. Deletions that are older than the 
+// configured retention period are purged permanently together with their file content.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"net/http"
+	"fmt"
+	"os"
+	"time"
+	"errors"
+	"database/sql"
+)
+
+
+const DELETIONTYPE_PROJECT = "project";
+const DELETIONTYPE_FOLDER = "folder";
+const DELETIONTYPE_ITEM = "item";
+const DELETIONTYPE_ENTITY = "entity";
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createDeletion
+// creates a new deletion DB entry. The deleted objects are added by the delete functions.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createDeletion (db *sql.DB, deletionuuid string, projectuuid string, objecttype string, objectuuid string, name string, userid string) (error) {
+
+	timestamp := time.Now().Format(time.RFC3339);
+
+	statement, err := db.Prepare ("INSERT INTO netstorage_deletions (uuid, projectuuid, objecttype, objectuuid, name, userid, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?)");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(deletionuuid, projectuuid, objecttype, objectuuid, name, userid, timestamp);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// addDeletedObject
+// records an object as deactivated by a deletion
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func addDeletedObject (db *sql.DB, deletionuuid string, objecttype string, objectuuid string) (error) {
+
+	statement, err := db.Prepare ("INSERT INTO netstorage_deletedobjects (deletionuuid, objecttype, objectuuid) VALUES (?, ?, ?)");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(deletionuuid, objecttype, objectuuid);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// queryDeletions
+// retrieves the deletions matching a condition including the number of deactivated objects
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func queryDeletions (db *sql.DB, condition string, args ...interface{}) ([]NetStorageDeletion, error) {
+	var deletions []NetStorageDeletion;
+	
+	statement, err := db.Prepare ("SELECT uuid, projectuuid, objecttype, objectuuid, name, userid, timestamp, (SELECT COUNT(*) FROM netstorage_deletedobjects WHERE deletionuuid=netstorage_deletions.uuid) FROM netstorage_deletions WHERE " + condition + " ORDER BY timestamp");
+	if (err != nil) {
+		return deletions, err;
+	}
+		
+	rows, err := statement.Query(args...);
+	if (err != nil) {
+		return deletions, err;
+	}
+	
+	defer rows.Close();
+
+	for (rows.Next()) {
+		var deletion NetStorageDeletion;
+		
+		err = rows.Scan (&deletion.UUID, &deletion.ProjectUUID, &deletion.ObjectType, &deletion.ObjectUUID, &deletion.Name, &deletion.UserID, &deletion.TimeStamp, &deletion.ObjectCount);
+		if (err != nil) {
+			return deletions, err;
+		}
+		
+		deletions = append (deletions, deletion);
+	}
+	
+	return deletions, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveDeletionsOfProject
+// retrieves the deletions of a project that match the list options, including the deletion of the 
+// project itself
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveDeletionsOfProject (db *sql.DB, projectuuid string, options NetStorageListOptions) ([]NetStorageDeletion, NetStorageListPage, error) {
+	var deletions []NetStorageDeletion;
+	var page NetStorageListPage;
+	
+	query := NetStorageListQuery {
+		Fields: "uuid, projectuuid, objecttype, objectuuid, name, userid, timestamp, (SELECT COUNT(*) FROM netstorage_deletedobjects WHERE deletionuuid=netstorage_deletions.uuid)",
+		From: "netstorage_deletions",
+		Condition: "projectuuid=?",
+		Arguments: []interface{} { projectuuid },
+		UUIDField: "uuid",
+		SortFields: map[string]string { LISTSORT_NAME: "name", LISTSORT_TIMESTAMP: "timestamp" },
+		DefaultSort: LISTSORT_TIMESTAMP,
+		NameField: "name",
+	};
+	
+	rows, err := openList (db, query, options);
+	if (err != nil) {
+		return deletions, page, err;
+	}
+	
+	defer rows.Close();
+
+	for (rows.Next()) {
+		var deletion NetStorageDeletion;
+		
+		err = rows.Scan (&deletion.UUID, &deletion.ProjectUUID, &deletion.ObjectType, &deletion.ObjectUUID, &deletion.Name, &deletion.UserID, &deletion.TimeStamp, &deletion.ObjectCount);
+		if (err != nil) {
+			return deletions, page, err;
+		}
+		
+		deletions = append (deletions, deletion);
+	}
+	
+	page, err = rows.Page ();
+	return deletions, page, err;
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveDeletionByUUID
+// retrieves a deletion by uuid
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveDeletionByUUID (db *sql.DB, deletionuuid string) (NetStorageDeletion, error) {
+	var deletion NetStorageDeletion;
+
+	deletions, err := queryDeletions (db, "uuid=?", deletionuuid);
+	if (err != nil) {
+		return deletion, err;
+	}
+	
+	if (len (deletions) == 0) {
+		return deletion, errors.New("deletion not found: " + deletionuuid);		
+	}
+	
+	return deletions[0], nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveDeletedObjects
+// retrieves the uuids of all objects of one type that have been deactivated by a deletion
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveDeletedObjects (db *sql.DB, deletionuuid string, objecttype string) ([]string, error) {
+	var objectuuids []string;
+	
+	statement, err := db.Prepare ("SELECT objectuuid FROM netstorage_deletedobjects WHERE deletionuuid=? AND objecttype=?");
+	if (err != nil) {
+		return objectuuids, err;
+	}
+		
+	rows, err := statement.Query(deletionuuid, objecttype);
+	if (err != nil) {
+		return objectuuids, err;
+	}
+	
+	defer rows.Close();
+
+	for (rows.Next()) {
+		objectuuid := "";
+		err = rows.Scan (&objectuuid);
+		if (err != nil) {
+			return objectuuids, err;
+		}
+		
+		objectuuids = append (objectuuids, objectuuid);
+	}
+	
+	return objectuuids, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// checkDeletionRestorable
+// checks that the parent of the deleted object is still active. Otherwise the parent has to be 
+// restored first.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func checkDeletionRestorable (db *sql.DB, deletion NetStorageDeletion) (error) {
+
+	query := "";
+	switch (deletion.ObjectType) {
+		case DELETIONTYPE_PROJECT:
+			return nil;
+			
+		case DELETIONTYPE_FOLDER:
+			query = "SELECT netstorage_folders.uuid FROM netstorage_folders JOIN netstorage_projects ON netstorage_projects.uuid=netstorage_folders.projectuuid " + 
+				"WHERE netstorage_folders.uuid=? AND netstorage_projects.active=1 AND (netstorage_folders.parentuuid='' OR " +
+				"EXISTS (SELECT uuid FROM netstorage_folders AS parents WHERE parents.uuid=netstorage_folders.parentuuid AND parents.active=1))";
+			
+		case DELETIONTYPE_ITEM:
+			query = "SELECT netstorage_items.uuid FROM netstorage_items JOIN netstorage_folders ON netstorage_folders.uuid=netstorage_items.folderuuid " +
+				"WHERE netstorage_items.uuid=? AND netstorage_folders.active=1";
+			
+		case DELETIONTYPE_ENTITY:
+			query = "SELECT netstorage_entities.uuid FROM netstorage_entities JOIN netstorage_items ON netstorage_items.uuid=netstorage_entities.itemuuid " +
+				"WHERE netstorage_entities.uuid=? AND netstorage_items.active=1";
+			
+		default:
+			return errors.New ("Invalid deletion type: " + deletion.ObjectType);
+	}
+
+	statement, err := db.Prepare (query);
+	if (err != nil) {
+		return err;
+	}
+		
+	rows, err := statement.Query(deletion.ObjectUUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	defer rows.Close();
+
+	if (!rows.Next()) {
+		return errors.New (fmt.Sprintf ("the parent of the deleted %s has been deleted as well and needs to be restored first: %s", deletion.ObjectType, deletion.ObjectUUID));
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// restoreDeletion
+// reactivates all objects of a deletion and removes the deletion from the recycle bin
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func restoreDeletion (db *sql.DB, deletionuuid string) (error) {
+
+	tables := map[string]string {
+		DELETIONTYPE_PROJECT: "netstorage_projects",
+		DELETIONTYPE_FOLDER: "netstorage_folders",
+		DELETIONTYPE_ITEM: "netstorage_items",
+		DELETIONTYPE_ENTITY: "netstorage_entities",
+	}
+	
+	for objecttype, table := range tables {
+		statement, err := db.Prepare ("UPDATE " + table + " SET active=1 WHERE uuid IN (SELECT objectuuid FROM netstorage_deletedobjects WHERE deletionuuid=? AND objecttype=?)");
+		if (err != nil) {
+			return err;
+		}
+		
+		_, err = statement.Exec(deletionuuid, objecttype);	
+		if (err != nil) {
+			return err;
+		}
+	}
+	
+	err := recordDeletionChanges (db, deletionuuid, CHANGETYPE_RESTORE);
+	if (err != nil) {
+		return err;
+	}
+	
+	return removeDeletion (db, deletionuuid);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// removeDeletion
+// removes a deletion and its object list from the DB
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func removeDeletion (db *sql.DB, deletionuuid string) (error) {
+
+	statement1, err := db.Prepare ("DELETE FROM netstorage_deletedobjects WHERE deletionuuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement1.Exec(deletionuuid);	
+	if (err != nil) {
+		return err;
+	}
+
+	statement2, err := db.Prepare ("DELETE FROM netstorage_deletions WHERE uuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement2.Exec(deletionuuid);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// purgeEntities
+// permanently removes the given entity DB entries together with the entities derived from them. 
+// Returns all purged entities, whose file content may only be released after the transaction has 
+// been committed.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func purgeEntities (db *sql.DB, entities []NetStorageEntity) ([]NetStorageEntity, error) {
+	purgedentities := make ([]NetStorageEntity, 0, len (entities));
+
+	for _, entity := range entities {
+		derivedentities, err := RetrieveDerivedEntities (db, entity.UUID, "");
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		if (len (derivedentities) > 0) {
+			derivedentities, err = purgeEntities (db, derivedentities);
+			if (err != nil) {
+				return purgedentities, err;
+			}
+			purgedentities = append (purgedentities, derivedentities...);
+		}
+	
+		statement1, err := db.Prepare ("DELETE FROM netstorage_entities WHERE uuid=?");
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		_, err = statement1.Exec(entity.UUID);	
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		// the entity might have been deleted on its own before
+		statement2, err := db.Prepare ("DELETE FROM netstorage_deletedobjects WHERE objectuuid=?");
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		_, err = statement2.Exec(entity.UUID);	
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		err = removeSearchObject (db, entity.UUID);
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		statement3, err := db.Prepare ("DELETE FROM netstorage_comments WHERE entityuuid=?");
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		_, err = statement3.Exec(entity.UUID);	
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		purgedentities = append (purgedentities, entity);
+	}
+	
+	return purgedentities, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// releaseEntityContent
+// releases the file content of a purged entity. Blobs are reference counted, files of entities that 
+// have been uploaded before the blob store existed are removed directly.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func releaseEntityContent (db *sql.DB, entity NetStorageEntity) (error) {
+
+	storagename, _, err := getEntityStorageName (db, entity);
+	if (err != nil) {
+		return err;
+	}
+
+	if (storagename != getUUIDStorageName (entity.UUID)) {
+		return releaseBlob (db, entity.SHA1);
+	}
+	
+	err = StorageBackend.Remove (storagename);
+	if (os.IsNotExist (err)) {
+		return nil;
+	}
+	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// purgeDeletion
+// permanently removes all objects of a deletion. Items are removed with all their entities. 
+// Returns the purged entities, whose content needs to be released after committing.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func purgeDeletion (db *sql.DB, deletionuuid string, result * NetStoragePurgeTrashReply) ([]NetStorageEntity, error) {
+	var purgedentities []NetStorageEntity;
+
+	// collect the deleted entities and all entities of deleted items
+	entityuuids, err := RetrieveDeletedObjects (db, deletionuuid, DELETIONTYPE_ENTITY);
+	if (err != nil) {
+		return purgedentities, err;
+	}
+	
+	statement1, err := db.Prepare ("SELECT uuid FROM netstorage_entities WHERE itemuuid IN (SELECT objectuuid FROM netstorage_deletedobjects WHERE deletionuuid=? AND objecttype=?)");
+	if (err != nil) {
+		return purgedentities, err;
+	}
+		
+	rows, err := statement1.Query(deletionuuid, DELETIONTYPE_ITEM);
+	if (err != nil) {
+		return purgedentities, err;
+	}
+	
+	for (rows.Next()) {
+		entityuuid := "";
+		err = rows.Scan (&entityuuid);
+		if (err != nil) {
+			rows.Close ();
+			return purgedentities, err;
+		}
+		
+		entityuuids = append (entityuuids, entityuuid);
+	}
+	rows.Close ();
+	
+	for _, entityuuid := range entityuuids {
+		entity, err := RetrieveEntityByUUID (db, entityuuid, false);
+		if (err == nil) {
+			purgedentities = append (purgedentities, entity);
+		}
+	}
+	
+	// derived entities are purged along, but not counted
+	result.EntityCount = result.EntityCount + len (purgedentities);
+	
+	purgedentities, err = purgeEntities (db, purgedentities);
+	if (err != nil) {
+		return purgedentities, err;
+	}
+	
+	statement2, err := db.Prepare ("DELETE FROM netstorage_search WHERE itemuuid IN (SELECT objectuuid FROM netstorage_deletedobjects WHERE deletionuuid=? AND objecttype=?)");
+	if (err != nil) {
+		return purgedentities, err;
+	}
+	
+	_, err = statement2.Exec(deletionuuid, DELETIONTYPE_ITEM);	
+	if (err != nil) {
+		return purgedentities, err;
+	}
+	
+	for _, table := range []string { "netstorage_itemtags", "netstorage_itemproperties", "netstorage_comments" } {
+		_, err = db.Exec ("DELETE FROM " + table + " WHERE itemuuid IN (SELECT objectuuid FROM netstorage_deletedobjects WHERE deletionuuid=? AND objecttype=?)", deletionuuid, DELETIONTYPE_ITEM);
+		if (err != nil) {
+			return purgedentities, err;
+		}
+	}
+	
+	tables := []string { "netstorage_items", "netstorage_folders", "netstorage_projects" };
+	objecttypes := []string { DELETIONTYPE_ITEM, DELETIONTYPE_FOLDER, DELETIONTYPE_PROJECT };
+	counts := []*int { &result.ItemCount, &result.FolderCount, &result.ProjectCount };
+	
+	for index, table := range tables {
+		statement3, err := db.Prepare ("DELETE FROM " + table + " WHERE active=0 AND uuid IN (SELECT objectuuid FROM netstorage_deletedobjects WHERE deletionuuid=? AND objecttype=?)");
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		dbresult, err := statement3.Exec(deletionuuid, objecttypes[index]);	
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		count, err := dbresult.RowsAffected();
+		if (err != nil) {
+			return purgedentities, err;
+		}
+		
+		*counts[index] = *counts[index] + int (count);
+	}
+	
+	result.DeletionCount = result.DeletionCount + 1;
+	
+	err = removeDeletion (db, deletionuuid);
+	if (err != nil) {
+		return purgedentities, err;
+	}
+	
+	// deletions whose objects have all been purged by this deletion are obsolete
+	statement4, err := db.Prepare ("DELETE FROM netstorage_deletions WHERE uuid NOT IN (SELECT deletionuuid FROM netstorage_deletedobjects)");
+	if (err != nil) {
+		return purgedentities, err;
+	}
+	
+	_, err = statement4.Exec();	
+	return purgedentities, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// purgeExpiredDeletions
+// permanently removes all deletions that are older than the retention period
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func purgeExpiredDeletions (db *sql.DB, session * NetStorageSession, retentiondays int) (NetStoragePurgeTrashReply, error) {
+	var result NetStoragePurgeTrashReply;
+
+	deletions, err := queryDeletions (db, "1=1");
+	if (err != nil) {
+		return result, err;
+	}
+	
+	expiry := time.Now().AddDate (0, 0, -retentiondays);
+	
+	for _, deletion := range deletions {
+		timestamp, err := time.Parse (time.RFC3339, deletion.TimeStamp);
+		if (err != nil) {
+			return result, err;
+		}
+		
+		if (timestamp.After (expiry)) {
+			continue;
+		}
+		
+		// an earlier purge might have made the deletion obsolete
+		_, err = RetrieveDeletionByUUID (db, deletion.UUID);
+		if (err != nil) {
+			continue;
+		}
+		
+		addLogMessage (session, fmt.Sprintf ("Purging %s %s (deletion %s)", deletion.ObjectType, deletion.ObjectUUID, deletion.UUID), LOGTYPE_DATA_PURGETRASH, LOGLEVEL_CONSOLE);	
+		
+		err = BeginTransaction (db);
+		if (err != nil) {
+			return result, err;
+		}
+		
+		entities, err := purgeDeletion (db, deletion.UUID, &result);
+		if (err != nil) {
+			RollbackTransaction (db);
+			return result, err;
+		}
+		
+		err = CommittTransaction (db);
+		if (err != nil) {
+			return result, err;
+		}
+		
+		for _, entity := range entities {
+			err = releaseEntityContent (db, entity);
+			if (err != nil) {
+				return result, err;
+			}
+		}
+	}
+	
+	return result, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageTrashHandler
+// handles a /projects/<uuid>/trash GET request and lists the deletions of a project
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageTrashHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, projectuuid string) error {
+	addLogMessage (session, "Retrieving trash of project: " + projectuuid, LOGTYPE_DATA_TRASH, LOGLEVEL_DBONLY);	
+	
+	options, err := parseListOptions (r);
+	if (err != nil) {
+		return err;
+	}
+	
+	deletions, page, err := RetrieveDeletionsOfProject (db, projectuuid, options);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageTrashReply;
+	reply.Protocol = PROTOCOL_TRASH;
+	reply.Version = PROTOCOL_VERSION;
+	reply.NetStorageListPage = page;
+	reply.Deletions = deletions;
+	if (reply.Deletions == nil) {
+		reply.Deletions = []NetStorageDeletion {};
+	}
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageTrashRestoreHandler
+// handles a /trash/<uuid>/restore POST request and reactivates all objects of a deletion
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageTrashRestoreHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, deletionuuid string) error {
+	addLogMessage (session, "Restoring deletion: " + deletionuuid, LOGTYPE_DATA_TRASH, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageRestoreDeletionRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_RESTOREDELETION);
+	if (err != nil) {
+		return err;
+	}
+	
+	deletion, err := RetrieveDeletionByUUID (db, deletionuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkDeletionRestorable (db, deletion);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = restoreDeletion (db, deletion.UUID);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = CommittTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageRestoreDeletionReply;
+	reply.Protocol = PROTOCOL_RESTOREDELETION;
+	reply.Version = PROTOCOL_VERSION;
+	reply.Deletion = deletion;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageTrashPurgeHandler
+// handles a /admin/purgetrash POST request and permanently removes all expired deletions
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageTrashPurgeHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
+	addLogMessage (session, "Purging trash", LOGTYPE_DATA_PURGETRASH, LOGLEVEL_CONSOLE);	
+	
+	err := checkAdministratorSession (session);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Parse JSON request
+	var request NetStoragePurgeTrashRequest;
+	err = parseJSONRequest (r, &request, PROTOCOL_PURGETRASH);
+	if (err != nil) {
+		return err;
+	}
+	
+	result, err := purgeExpiredDeletions (db, session, GlobalConfig.Trash.RetentionDays);
+	if (err != nil) {
+		return err;
+	}
+	
+	// Send reply JSON	
+	reply := result;
+	reply.Protocol = PROTOCOL_PURGETRASH;
+	reply.Version = PROTOCOL_VERSION;
+	reply.RetentionDays = GlobalConfig.Trash.RetentionDays;
+	return sendJSON (w, &reply);			
+}
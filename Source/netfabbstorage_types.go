@@ -54,6 +54,49 @@ const PROTOCOL_NEWFOLDER = "com.autodesk.netfabbstorage.newfolder"
 const PROTOCOL_NEWITEM = "com.autodesk.netfabbstorage.newitem"
 const PROTOCOL_NEWENTITY = "com.autodesk.netfabbstorage.newentity"
 const PROTOCOL_UPDATEENTITY = "com.autodesk.netfabbstorage.updateentity"
+const PROTOCOL_NEWUPLOAD = "com.autodesk.netfabbstorage.newupload"
+const PROTOCOL_UPLOADSTATUS = "com.autodesk.netfabbstorage.uploadstatus"
+const PROTOCOL_FINALIZEUPLOAD = "com.autodesk.netfabbstorage.finalizeupload"
+const PROTOCOL_ABORTUPLOAD = "com.autodesk.netfabbstorage.abortupload"
+const PROTOCOL_RENAMEPROJECT = "com.autodesk.netfabbstorage.renameproject"
+const PROTOCOL_RENAMEFOLDER = "com.autodesk.netfabbstorage.renamefolder"
+const PROTOCOL_RENAMEITEM = "com.autodesk.netfabbstorage.renameitem"
+const PROTOCOL_MOVEFOLDER = "com.autodesk.netfabbstorage.movefolder"
+const PROTOCOL_MOVEITEM = "com.autodesk.netfabbstorage.moveitem"
+const PROTOCOL_DELETEPROJECT = "com.autodesk.netfabbstorage.deleteproject"
+const PROTOCOL_DELETEFOLDER = "com.autodesk.netfabbstorage.deletefolder"
+const PROTOCOL_DELETEITEM = "com.autodesk.netfabbstorage.deleteitem"
+const PROTOCOL_DELETEENTITY = "com.autodesk.netfabbstorage.deleteentity"
+const PROTOCOL_TRASH = "com.autodesk.netfabbstorage.trash"
+const PROTOCOL_RESTOREDELETION = "com.autodesk.netfabbstorage.restoredeletion"
+const PROTOCOL_PURGETRASH = "com.autodesk.netfabbstorage.purgetrash"
+const PROTOCOL_FOLDERTREE = "com.autodesk.netfabbstorage.foldertree"
+const PROTOCOL_SEARCH = "com.autodesk.netfabbstorage.search"
+const PROTOCOL_NEWHUB = "com.autodesk.netfabbstorage.newhub"
+const PROTOCOL_RENAMEHUB = "com.autodesk.netfabbstorage.renamehub"
+const PROTOCOL_DEACTIVATEHUB = "com.autodesk.netfabbstorage.deactivatehub"
+const PROTOCOL_HUBSTATISTICS = "com.autodesk.netfabbstorage.hubstatistics"
+const PROTOCOL_SETCURRENTENTITY = "com.autodesk.netfabbstorage.setcurrententity"
+const PROTOCOL_CURRENTENTITY = "com.autodesk.netfabbstorage.currententity"
+const PROTOCOL_CHECKOUTITEM = "com.autodesk.netfabbstorage.checkoutitem"
+const PROTOCOL_CHECKINITEM = "com.autodesk.netfabbstorage.checkinitem"
+const PROTOCOL_BREAKITEMLOCK = "com.autodesk.netfabbstorage.breakitemlock"
+const PROTOCOL_SETQUOTA = "com.autodesk.netfabbstorage.setquota"
+const PROTOCOL_USAGE = "com.autodesk.netfabbstorage.usage"
+const PROTOCOL_COLLECTGARBAGE = "com.autodesk.netfabbstorage.collectgarbage"
+const PROTOCOL_SCRUB = "com.autodesk.netfabbstorage.scrub"
+const PROTOCOL_INTEGRITYFINDINGS = "com.autodesk.netfabbstorage.integrityfindings"
+const PROTOCOL_RENDERTHUMBNAIL = "com.autodesk.netfabbstorage.renderthumbnail"
+const PROTOCOL_BATCH = "com.autodesk.netfabbstorage.batch"
+const PROTOCOL_ITEMPROPERTIES = "com.autodesk.netfabbstorage.itemproperties"
+const PROTOCOL_SETITEMTAGS = "com.autodesk.netfabbstorage.setitemtags"
+const PROTOCOL_SETITEMPROPERTIES = "com.autodesk.netfabbstorage.setitemproperties"
+const PROTOCOL_PROJECTITEMS = "com.autodesk.netfabbstorage.projectitems"
+const PROTOCOL_COMMENTS = "com.autodesk.netfabbstorage.comments"
+const PROTOCOL_NEWCOMMENT = "com.autodesk.netfabbstorage.newcomment"
+const PROTOCOL_EDITCOMMENT = "com.autodesk.netfabbstorage.editcomment"
+const PROTOCOL_DELETECOMMENT = "com.autodesk.netfabbstorage.deletecomment"
+const PROTOCOL_CHANGES = "com.autodesk.netfabbstorage.changes"
 
 const PROTOCOL_ORMREAD = "com.autodesk.netfabborm.read"
 const PROTOCOL_ORMSAVE = "com.autodesk.netfabborm.save"
@@ -94,6 +137,18 @@ type NetStorageHub struct {
     Active int `json:"active"`
 }
 
+type NetStorageHubStatistics struct {
+    UUID string `json:"uuid"`
+    Name string `json:"name"`
+    Active int `json:"active"`
+	ProjectCount int `json:"projectcount"`
+	ItemCount int `json:"itemcount"`
+	EntityCount int `json:"entitycount"`
+	StorageUsed int64 `json:"storageused"`
+	BlobSize int64 `json:"blobsize"`
+	StoredSize int64 `json:"storedsize"`
+}
+
 type NetStorageProject struct {
     UUID string `json:"uuid"`
     HubUUID string `json:"hubuuid"`
@@ -115,6 +170,14 @@ type NetStorageItem struct {
     FolderUUID string `json:"folderuuid"`
     Name string `json:"name"`
     Active int `json:"active"`
+	Lock * NetStorageItemLock `json:"lock"`
+}
+
+type NetStorageItemLock struct {
+    UserID string `json:"userid"`
+	Note string `json:"note"`
+	TimeStamp string `json:"timestamp"`
+	Expiry string `json:"expiry"`
 }
 
 type NetStorageEntity struct {
@@ -126,6 +189,233 @@ type NetStorageEntity struct {
 	MetaData string `json:"metadata"`
 	TimeStamp string `json:"timestamp"`
     Active int `json:"active"`
+	VersionNumber int `json:"versionnumber"`
+    UserID string `json:"userid"`
+	Comment string `json:"comment"`
+	Current bool `json:"current"`
+}
+
+type NetStorageBlob struct {
+	SHA1 string `json:"sha1"`
+	FileSize int64 `json:"filesize"`
+	RefCount int `json:"refcount"`
+	Compression string `json:"compression"`
+	StoredSize int64 `json:"storedsize"`
+}
+
+type NetStorageUpload struct {
+    UUID string `json:"uuid"`
+    ItemUUID string `json:"itemuuid"`
+    UserID string `json:"userid"`
+	FileSize int64 `json:"filesize"`
+	HashOffset int64 `json:"hashoffset"`
+	HashState string `json:"-"`
+	Status string `json:"status"`
+	TimeStamp string `json:"timestamp"`
+}
+
+type NetStorageUploadRange struct {
+	Offset int64 `json:"offset"`
+	Size int64 `json:"size"`
+}
+
+type NetStorageDeletion struct {
+    UUID string `json:"uuid"`
+    ProjectUUID string `json:"projectuuid"`
+	ObjectType string `json:"objecttype"`
+    ObjectUUID string `json:"objectuuid"`
+	Name string `json:"name"`
+    UserID string `json:"userid"`
+	TimeStamp string `json:"timestamp"`
+	ObjectCount int `json:"objectcount"`
+}
+
+type NetStorageTreeItem struct {
+	NetStorageItem
+	CurrentEntity * NetStorageEntity `json:"currententity,omitempty"`
+}
+
+type NetStorageTreeFolder struct {
+	NetStorageFolder
+	Depth int `json:"depth"`
+	SubFolders []*NetStorageTreeFolder `json:"subfolders"`
+	Items []NetStorageTreeItem `json:"items"`
+}
+
+type NetStorageQuotaUsage struct {
+	ObjectType string `json:"objecttype"`
+    UUID string `json:"uuid"`
+    Name string `json:"name"`
+	MaxBytes int64 `json:"maxbytes"`
+	MaxEntities int64 `json:"maxentities"`
+	UsedBytes int64 `json:"usedbytes"`
+	UsedEntities int64 `json:"usedentities"`
+	IsDefault bool `json:"isdefault"`
+}
+
+type NetStorageGarbageFile struct {
+	Name string `json:"name"`
+	FileSize int64 `json:"filesize"`
+	TimeStamp string `json:"timestamp"`
+}
+
+type NetStorageIntegrityFinding struct {
+    EntityUUID string `json:"entityuuid"`
+    ItemUUID string `json:"itemuuid"`
+	StorageName string `json:"storagename"`
+	Problem string `json:"problem"`
+	ExpectedSHA1 string `json:"expectedsha1"`
+	ActualSHA1 string `json:"actualsha1"`
+	ExpectedSize int64 `json:"expectedsize"`
+	ActualSize int64 `json:"actualsize"`
+	Detected string `json:"detected"`
+	LastChecked string `json:"lastchecked"`
+}
+
+type NetStorageBoundingBox struct {
+	Min [3]float64 `json:"min"`
+	Max [3]float64 `json:"max"`
+}
+
+type NetStorageSTLGeometry struct {
+	Format string `json:"format"`
+	TriangleCount int `json:"trianglecount"`
+	VertexCount int `json:"vertexcount"`
+	BoundingBox NetStorageBoundingBox `json:"boundingbox"`
+	Size [3]float64 `json:"size"`
+	SurfaceArea float64 `json:"surfacearea"`
+	Volume float64 `json:"volume"`
+	Watertight * bool `json:"watertight"`
+	BoundaryEdges int `json:"boundaryedges"`
+	NonManifoldEdges int `json:"nonmanifoldedges"`
+}
+
+type NetStorage3MFObject struct {
+    ID string `json:"id"`
+    Name string `json:"name"`
+	Type string `json:"type"`
+	VertexCount int `json:"vertexcount"`
+	TriangleCount int `json:"trianglecount"`
+	ComponentCount int `json:"componentcount"`
+}
+
+type NetStorage3MFMaterial struct {
+    GroupID string `json:"groupid"`
+	Index int `json:"index"`
+    Name string `json:"name"`
+	DisplayColor string `json:"displaycolor"`
+}
+
+type NetStorage3MFPackage struct {
+	Unit string `json:"unit"`
+	MetaData map[string]string `json:"metadata"`
+	ObjectCount int `json:"objectcount"`
+	BuildItemCount int `json:"builditemcount"`
+	Objects []NetStorage3MFObject `json:"objects"`
+	Materials []NetStorage3MFMaterial `json:"materials"`
+	ModelPart string `json:"modelpart"`
+	ThumbnailPart string `json:"thumbnailpart"`
+	ThumbnailContentType string `json:"thumbnailcontenttype"`
+	Thumbnail []byte `json:"-"`
+}
+
+type NetStorageThumbnail struct {
+    EntityUUID string `json:"entityuuid"`
+    ThumbnailEntityUUID string `json:"thumbnailentityuuid"`
+	ContentType string `json:"contenttype"`
+	FileSize int64 `json:"filesize"`
+	Source string `json:"source"`
+	TimeStamp string `json:"timestamp"`
+}
+
+type NetStorageThumbnailMetaData struct {
+	Source string `json:"source"`
+}
+
+type NetStorageItemProperty struct {
+    Name string `json:"name"`
+	Type string `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type NetStorageComment struct {
+    UUID string `json:"uuid"`
+    ItemUUID string `json:"itemuuid"`
+    EntityUUID string `json:"entityuuid"`
+    ParentUUID string `json:"parentuuid"`
+	UserID string `json:"userid"`
+	Text string `json:"text"`
+	TimeStamp string `json:"timestamp"`
+	Edited string `json:"edited"`
+	Deleted bool `json:"deleted"`
+}
+
+type NetStorageChange struct {
+	Sequence int64 `json:"sequence"`
+	ObjectType string `json:"objecttype"`
+    ObjectUUID string `json:"objectuuid"`
+	Change string `json:"change"`
+    HubUUID string `json:"hubuuid"`
+    ProjectUUID string `json:"projectuuid"`
+    FolderUUID string `json:"folderuuid"`
+	TimeStamp string `json:"timestamp"`
+}
+
+type NetStorageListOptions struct {
+	Limit int
+	Cursor string
+	Sort string
+	Descending bool
+	NamePrefix string
+	DataType string
+}
+
+type NetStorageListPage struct {
+	Cursor string `json:"cursor"`
+	TotalCount int `json:"totalcount"`
+}
+
+type NetStorageBatchOperation struct {
+	Operation string `json:"operation"`
+	ID string `json:"id"`
+	FolderUUID string `json:"folderuuid"`
+	FolderName string `json:"foldername"`
+	ItemName string `json:"itemname"`
+	EntityUUID string `json:"entityuuid"`
+	DataType string `json:"datatype"`
+	MetaData json.RawMessage `json:"metadata"`
+	Comment string `json:"comment"`
+}
+
+type NetStorageBatchResult struct {
+	Index int `json:"index"`
+	Operation string `json:"operation"`
+	ID string `json:"id,omitempty"`
+	UUID string `json:"uuid"`
+	ProjectUUID string `json:"projectuuid"`
+	FolderUUID string `json:"folderuuid"`
+	ItemUUID string `json:"itemuuid,omitempty"`
+	VersionNumber int `json:"versionnumber,omitempty"`
+}
+
+type NetStorageMeshConversion struct {
+	TaskUUID string `json:"taskuuid"`
+	SourceEntityUUID string `json:"sourceentityuuid"`
+	SourceDataType string `json:"sourcedatatype"`
+	Format string `json:"format"`
+	Unit string `json:"unit,omitempty"`
+	TriangleCount int64 `json:"trianglecount"`
+}
+
+type NetStorageSearchHit struct {
+	MatchType string `json:"matchtype"`
+    ItemUUID string `json:"itemuuid"`
+	ItemName string `json:"itemname"`
+    EntityUUID string `json:"entityuuid,omitempty"`
+	DataType string `json:"datatype,omitempty"`
+    FolderUUID string `json:"folderuuid"`
+    ProjectUUID string `json:"projectuuid"`
+	Path string `json:"path"`
 }
 
 
@@ -180,6 +470,130 @@ type NetStorageUpdateEntityRequest struct {
 	NetStorageProtocolHeader
     DataType string `json:"datatype"`
 	MetaData json.RawMessage `json:"metadata"`
+	Comment string `json:"comment"`
+}
+
+type NetStorageRenameProjectRequest struct {
+	NetStorageProtocolHeader
+    ProjectName string `json:"projectname"`
+}
+
+type NetStorageRenameFolderRequest struct {
+	NetStorageProtocolHeader
+    FolderName string `json:"foldername"`
+}
+
+type NetStorageRenameItemRequest struct {
+	NetStorageProtocolHeader
+    ItemName string `json:"itemname"`
+}
+
+type NetStorageMoveFolderRequest struct {
+	NetStorageProtocolHeader
+    ParentUUID string `json:"parentuuid"`
+}
+
+type NetStorageMoveItemRequest struct {
+	NetStorageProtocolHeader
+    FolderUUID string `json:"folderuuid"`
+}
+
+type NetStorageNewUploadRequest struct {
+	NetStorageProtocolHeader
+	FileSize int64 `json:"filesize"`
+}
+
+type NetStorageFinalizeUploadRequest struct {
+	NetStorageProtocolHeader
+    DataType string `json:"datatype"`
+	MetaData json.RawMessage `json:"metadata"`
+	Comment string `json:"comment"`
+}
+
+type NetStorageRestoreDeletionRequest struct {
+	NetStorageProtocolHeader
+}
+
+type NetStoragePurgeTrashRequest struct {
+	NetStorageProtocolHeader
+}
+
+type NetStorageNewHubRequest struct {
+	NetStorageProtocolHeader
+    HubName string `json:"hubname"`
+}
+
+type NetStorageRenameHubRequest struct {
+	NetStorageProtocolHeader
+    HubName string `json:"hubname"`
+}
+
+type NetStorageSetQuotaRequest struct {
+	NetStorageProtocolHeader
+	MaxBytes int64 `json:"maxbytes"`
+	MaxEntities int64 `json:"maxentities"`
+	Reset bool `json:"reset"`
+}
+
+type NetStorageCollectGarbageRequest struct {
+	NetStorageProtocolHeader
+	DryRun bool `json:"dryrun"`
+	GracePeriod int `json:"graceperiod"`
+}
+
+type NetStorageScrubRequest struct {
+	NetStorageProtocolHeader
+}
+
+type NetStorageRenderThumbnailRequest struct {
+	NetStorageProtocolHeader
+	Size int `json:"size"`
+}
+
+type NetStorageBatchRequest struct {
+	NetStorageProtocolHeader
+	Operations []NetStorageBatchOperation `json:"operations"`
+}
+
+type NetStorageSetItemTagsRequest struct {
+	NetStorageProtocolHeader
+	AddTags []string `json:"addtags"`
+	RemoveTags []string `json:"removetags"`
+}
+
+type NetStorageSetItemPropertiesRequest struct {
+	NetStorageProtocolHeader
+	Properties []NetStorageItemProperty `json:"properties"`
+	RemoveProperties []string `json:"removeproperties"`
+}
+
+type NetStorageNewCommentRequest struct {
+	NetStorageProtocolHeader
+	ParentUUID string `json:"parentuuid"`
+	Text string `json:"text"`
+}
+
+type NetStorageEditCommentRequest struct {
+	NetStorageProtocolHeader
+	Text string `json:"text"`
+}
+
+type NetStorageSetCurrentEntityRequest struct {
+	NetStorageProtocolHeader
+}
+
+type NetStorageCheckOutItemRequest struct {
+	NetStorageProtocolHeader
+	Duration int `json:"duration"`
+	Note string `json:"note"`
+}
+
+type NetStorageCheckInItemRequest struct {
+	NetStorageProtocolHeader
+}
+
+type NetStorageBreakItemLockRequest struct {
+	NetStorageProtocolHeader
 }
 
 
@@ -304,6 +718,102 @@ func (request *NetStorageUpdateEntityRequest) GetHeader() NetStorageProtocolHead
   return request.NetStorageProtocolHeader;
 }
 
+func (request *NetStorageRenameProjectRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageRenameFolderRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageRenameItemRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageMoveFolderRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageMoveItemRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageNewUploadRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageFinalizeUploadRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageRestoreDeletionRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStoragePurgeTrashRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageNewHubRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageRenameHubRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageSetQuotaRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageCollectGarbageRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageScrubRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageRenderThumbnailRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageBatchRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageSetItemTagsRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageSetItemPropertiesRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageNewCommentRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageEditCommentRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageSetCurrentEntityRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageCheckOutItemRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageCheckInItemRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
+func (request *NetStorageBreakItemLockRequest) GetHeader() NetStorageProtocolHeader {
+  return request.NetStorageProtocolHeader;
+}
+
 func (request *NetORMReadRequest) GetHeader() NetStorageProtocolHeader {
   return request.NetStorageProtocolHeader;
 }
@@ -371,27 +881,32 @@ type NetStorageAuthenticateSessionReply struct {
 
 type NetStorageHubReply struct {
 	NetStorageProtocolHeader
+	NetStorageListPage
 	Hubs []NetStorageHub `json:"hubs"`
 }
 
 type NetStorageProjectsReply struct {
 	NetStorageProtocolHeader
+	NetStorageListPage
     HubUUID string `json:"hubuuid"`
 	Projects []NetStorageProject `json:"projects"`
 }
 
 type NetStorageFoldersReply struct {
 	NetStorageProtocolHeader
+	NetStorageListPage
 	Folders []NetStorageFolder `json:"folders"`
 }
 
 type NetStorageItemsReply struct {
 	NetStorageProtocolHeader
+	NetStorageListPage
 	Items []NetStorageItem `json:"items"`
 }
 
 type NetStorageEntitiesReply struct {
 	NetStorageProtocolHeader
+	NetStorageListPage
 	Entities []NetStorageEntity `json:"entities"`
 }
 
@@ -425,6 +940,205 @@ type NetStorageUpdateEntityReply struct {
 	NetStorageProtocolHeader
     ItemUUID string `json:"itemuuid"`
     EntityUUID string `json:"entityuuid"`
+	VersionNumber int `json:"versionnumber"`
+}
+
+type NetStorageRenameReply struct {
+	NetStorageProtocolHeader
+    UUID string `json:"uuid"`
+    Name string `json:"name"`
+}
+
+type NetStorageMoveFolderReply struct {
+	NetStorageProtocolHeader
+    FolderUUID string `json:"folderuuid"`
+    ProjectUUID string `json:"projectuuid"`
+    ParentUUID string `json:"parentuuid"`
+}
+
+type NetStorageMoveItemReply struct {
+	NetStorageProtocolHeader
+    ItemUUID string `json:"itemuuid"`
+    FolderUUID string `json:"folderuuid"`
+}
+
+type NetStorageDeleteReply struct {
+	NetStorageProtocolHeader
+    UUID string `json:"uuid"`
+    DeletionUUID string `json:"deletionuuid"`
+	FolderCount int `json:"foldercount"`
+	ItemCount int `json:"itemcount"`
+}
+
+type NetStorageNewUploadReply struct {
+	NetStorageProtocolHeader
+    ItemUUID string `json:"itemuuid"`
+    UploadUUID string `json:"uploaduuid"`
+	FileSize int64 `json:"filesize"`
+}
+
+type NetStorageUploadStatusReply struct {
+	NetStorageProtocolHeader
+    ItemUUID string `json:"itemuuid"`
+    UploadUUID string `json:"uploaduuid"`
+	Status string `json:"status"`
+	FileSize int64 `json:"filesize"`
+	ReceivedSize int64 `json:"receivedsize"`
+	Ranges []NetStorageUploadRange `json:"ranges"`
+}
+
+type NetStorageFinalizeUploadReply struct {
+	NetStorageProtocolHeader
+    ItemUUID string `json:"itemuuid"`
+    UploadUUID string `json:"uploaduuid"`
+    EntityUUID string `json:"entityuuid"`
+	VersionNumber int `json:"versionnumber"`
+	SHA1 string `json:"sha1"`
+	FileSize int64 `json:"filesize"`
+}
+
+type NetStorageAbortUploadReply struct {
+	NetStorageProtocolHeader
+    UploadUUID string `json:"uploaduuid"`
+}
+
+type NetStorageDeleteEntityReply struct {
+	NetStorageProtocolHeader
+    EntityUUID string `json:"entityuuid"`
+    DeletionUUID string `json:"deletionuuid"`
+}
+
+type NetStorageTrashReply struct {
+	NetStorageProtocolHeader
+	NetStorageListPage
+	Deletions []NetStorageDeletion `json:"deletions"`
+}
+
+type NetStorageRestoreDeletionReply struct {
+	NetStorageProtocolHeader
+	Deletion NetStorageDeletion `json:"deletion"`
+}
+
+type NetStoragePurgeTrashReply struct {
+	NetStorageProtocolHeader
+	RetentionDays int `json:"retentiondays"`
+	DeletionCount int `json:"deletioncount"`
+	ProjectCount int `json:"projectcount"`
+	FolderCount int `json:"foldercount"`
+	ItemCount int `json:"itemcount"`
+	EntityCount int `json:"entitycount"`
+}
+
+type NetStorageFolderTreeReply struct {
+	NetStorageProtocolHeader
+	MaxDepth int `json:"maxdepth"`
+	Folders []*NetStorageTreeFolder `json:"folders"`
+}
+
+type NetStorageNewHubReply struct {
+	NetStorageProtocolHeader
+    HubUUID string `json:"hubuuid"`
+    HubName string `json:"hubname"`
+}
+
+type NetStorageDeactivateHubReply struct {
+	NetStorageProtocolHeader
+    HubUUID string `json:"hubuuid"`
+}
+
+type NetStorageHubStatisticsReply struct {
+	NetStorageProtocolHeader
+	Hubs []NetStorageHubStatistics `json:"hubs"`
+}
+
+type NetStorageCurrentEntityReply struct {
+	NetStorageProtocolHeader
+    ItemUUID string `json:"itemuuid"`
+	Entity NetStorageEntity `json:"entity"`
+}
+
+type NetStorageItemLockReply struct {
+	NetStorageProtocolHeader
+    ItemUUID string `json:"itemuuid"`
+	Lock * NetStorageItemLock `json:"lock"`
+}
+
+type NetStorageUsageReply struct {
+	NetStorageProtocolHeader
+	Usage NetStorageQuotaUsage `json:"usage"`
+	Projects []NetStorageQuotaUsage `json:"projects"`
+}
+
+type NetStorageCollectGarbageReply struct {
+	NetStorageProtocolHeader
+	DryRun bool `json:"dryrun"`
+	GracePeriod int `json:"graceperiod"`
+	StaleEntities []NetStorageEntity `json:"staleentities"`
+	StaleUploads []NetStorageUpload `json:"staleuploads"`
+	UnreferencedFiles []NetStorageGarbageFile `json:"unreferencedfiles"`
+	ReleasedSize int64 `json:"releasedsize"`
+	PrunedChanges int `json:"prunedchanges"`
+}
+
+type NetStorageScrubReply struct {
+	NetStorageProtocolHeader
+	CheckedEntities int `json:"checkedentities"`
+	CheckedFiles int `json:"checkedfiles"`
+	CheckedSize int64 `json:"checkedsize"`
+	Findings []NetStorageIntegrityFinding `json:"findings"`
+}
+
+type NetStorageIntegrityFindingsReply struct {
+	NetStorageProtocolHeader
+	Findings []NetStorageIntegrityFinding `json:"findings"`
+}
+
+type NetStorageRenderThumbnailReply struct {
+	NetStorageProtocolHeader
+	Thumbnail NetStorageThumbnail `json:"thumbnail"`
+}
+
+type NetStorageBatchReply struct {
+	NetStorageProtocolHeader
+	Results []NetStorageBatchResult `json:"results"`
+	IDs map[string]string `json:"ids"`
+}
+
+type NetStorageItemPropertiesReply struct {
+	NetStorageProtocolHeader
+	ItemUUID string `json:"itemuuid"`
+	Tags []string `json:"tags"`
+	Properties []NetStorageItemProperty `json:"properties"`
+}
+
+type NetStorageCommentsReply struct {
+	NetStorageProtocolHeader
+	NetStorageListPage
+	Comments []NetStorageComment `json:"comments"`
+}
+
+type NetStorageCommentReply struct {
+	NetStorageProtocolHeader
+	Comment NetStorageComment `json:"comment"`
+}
+
+type NetStorageDeleteCommentReply struct {
+	NetStorageProtocolHeader
+    CommentUUID string `json:"commentuuid"`
+	Removed bool `json:"removed"`
+}
+
+type NetStorageChangesReply struct {
+	NetStorageProtocolHeader
+	Cursor string `json:"cursor"`
+	HasMore bool `json:"hasmore"`
+	Changes []NetStorageChange `json:"changes"`
+}
+
+type NetStorageSearchReply struct {
+	NetStorageProtocolHeader
+	Query string `json:"query"`
+	Hits []NetStorageSearchHit `json:"hits"`
 }
 
 
@@ -0,0 +1,729 @@
+/*++
+
+Copyright (C) 2015 Autodesk Inc. (Original Author)
+
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+this list of conditions and the following disclaimer in the documentation
+and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+--*/
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// netfabbstorage_uploads.go
+// Handles resumable chunked uploads. An upload session collects chunks in a .part file in the data 
+// directory, hashes them as soon as they are contiguous and moves the file into the blob store on 
+// finalize.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"net/http"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"errors"
+	"encoding"
+	"encoding/base64"
+	"database/sql"
+	"crypto/sha1"
+	"hash"
+)
+
+
+const UPLOADSTATUS_NEW = "NEW";
+const UPLOADSTATUS_FINALIZED = "FINALIZED";
+const UPLOADSTATUS_ABORTED = "ABORTED";
+
+// Serializes the SHA1 state updates of concurrently arriving chunks
+var UploadHashMutex sync.Mutex;
+
+// Per upload locks, chunks are written under the read lock and hashed under the write lock, so that 
+// bytes cannot change anymore once they have been hashed
+var UploadWriteLocks sync.Map;
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// parseContentRange
+// parses a "bytes <first>-<last>/<total>" Content-Range header
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func parseContentRange (header string) (int64, int64, int64, error) {
+	var first, last, total int64;
+	
+	_, err := fmt.Sscanf (header, "bytes %d-%d/%d", &first, &last, &total);
+	if (err != nil) {
+		return 0, 0, 0, errors.New ("Invalid Content-Range header: " + header);
+	}
+	
+	if ((first < 0) || (last < first) || (last >= total)) {
+		return 0, 0, 0, errors.New ("Invalid Content-Range header: " + header);
+	}
+	
+	return first, last, total, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// restoreUploadHash
+// restores the incremental SHA1 state of an upload
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func restoreUploadHash (upload NetStorageUpload) (hash.Hash, error) {
+	hasher := sha1.New ();
+	
+	if (upload.HashState != "") {
+		state, err := base64.StdEncoding.DecodeString (upload.HashState);
+		if (err != nil) {
+			return nil, err;
+		}
+		
+		err = hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary (state);
+		if (err != nil) {
+			return nil, err;
+		}
+	}
+	
+	return hasher, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// getUploadWriteLock
+// returns the lock that guards the .part file of an upload
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func getUploadWriteLock (uploaduuid string) (*sync.RWMutex) {
+	lock, _ := UploadWriteLocks.LoadOrStore (uploaduuid, &sync.RWMutex {});
+	return lock.(*sync.RWMutex);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// checkUploadOwner
+// checks that an upload session has been initiated by the user of the session
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func checkUploadOwner (upload NetStorageUpload, session * NetStorageSession) (error) {
+
+	if (upload.UserID != session.UserID) {
+		return errors.New ("upload belongs to another user: " + upload.UUID);
+	}
+	
+	return nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveUploadByUUID
+// retrieves an upload session by uuid
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveUploadByUUID (db *sql.DB, uploaduuid string) (NetStorageUpload, error) {
+	var upload NetStorageUpload;
+	
+	statement, err := db.Prepare ("SELECT uuid, itemuuid, userid, filesize, hashoffset, hashstate, status, timestamp FROM netstorage_uploads WHERE uuid=?");
+	if (err != nil) {
+		return upload, err;
+	}
+		
+	rows, err := statement.Query(uploaduuid);
+	if (err != nil) {
+		return upload, err;
+	}
+	
+	defer rows.Close();
+
+	if (!rows.Next()) {
+		return upload, errors.New("upload not found: " + uploaduuid);		
+	}	
+	
+	err = rows.Scan (&upload.UUID, &upload.ItemUUID, &upload.UserID, &upload.FileSize, &upload.HashOffset, &upload.HashState, &upload.Status, &upload.TimeStamp);
+	
+	return upload, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// RetrieveUploadRanges
+// retrieves the received byte ranges of an upload, merged and ordered by offset
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func RetrieveUploadRanges (db *sql.DB, uploaduuid string) ([]NetStorageUploadRange, error) {
+	ranges := make([] NetStorageUploadRange, 0);
+	
+	statement, err := db.Prepare ("SELECT chunkoffset, chunksize FROM netstorage_uploadchunks WHERE uploaduuid=? ORDER BY chunkoffset");
+	if (err != nil) {
+		return ranges, err;
+	}
+		
+	rows, err := statement.Query(uploaduuid);
+	if (err != nil) {
+		return ranges, err;
+	}
+	
+	defer rows.Close();
+
+	for (rows.Next()) {
+		var chunk NetStorageUploadRange;
+		err = rows.Scan (&chunk.Offset, &chunk.Size);
+		if (err != nil) {
+			return ranges, err;
+		}
+		
+		// merge overlapping and adjacent chunks
+		count := len (ranges);
+		if ((count > 0) && (chunk.Offset <= ranges[count - 1].Offset + ranges[count - 1].Size)) {
+			end := chunk.Offset + chunk.Size;
+			if (end > ranges[count - 1].Offset + ranges[count - 1].Size) {
+				ranges[count - 1].Size = end - ranges[count - 1].Offset;
+			}
+		} else {
+			ranges = append (ranges, chunk);
+		}
+	}
+	
+	return ranges, nil;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// createNewUpload
+// creates a new upload session DB entry
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func createNewUpload (db *sql.DB, uploaduuid string, itemuuid string, userid string, filesize int64) (error) {
+
+	timestamp := time.Now().Format(time.RFC3339);
+	
+	statement, err := db.Prepare ("INSERT INTO netstorage_uploads (uuid, itemuuid, userid, filesize, hashoffset, hashstate, status, timestamp) VALUES (?, ?, ?, ?, 0, '', ?, ?)");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(uploaduuid, itemuuid, userid, filesize, UPLOADSTATUS_NEW, timestamp);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// addUploadChunk
+// records a received chunk of an upload session
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func addUploadChunk (db *sql.DB, uploaduuid string, offset int64, size int64) (error) {
+
+	statement, err := db.Prepare ("INSERT INTO netstorage_uploadchunks (uploaduuid, chunkoffset, chunksize) VALUES (?, ?, ?)");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement.Exec(uploaduuid, offset, size);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// updateUploadStatus
+// sets the status of an upload session and drops its chunk records. Closed uploads do not accept 
+// chunks anymore, so their write lock is released.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func updateUploadStatus (db *sql.DB, uploaduuid string, status string) (error) {
+
+	if (status != UPLOADSTATUS_NEW) {
+		UploadWriteLocks.Delete (uploaduuid);
+	}
+
+	statement1, err := db.Prepare ("UPDATE netstorage_uploads SET status=? WHERE uuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement1.Exec(status, uploaduuid);	
+	if (err != nil) {
+		return err;
+	}
+
+	statement2, err := db.Prepare ("DELETE FROM netstorage_uploadchunks WHERE uploaduuid=?");
+	if (err != nil) {
+		return err;
+	}
+	
+	_, err = statement2.Exec(uploaduuid);	
+	return err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// advanceUploadHash
+// feeds all contiguously received bytes after the current hash offset into the SHA1 state of an 
+// upload and stores the new state. Returns the updated upload.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func advanceUploadHash (db *sql.DB, uploaduuid string) (NetStorageUpload, error) {
+
+	writelock := getUploadWriteLock (uploaduuid);
+	writelock.Lock();
+	defer writelock.Unlock();
+
+	UploadHashMutex.Lock();
+	defer UploadHashMutex.Unlock();
+
+	upload, err := RetrieveUploadByUUID (db, uploaduuid);
+	if (err != nil) {
+		return upload, err;
+	}
+	
+	ranges, err := RetrieveUploadRanges (db, uploaduuid);
+	if (err != nil) {
+		return upload, err;
+	}
+	
+	hashend := upload.HashOffset;
+	for _, chunk := range ranges {
+		if ((chunk.Offset <= hashend) && (chunk.Offset + chunk.Size > hashend)) {
+			hashend = chunk.Offset + chunk.Size;
+		}
+	}
+	
+	if (hashend == upload.HashOffset) {
+		return upload, nil;
+	}
+	
+	hasher, err := restoreUploadHash (upload);
+	if (err != nil) {
+		return upload, err;
+	}
+	
+	file, err := os.Open (getUploadStorageName (upload.UUID));
+	if (err != nil) {
+		return upload, err;
+	}
+	defer file.Close();
+	
+	_, err = file.Seek (upload.HashOffset, io.SeekStart);
+	if (err != nil) {
+		return upload, err;
+	}
+	
+	_, err = io.CopyN (hasher, file, hashend - upload.HashOffset);
+	if (err != nil) {
+		return upload, err;
+	}
+	
+	state, err := hasher.(encoding.BinaryMarshaler).MarshalBinary ();
+	if (err != nil) {
+		return upload, err;
+	}
+	
+	upload.HashOffset = hashend;
+	upload.HashState = base64.StdEncoding.EncodeToString (state);
+	
+	statement, err := db.Prepare ("UPDATE netstorage_uploads SET hashoffset=?, hashstate=? WHERE uuid=?");
+	if (err != nil) {
+		return upload, err;
+	}
+	
+	_, err = statement.Exec(upload.HashOffset, upload.HashState, upload.UUID);	
+	return upload, err;
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// sendUploadStatus
+// sends the status and the received ranges of an upload
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func sendUploadStatus (db *sql.DB, w http.ResponseWriter, upload NetStorageUpload) error {
+
+	ranges, err := RetrieveUploadRanges (db, upload.UUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	var receivedsize int64 = 0;
+	for _, chunk := range ranges {
+		receivedsize = receivedsize + chunk.Size;
+	}
+
+	var reply NetStorageUploadStatusReply;
+	reply.Protocol = PROTOCOL_UPLOADSTATUS;
+	reply.Version = PROTOCOL_VERSION;
+	reply.ItemUUID = upload.ItemUUID;
+	reply.UploadUUID = upload.UUID;
+	reply.Status = upload.Status;
+	reply.FileSize = upload.FileSize;
+	reply.ReceivedSize = receivedsize;
+	reply.Ranges = ranges;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageUploadNewHandler
+// handles a /items/<uuid>/newupload POST request and initiates a chunked upload session for the item.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageUploadNewHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, itemuuid string) error {
+	addLogMessage (session, "Initiating upload for item: " + itemuuid, LOGTYPE_DATA_NEWUPLOAD, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageNewUploadRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_NEWUPLOAD);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (request.FileSize < 0) {
+		return errors.New ("Invalid upload file size");
+	}
+	
+	item, err := RetrieveItemByUUID (db, itemuuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkItemLock (item, session.UserID);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkQuotas (db, item, request.FileSize);
+	if (err != nil) {
+		return err;
+	}
+	
+	uploaduuid := createUUID ();
+	
+	// create empty part file on disk
+	file, err := os.Create(getUploadStorageName (uploaduuid));
+	if (err != nil) {
+		return err;
+	}	
+	file.Close();
+	
+	err = createNewUpload (db, uploaduuid, item.UUID, session.UserID, request.FileSize);
+	if (err != nil) {
+		os.Remove (getUploadStorageName (uploaduuid));
+		return err;
+	}
+	
+	// Send reply JSON	
+	var reply NetStorageNewUploadReply;
+	reply.Protocol = PROTOCOL_NEWUPLOAD;
+	reply.Version = PROTOCOL_VERSION;
+	reply.ItemUUID = item.UUID;
+	reply.UploadUUID = uploaduuid;
+	reply.FileSize = request.FileSize;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageUploadChunkHandler
+// handles a /uploads/<uuid> PUT request. The body is written to the offset given by the 
+// Content-Range header and the received ranges are returned.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageUploadChunkHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, uploaduuid string) error {
+	
+	upload, err := RetrieveUploadByUUID (db, uploaduuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkUploadOwner (upload, session);
+	if (err != nil) {
+		return err;
+	}
+	
+	first, last, total, err := parseContentRange (r.Header.Get ("Content-Range"));
+	if (err != nil) {
+		return err;
+	}
+	
+	if (total != upload.FileSize) {
+		return errors.New (fmt.Sprintf ("Content-Range size %d does not match upload size %d", total, upload.FileSize));
+	}
+	
+	addLogMessage (session, fmt.Sprintf ("Receiving bytes %d-%d of upload %s", first, last, uploaduuid), LOGTYPE_DATA_UPLOADCHUNK, LOGLEVEL_DBONLY);	
+	
+	err = writeUploadChunk (db, uploaduuid, first, last, r.Body);
+	if (err != nil) {
+		return err;
+	}
+	
+	upload, err = advanceUploadHash (db, upload.UUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	return sendUploadStatus (db, w, upload);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// writeUploadChunk
+// writes a chunk into the .part file of an open upload and records it. Bytes that have already been 
+// hashed must not be written again, as the SHA1 sum of the upload would not match its content.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func writeUploadChunk (db *sql.DB, uploaduuid string, first int64, last int64, body io.Reader) (error) {
+
+	writelock := getUploadWriteLock (uploaduuid);
+	writelock.RLock();
+	defer writelock.RUnlock();
+
+	// the upload is retrieved again under the lock, it might have been hashed or closed in the meantime
+	upload, err := RetrieveUploadByUUID (db, uploaduuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (upload.Status != UPLOADSTATUS_NEW) {
+		return errors.New ("upload is not open: " + uploaduuid);
+	}
+	
+	if (first < upload.HashOffset) {
+		return errors.New (fmt.Sprintf ("bytes %d-%d of upload %s have already been received and cannot be overwritten", first, upload.HashOffset - 1, uploaduuid));
+	}
+	
+	file, err := os.OpenFile (getUploadStorageName (upload.UUID), os.O_WRONLY, 0);
+	if (err != nil) {
+		return err;
+	}	
+	defer file.Close();
+	
+	_, err = file.Seek (first, io.SeekStart);
+	if (err != nil) {
+		return err;
+	}
+	
+	// stream chunk to disk
+	_, err = io.CopyN (file, body, last - first + 1);
+	if (err != nil) {
+		return errors.New ("Could not receive chunk: " + err.Error ());
+	}
+	
+	err = file.Sync ();
+	if (err != nil) {
+		return err;
+	}
+	
+	return addUploadChunk (db, upload.UUID, first, last - first + 1);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageUploadStatusHandler
+// handles a /uploads/<uuid> GET request and returns the received ranges of an upload
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageUploadStatusHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, uploaduuid string) error {
+	addLogMessage (session, "Retrieving status of upload: " + uploaduuid, LOGTYPE_DATA_UPLOADSTATUS, LOGLEVEL_DBONLY);	
+	
+	upload, err := RetrieveUploadByUUID (db, uploaduuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkUploadOwner (upload, session);
+	if (err != nil) {
+		return err;
+	}
+	
+	return sendUploadStatus (db, w, upload);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageUploadFinalizeHandler
+// handles a /uploads/<uuid>/finalize POST request. Checks that all bytes have been received and 
+// creates the active entity for the uploaded file.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageUploadFinalizeHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, uploaduuid string) error {
+	addLogMessage (session, "Finalizing upload: " + uploaduuid, LOGTYPE_DATA_FINALIZEUPLOAD, LOGLEVEL_CONSOLE);	
+	
+	// Parse JSON request
+	var request NetStorageFinalizeUploadRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_FINALIZEUPLOAD);
+	if (err != nil) {
+		return err;
+	}
+	
+	upload, err := RetrieveUploadByUUID (db, uploaduuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkUploadOwner (upload, session);
+	if (err != nil) {
+		return err;
+	}
+	
+	upload, err = advanceUploadHash (db, uploaduuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (upload.Status != UPLOADSTATUS_NEW) {
+		return errors.New ("upload is not open: " + uploaduuid);
+	}
+	
+	if (upload.HashOffset != upload.FileSize) {
+		return errors.New (fmt.Sprintf ("upload is incomplete: %d of %d bytes received", upload.HashOffset, upload.FileSize));
+	}
+	
+	item, err := RetrieveItemByUUID (db, upload.ItemUUID);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkItemLock (item, session.UserID);
+	if (err != nil) {
+		return err;
+	}
+	
+	hasher, err := restoreUploadHash (upload);
+	if (err != nil) {
+		return err;
+	}
+	sha1sum := fmt.Sprintf("%x", hasher.Sum (nil));		
+	
+	entityuuid := createUUID ();
+	
+	err = BeginTransaction (db);
+	if (err != nil) {
+		return err;
+	}
+	
+	// other uploads may have been finalized since the upload was initiated
+	err = checkQuotas (db, item, upload.FileSize);
+	if (err == nil) {
+		err = createNewEntity (db, entityuuid, item.UUID, sha1sum, upload.FileSize, upload.UserID, true);
+	}
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = updateEntity (db, entityuuid, request.DataType, string (request.MetaData), true);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = updateEntityComment (db, entityuuid, request.Comment);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	entity, err := RetrieveEntityByUUID (db, entityuuid, true);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = updateUploadStatus (db, upload.UUID, UPLOADSTATUS_FINALIZED);
+	if (err != nil) {
+		RollbackTransaction (db);
+		return err;
+	}
+	
+	err = storeBlob (db, getUploadStorageName (upload.UUID), sha1sum, upload.FileSize);
+	if (err == nil) {
+		err = CommittTransaction (db);
+	}
+	if (err != nil) {
+		RollbackTransaction (db);
+		discardOrphanBlob (db, sha1sum);
+		return err;
+	}
+	
+	queueEntityAnalysis (db, session, entityuuid);
+	
+	// Send reply JSON	
+	var reply NetStorageFinalizeUploadReply;
+	reply.Protocol = PROTOCOL_FINALIZEUPLOAD;
+	reply.Version = PROTOCOL_VERSION;
+	reply.ItemUUID = item.UUID;
+	reply.UploadUUID = upload.UUID;
+	reply.EntityUUID = entityuuid;
+	reply.VersionNumber = entity.VersionNumber;
+	reply.SHA1 = sha1sum;
+	reply.FileSize = upload.FileSize;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// StorageUploadAbortHandler
+// handles a /uploads/<uuid> DELETE request and discards an open upload
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func StorageUploadAbortHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, uploaduuid string) error {
+	addLogMessage (session, "Aborting upload: " + uploaduuid, LOGTYPE_DATA_ABORTUPLOAD, LOGLEVEL_CONSOLE);	
+	
+	upload, err := RetrieveUploadByUUID (db, uploaduuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = checkUploadOwner (upload, session);
+	if (err != nil) {
+		return err;
+	}
+	
+	// waits for chunks that are being written
+	writelock := getUploadWriteLock (uploaduuid);
+	writelock.Lock();
+	defer writelock.Unlock();
+	
+	upload, err = RetrieveUploadByUUID (db, uploaduuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	if (upload.Status != UPLOADSTATUS_NEW) {
+		return errors.New ("upload is not open: " + uploaduuid);
+	}
+	
+	err = updateUploadStatus (db, upload.UUID, UPLOADSTATUS_ABORTED);
+	if (err != nil) {
+		return err;
+	}
+	
+	err = os.Remove (getUploadStorageName (upload.UUID));
+	if (err != nil) {
+		return err;
+	}
+	
+	var reply NetStorageAbortUploadReply;
+	reply.Protocol = PROTOCOL_ABORTUPLOAD;
+	reply.Version = PROTOCOL_VERSION;
+	reply.UploadUUID = upload.UUID;
+	return sendJSON (w, &reply);			
+}
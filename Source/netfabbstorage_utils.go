@@ -46,6 +46,7 @@ import (
 	"io/ioutil"
 	"os"
 	"fmt"
+	"strings"
 	"github.com/twinj/uuid"	
 	_ "github.com/mattn/go-sqlite3"	
 )
@@ -294,6 +295,54 @@ func sendJSONPostRequest (url string, desiredprotocol string, requeststruct inte
 	
 }
 
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// getDataTypeContentType
+// maps an entity datatype to the Content-Type of a download
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func getDataTypeContentType (datatype string) string {
+
+	// datatypes may already be given as MIME types
+	if (strings.Contains (datatype, "/")) {
+		return datatype;
+	}
+
+	switch (strings.ToLower (datatype)) {
+		case "stl":
+			return "model/stl";
+		case "3mf":
+			return "model/3mf";
+		case "obj":
+			return "model/obj";
+		case "png":
+			return "image/png";
+		case "jpg", "jpeg":
+			return "image/jpeg";
+		case "json":
+			return "application/json";
+		case "xml":
+			return "application/xml";
+		case "txt":
+			return "text/plain";
+		case "zip":
+			return "application/zip";
+		default:
+			return "application/octet-stream";
+	}
+}
+
+
+// Storage names are relative to the root of the storage backend
 func getUUIDStorageName (uuid string) string {
-	return path.Join (GlobalConfig.Data.Directory, uuid + ".dat");
+	return uuid + ".dat";
+}
+
+func getBlobStorageName (sha1sum string) string {
+	return path.Join ("blobs", sha1sum[0:2], sha1sum + ".dat");
+}
+
+// Uploads are staged in the local data directory
+
+func getUploadStorageName (uuid string) string {
+	return path.Join (GlobalConfig.Data.Directory, uuid + ".part");
 }
@@ -60,6 +60,14 @@ func TaskNewHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWri
 		return err;
 	}		
 	
+	// built-in tasks check their parameters before they are queued
+	if (request.Name == TASKNAME_MESHCONVERSION) {
+		request.Parameters, err = checkMeshConversionParameters (db, session, request.Parameters);
+		if (err != nil) {
+			return err;
+		}
+	}
+	
 	
 	jsondata, err := json.Marshal (&request.Parameters);
 	if (err != nil) {
@@ -68,8 +76,6 @@ func TaskNewHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWri
 	
 	parameters := string (jsondata);	
 	
-	timestamp := time.Now().Format(time.RFC3339);
-	status := "NEW";
 	taskname := request.Name;
 	
 	if (taskname == "") {
@@ -78,14 +84,7 @@ func TaskNewHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWri
 	
 	addLogMessage (session, fmt.Sprintf ("Parameters: %s", parameters), LOGTYPE_TASK_NEW, LOGLEVEL_DBONLY);		
 		
-	query := fmt.Sprintf ("INSERT INTO netstorage_tasks (uuid, taskname, status, parameters, timestamp, transactionuuid) VALUES (?, ?, ?, ?, ?, ?)");
-		
-	statement, err := db.Prepare (query);
-	if (err != nil) {
-		return err;
-	}
-	
-	_, err = statement.Exec (uuid, taskname, status, parameters, timestamp, uuid);
+	err = createNewTask (db, uuid, taskname, parameters);
 	if (err != nil) {
 		return err;
 	}
@@ -132,6 +131,11 @@ func TaskClearHandler (db *sql.DB, session * NetStorageSession, w http.ResponseW
 	if (err != nil) {
 		return err;
 	}
+	
+	err = recordTransactionTaskChanges (db, transactionUUID);
+	if (err != nil) {
+		return err;
+	}
 
 		
 	var reply NetTaskClearReply;
@@ -143,57 +147,129 @@ func TaskClearHandler (db *sql.DB, session * NetStorageSession, w http.ResponseW
 
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
-// Handle a task and mark it "INPROCESS"
+// Queues a new task with JSON encoded parameters
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func TaskHandleHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
+func createNewTask (db *sql.DB, uuid string, taskname string, parameters string) error {
 
-	transactionUUID := createUUID ();
-	workerSecret := transactionUUID;
+	timestamp := time.Now().Format(time.RFC3339);
+	status := "NEW";
+	
+	query := fmt.Sprintf ("INSERT INTO netstorage_tasks (uuid, taskname, status, parameters, timestamp, transactionuuid) VALUES (?, ?, ?, ?, ?, ?)");
+		
+	statement, err := db.Prepare (query);
+	if (err != nil) {
+		return err;
+	}
+	
+	defer statement.Close();
+	
+	_, err = statement.Exec (uuid, taskname, status, parameters, timestamp, uuid);
+	if (err != nil) {
+		return err;
+	}
+	
+	return recordChange (db, CHANGEOBJECT_TASK, uuid, CHANGETYPE_CREATE);
+}
 
-	addLogMessage (session, fmt.Sprintf ("handling task (transaction UUID: %s)", transactionUUID), LOGTYPE_TASK_HANDLE, LOGLEVEL_DBONLY);	
 
-	// Parse JSON request
-	var request NetTaskHandleRequest;
-	err := parseJSONRequest (r, &request, PROTOCOL_TASKHANDLE);
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// Returns the tasks of a built-in worker to the queue that were in process when the server stopped. 
+// The server is their only worker, so none of them can still be running.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func requeueInterruptedTasks (db *sql.DB, taskname string, worker string) (int, error) {
+
+	statement, err := db.Prepare ("SELECT uuid FROM netstorage_tasks WHERE taskname=? AND worker=? AND status=?");
 	if (err != nil) {
-		return err;
-	}		
+		return 0, err;
+	}
+	defer statement.Close ();
+	
+	rows, err := statement.Query (taskname, worker, "INPROCESS");
+	if (err != nil) {
+		return 0, err;
+	}
+	
+	var taskuuids []string;
+	for (rows.Next ()) {
+		var taskuuid string;
+		err = rows.Scan (&taskuuid);
+		if (err != nil) {
+			rows.Close ();
+			return 0, err;
+		}
+		taskuuids = append (taskuuids, taskuuid);
+	}
+	rows.Close ();
+	
+	update, err := db.Prepare ("UPDATE netstorage_tasks SET status=?, transactionuuid=?, workersecret='' WHERE uuid=? AND status=?");
+	if (err != nil) {
+		return 0, err;
+	}
+	defer update.Close ();
+	
+	for _, taskuuid := range taskuuids {
+		_, err = update.Exec ("RETURNED", createUUID (), taskuuid, "INPROCESS");
+		if (err != nil) {
+			return 0, err;
+		}
+		
+		err = recordChange (db, CHANGEOBJECT_TASK, taskuuid, CHANGETYPE_UPDATE);
+		if (err != nil) {
+			return 0, err;
+		}
+	}
+	
+	return len (taskuuids), nil;
+}
 
-	worker := request.Worker;
-	taskname := request.Name;
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// Locks the latest open task of a name for a worker and marks it "INPROCESS". Returns an empty UUID 
+// if there is no task in the queue.
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func handleTask (db *sql.DB, session * NetStorageSession, taskname string, worker string) (NetTaskHandleReply, error) {
+
+	var reply NetTaskHandleReply;
+
+	transactionUUID := createUUID ();
+	workerSecret := transactionUUID;
+
+	addLogMessage (session, fmt.Sprintf ("handling task (transaction UUID: %s)", transactionUUID), LOGTYPE_TASK_HANDLE, LOGLEVEL_DBONLY);	
 	addLogMessage (session, fmt.Sprintf ("Task name: %s, worker: %s", taskname, worker), LOGTYPE_TASK_HANDLE, LOGLEVEL_DBONLY);	
 	
 	if (taskname == "") {
-		return errors.New ("Invalid task name");
+		return reply, errors.New ("Invalid task name");
 	}
 
 	query := fmt.Sprintf ("UPDATE netstorage_tasks SET status=?, transactionuuid=?, worker=?, workersecret=? WHERE uuid IN (SELECT uuid FROM netstorage_tasks WHERE (status=? or status=?) AND taskname=? ORDER BY timestamp DESC LIMIT 1)");
 		
 	statement1, err := db.Prepare (query);
 	if (err != nil) {
-		return err;
+		return reply, err;
 	}
 	
 	defer statement1.Close();
 	
 	_, err = statement1.Exec ("INPROCESS", transactionUUID, worker, workerSecret, "NEW", "RETURNED", taskname);
 	if (err != nil) {
-		return err;
+		return reply, err;
 	}
 	
 	query = fmt.Sprintf ("SELECT uuid, taskname, parameters FROM netstorage_tasks WHERE transactionuuid=?");
 		
 	statement2, err := db.Prepare (query);
 	if (err != nil) {
-		return err;
+		return reply, err;
 	}
 
 	defer statement2.Close();
 	
 	rows, err := statement2.Query (transactionUUID);
 	if (err != nil) {
-		return err;
+		return reply, err;
 	}
 	
 	defer rows.Close();
@@ -204,11 +280,11 @@ func TaskHandleHandler (db *sql.DB, session * NetStorageSession, w http.Response
 	if (rows.Next()) {
 		err = rows.Scan (&resultuuid, &taskname, &parameters);
 		if (err != nil) {
-			return err;
+			return reply, err;
 		}
 	
 		if (rows.Next()) {
-			return errors.New("Duplicate tasks locked in request!");		
+			return reply, errors.New("Duplicate tasks locked in request!");		
 		}			
 		addLogMessage (session, fmt.Sprintf ("Task retrieved: taskname %s, resultuuid: %s", taskname, resultuuid), LOGTYPE_TASK_HANDLE, LOGLEVEL_CONSOLE);		
 		addLogMessage (session, fmt.Sprintf ("  Parameters: %s", parameters), LOGTYPE_TASK_HANDLE, LOGLEVEL_DBONLY);		
@@ -217,48 +293,67 @@ func TaskHandleHandler (db *sql.DB, session * NetStorageSession, w http.Response
 		addLogMessage (session, fmt.Sprintf ("  no task in queue"), LOGTYPE_TASK_HANDLE, LOGLEVEL_DBONLY);		
 	}
 	
-
-	var reply NetTaskHandleReply;
-	reply.Protocol = PROTOCOL_TASKHANDLE;
-	reply.Version = PROTOCOL_VERSION;
+	rows.Close();
+	
+	if (resultuuid != "") {
+		err = recordChange (db, CHANGEOBJECT_TASK, resultuuid, CHANGETYPE_UPDATE);
+		if (err != nil) {
+			return reply, err;
+		}
+	}
+	
 	reply.UUID = resultuuid;
 	reply.Name = taskname;
 	
 	if (parameters != "") {	
 		err = json.Unmarshal ([]byte (parameters), &reply.Parameters);
 		if (err != nil) {
-			return err;
+			return reply, err;
 		}
 	}
 	
 	reply.WorkerSecret = workerSecret;
-	return sendJSON (w, &reply);			
+	return reply, nil;
 }
 
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
-// Updates the status of a task
+// Handle a task and mark it "INPROCESS"
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func TaskUpdateHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, uuid string) error {
-
-	transactionUUID := createUUID ();
-
-	addLogMessage (session, fmt.Sprintf ("Updating task %s (transaction %s)", uuid, transactionUUID), LOGTYPE_TASK_HANDLE, LOGLEVEL_DBONLY);	
+func TaskHandleHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request) error {
 
 	// Parse JSON request
-	var request NetTaskUpdateRequest;
-	err := parseJSONRequest (r, &request, PROTOCOL_TASKUPDATE);
+	var request NetTaskHandleRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_TASKHANDLE);
 	if (err != nil) {
 		return err;
 	}		
-	
-	workerSecret := request.WorkerSecret;
-	
-	addLogMessage (session, fmt.Sprintf ("Updating task %s to status %s", uuid, request.Status), LOGTYPE_TASK_HANDLE, LOGLEVEL_CONSOLE);	
+
+	reply, err := handleTask (db, session, request.Name, request.Worker);
+	if (err != nil) {
+		return err;
+	}
+
+	reply.Protocol = PROTOCOL_TASKHANDLE;
+	reply.Version = PROTOCOL_VERSION;
+	return sendJSON (w, &reply);			
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// Finishes a task that is in process with a status and its results
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func updateTask (db *sql.DB, session * NetStorageSession, uuid string, workerSecret string, status string, results map[string]string) error {
+
+	transactionUUID := createUUID ();
+
+	addLogMessage (session, fmt.Sprintf ("Updating task %s (transaction %s)", uuid, transactionUUID), LOGTYPE_TASK_HANDLE, LOGLEVEL_DBONLY);	
+	addLogMessage (session, fmt.Sprintf ("Updating task %s to status %s", uuid, status), LOGTYPE_TASK_HANDLE, LOGLEVEL_CONSOLE);	
 	addLogMessage (session, fmt.Sprintf ("  Worker Secret: %s", workerSecret), LOGTYPE_TASK_UPDATE, LOGLEVEL_DBONLY);	
 	
-	jsondata, err := json.Marshal (&request.Results);
+	jsondata, err := json.Marshal (&results);
 	if (err != nil) {
 		return err;
 	}
@@ -267,7 +362,6 @@ func TaskUpdateHandler (db *sql.DB, session * NetStorageSession, w http.Response
 	
 	addLogMessage (session, fmt.Sprintf ("  Result JSON: %s", resultjson), LOGTYPE_TASK_UPDATE, LOGLEVEL_DBONLY);	
 	
-	status := request.Status;
 	if (status != "SUCCESS") && (status != "ERROR") && (status != "CANCELED") && (status != "RETURNED") {
 		return errors.New ("Invalid status string: " + status);
 	}
@@ -316,7 +410,30 @@ func TaskUpdateHandler (db *sql.DB, session * NetStorageSession, w http.Response
 	if (foundtransaction != transactionUUID) {
 		return errors.New ("Could not update job: " + uuid );
 	}
-			
+	
+	rows.Close();
+	
+	return recordChange (db, CHANGEOBJECT_TASK, uuid, CHANGETYPE_UPDATE);
+}
+
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+// Updates the status of a task
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func TaskUpdateHandler (db *sql.DB, session * NetStorageSession, w http.ResponseWriter, r *http.Request, uuid string) error {
+
+	// Parse JSON request
+	var request NetTaskUpdateRequest;
+	err := parseJSONRequest (r, &request, PROTOCOL_TASKUPDATE);
+	if (err != nil) {
+		return err;
+	}		
+	
+	err = updateTask (db, session, uuid, request.WorkerSecret, request.Status, request.Results);
+	if (err != nil) {
+		return err;
+	}
 		
 	var reply NetTaskUpdateReply;
 	reply.Protocol = PROTOCOL_TASKUPDATE;
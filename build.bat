@@ -3,8 +3,11 @@ if exist Bin\NetfabbStorageServer.exe del Bin\NetfabbStorageServer.exe
 if exist Bin\NetfabbTaskServer.exe del Bin\NetfabbTaskServer.exe
 if exist Bin\NetfabbApplicationServer.exe del Bin\NetfabbApplicationServer.exe
 
+echo Installing required GO packages
+go get github.com/mattn/go-sqlite3 github.com/twinj/uuid github.com/klauspost/compress/zstd@v1.18.0 golang.org/x/sys/windows/...
+
 echo Building Application Server
-go build -o Bin/NetfabbApplicationServer.exe Source/netfabbapplicationserver.go Source/netfabbstorage_db.go Source/netfabbstorage_types.go Source/netfabbstorage_utils.go  Source/netfabbstorage_config.go Source/netfabbstorage_auth.go Source/netfabbstorage_orm.go Source/netfabbstorage_data.go Source/netfabbtask_handler.go Source/netfabbtask_pan.go Source/netfabbapplication.go 
+go build -o Bin/NetfabbApplicationServer.exe Source/netfabbapplicationserver.go Source/netfabbstorage_db.go Source/netfabbstorage_types.go Source/netfabbstorage_utils.go  Source/netfabbstorage_config.go Source/netfabbstorage_auth.go Source/netfabbstorage_orm.go Source/netfabbstorage_data.go Source/netfabbstorage_schema.go Source/netfabbstorage_uploads.go Source/netfabbstorage_blobs.go Source/netfabbstorage_compression.go Source/netfabbstorage_backend.go Source/netfabbstorage_backend_s3.go Source/netfabbstorage_trash.go Source/netfabbstorage_search.go Source/netfabbstorage_listing.go Source/netfabbstorage_batch.go Source/netfabbstorage_properties.go Source/netfabbstorage_comments.go Source/netfabbstorage_changes.go Source/netfabbstorage_events.go Source/netfabbstorage_hubs.go Source/netfabbstorage_locks.go Source/netfabbstorage_quotas.go Source/netfabbstorage_garbage.go Source/netfabbstorage_integrity.go Source/netfabbstorage_stl.go Source/netfabbstorage_3mf.go Source/netfabbstorage_thumbnails.go Source/netfabbstorage_render.go Source/netfabbstorage_analysis.go Source/netfabbstorage_obj.go Source/netfabbstorage_convert.go Source/netfabbtask_handler.go Source/netfabbtask_pan.go Source/netfabbapplication.go 
 
 echo Building Application Service
-go build -o Bin/NetfabbApplicationService.exe Source/netfabbapplicationservice.go Source/netfabbstorage_db.go Source/netfabbstorage_types.go Source/netfabbstorage_utils.go  Source/netfabbstorage_config.go Source/netfabbstorage_auth.go Source/netfabbstorage_orm.go Source/netfabbstorage_data.go Source/netfabbtask_handler.go Source/netfabbtask_pan.go Source/netfabbapplication.go Source/service.go
+go build -o Bin/NetfabbApplicationService.exe Source/netfabbapplicationservice.go Source/netfabbstorage_db.go Source/netfabbstorage_types.go Source/netfabbstorage_utils.go  Source/netfabbstorage_config.go Source/netfabbstorage_auth.go Source/netfabbstorage_orm.go Source/netfabbstorage_data.go Source/netfabbstorage_schema.go Source/netfabbstorage_uploads.go Source/netfabbstorage_blobs.go Source/netfabbstorage_compression.go Source/netfabbstorage_backend.go Source/netfabbstorage_backend_s3.go Source/netfabbstorage_trash.go Source/netfabbstorage_search.go Source/netfabbstorage_listing.go Source/netfabbstorage_batch.go Source/netfabbstorage_properties.go Source/netfabbstorage_comments.go Source/netfabbstorage_changes.go Source/netfabbstorage_events.go Source/netfabbstorage_hubs.go Source/netfabbstorage_locks.go Source/netfabbstorage_quotas.go Source/netfabbstorage_garbage.go Source/netfabbstorage_integrity.go Source/netfabbstorage_stl.go Source/netfabbstorage_3mf.go Source/netfabbstorage_thumbnails.go Source/netfabbstorage_render.go Source/netfabbstorage_analysis.go Source/netfabbstorage_obj.go Source/netfabbstorage_convert.go Source/netfabbtask_handler.go Source/netfabbtask_pan.go Source/netfabbapplication.go Source/service.go
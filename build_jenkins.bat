@@ -16,22 +16,42 @@ set GOEXE=C:\Go\bin\go.exe
 :: GO Package Settings
 set PackageGoSqlite=github.com/mattn/go-sqlite3
 set PackageGoUuid=github.com/twinj/uuid
+set PackageGoCompress=github.com/klauspost/compress/zstd@v1.18.0
+set PackageGoSys=golang.org/x/sys/windows/...
 
 :: GO File Lists
-set common_source=netfabbstorage_db.go netfabbstorage_types.go netfabbstorage_utils.go netfabbstorage_auth.go netfabbstorage_orm.go netfabbstorage_data.go netfabbtask_handler.go netfabbapplication.go
+set common_source=netfabbstorage_db.go netfabbstorage_types.go netfabbstorage_utils.go netfabbstorage_auth.go netfabbstorage_orm.go netfabbstorage_data.go netfabbstorage_schema.go netfabbstorage_uploads.go netfabbstorage_blobs.go netfabbstorage_compression.go netfabbstorage_backend.go netfabbstorage_backend_s3.go netfabbstorage_trash.go netfabbstorage_search.go netfabbstorage_listing.go netfabbstorage_batch.go netfabbstorage_properties.go netfabbstorage_comments.go netfabbstorage_changes.go netfabbstorage_events.go netfabbstorage_hubs.go netfabbstorage_locks.go netfabbstorage_quotas.go netfabbstorage_garbage.go netfabbstorage_integrity.go netfabbstorage_stl.go netfabbstorage_3mf.go netfabbstorage_thumbnails.go netfabbstorage_render.go netfabbstorage_analysis.go netfabbstorage_obj.go netfabbstorage_convert.go netfabbtask_handler.go netfabbapplication.go
 set taskserver_source=netfabbtaskserver.go netfabbtask_config.go 
 set applicationserver_source=netfabbapplicationserver.go netfabbstorage_config.go
 set applicationservice_source=netfabbapplicationservice.go netfabbstorage_config.go service.go
+set backendtest_source=netfabbstorage_backend_test.go netfabbstorage_backend.go netfabbstorage_backend_s3.go netfabbstorage_config.go
+set meshtest_source=netfabbstorage_convert_test.go netfabbstorage_stl_test.go
 
-::echo Install required GO packages
-::go get %PackageGoSqlite% %PackageGoUuid%
+echo Install required GO packages
+call %GOEXE% get %PackageGoSqlite% %PackageGoUuid% %PackageGoCompress% %PackageGoSys%
 if %errorlevel% == 0 (
-  goto buildServers
+  goto testBackends
 ) else (
   goto END
 )
 
 
+:testBackends
+cd /d %Sourcepath%
+echo Testing Storage Backends
+call %GOEXE% test %backendtest_source% || goto END
+cd /d %Scriptpath%
+goto testMeshes
+
+
+:testMeshes
+cd /d %Sourcepath%
+echo Testing Mesh Sources
+call %GOEXE% test %meshtest_source% %applicationserver_source% %common_source% || goto END
+cd /d %Scriptpath%
+goto buildServers
+
+
 :buildServers
 cd /d %Binpath%
 if exist NetfabbApplicationServer.exe del NetfabbApplicationServer.exe